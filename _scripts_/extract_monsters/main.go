@@ -8,16 +8,21 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"image/color"
 	"io/ioutil"
 	"log"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 
+	"github.com/mewkiz/pkg/imgutil"
 	"github.com/mewkiz/pkg/pathutil"
 	"github.com/mewkiz/pkg/term"
 	"github.com/pkg/errors"
+	"github.com/sanctuary/ember/asset"
+	"github.com/sanctuary/ember/gfx"
+	"github.com/sanctuary/ember/vorbis"
+	"github.com/sanctuary/ember/wav"
 	"github.com/sanctuary/exp/d1"
 )
 
@@ -41,6 +46,8 @@ Flags:
 
 // Global command line flags.
 var (
+	// assets specifies the game assets of the extracted MPQ archives.
+	assets *asset.Resolver
 	// def specifies whether to extract monster definitions.
 	def bool
 	// graphics specifies whether to extract monster graphics.
@@ -52,10 +59,18 @@ var (
 func main() {
 	// Parse command line arguments.
 	var (
+		// assetDir specifies the path to the directory containing the extracted
+		// MPQ archives (e.g. "diabdat" and "hellfire").
+		assetDir string
+		// mpqList specifies a comma-separated list of MPQ archives to read
+		// directly, without extraction.
+		mpqList string
 		// quiet specifies whether to suppress non-error messages.
 		quiet bool
 	)
 	flag.Usage = usage
+	flag.StringVar(&assetDir, "assetdir", ".", `path to directory containing extracted MPQ archives (e.g. "diabdat" and "hellfire")`)
+	flag.StringVar(&mpqList, "mpq", "", `comma-separated list of MPQ archives to read directly (e.g. "diabdat.mpq,hellfire.mpq"); overrides -assetdir`)
 	flag.BoolVar(&def, "def", false, "extract monster definitions")
 	flag.BoolVar(&graphics, "graphics", false, "extract monster graphics")
 	flag.BoolVar(&quiet, "q", false, "suppress non-error messages")
@@ -70,6 +85,14 @@ func main() {
 	if quiet {
 		dbg.SetOutput(ioutil.Discard)
 	}
+	var err error
+	assets, err = asset.Open(assetDir, mpqList)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if assets.Spawn() {
+		dbg.Println("using shareware spawn.mpq; skipping monsters missing from the MPQ archive.")
+	}
 
 	// Extract monster assets from diablo.exe.
 	if err := extract(exePath); err != nil {
@@ -83,7 +106,6 @@ func extract(exePath string) error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	fmt.Println("#!/bin/bash")
 	for _, monster := range exe.Monsters {
 		if err := extractMonster(monster); err != nil {
 			return errors.WithStack(err)
@@ -94,6 +116,12 @@ func extract(exePath string) error {
 
 // extractMonster extracts the assets of the given monster.
 func extractMonster(monster d1.MonsterData) error {
+	// Skip monster if graphics are missing from the MPQ archives (e.g. most
+	// monsters of the shareware spawn.mpq).
+	if assets.Spawn() && !hasMonsterGraphics(monster) {
+		dbg.Printf("skipping %q; unable to locate graphics.", monster.Name)
+		return nil
+	}
 	dbg.Printf("extracting assets of %q.", monster.Name)
 	// Extract monster graphics.
 	if graphics {
@@ -117,15 +145,64 @@ func extractMonster(monster d1.MonsterData) error {
 
 // extractMonsterGraphics extracts the graphics of the given monster.
 func extractMonsterGraphics(monster d1.MonsterData) error {
-	switch monster.Name {
-	case "Wyrm", "Cave Slug", "Devil Wyrm", "Devourer":
-		// TODO: Try to locate the Wyrm monster graphics in another MPQ archive
-		// than diabdat.mpq.
-
-		// Skip monster; Wyrm monster graphics missing from diabdat.mpq.
+	// Skip monster if graphics are missing from the MPQ archives (e.g. Wyrm
+	// monster graphics missing from diabdat.mpq).
+	if !hasMonsterGraphics(monster) {
+		dbg.Printf("skipping graphics of %q; unable to locate graphics.", monster.Name)
 		return nil
 	}
 
+	actions, relCL2Paths := monsterGraphics(monster)
+	const ndirs = 8
+	rows := make([][]*gfx.Image, ndirs)
+	for i, action := range actions {
+		relCL2Path := relCL2Paths[i]
+		if !assets.Exists(relCL2Path) {
+			// Skip action; graphics missing from the MPQ archives (e.g.
+			// bigfall special action graphics missing from diabdat.mpq).
+			dbg.Printf("skipping %s action of %q; unable to locate %q.", action, monster.Name, relCL2Path)
+			continue
+		}
+		buf, err := assets.ReadFile(relCL2Path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		groups, err := gfx.DecodeCL2(buf, int(monster.FrameWidth))
+		if err != nil {
+			return errors.Wrapf(err, "unable to decode %q", relCL2Path)
+		}
+		for i := range rows {
+			direction := (2 + i) % ndirs
+			if len(groups) == 1 {
+				// Golem has only one direction for die and special actions.
+				direction = 0
+			}
+			if direction >= len(groups) {
+				return errors.Errorf("invalid number of directions in %q; expected %d, got %d", relCL2Path, ndirs, len(groups))
+			}
+			rows[i] = append(rows[i], groups[direction]...)
+		}
+	}
+	pal, err := monsterPalette(monster)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	sheet := gfx.SpriteSheet(rows, int(monster.FrameWidth), 0)
+	dstName := monsterName(monster)
+	dstPath := fmt.Sprintf("../mods/tristram/images/monster/%s.png", dstName)
+	dbg.Printf("extracting graphics of %q to %q.", monster.Name, dstPath)
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := imgutil.WriteFile(dstPath, sheet.Render(pal)); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// monsterGraphics returns the actions of the given monster with graphics in the
+// original game, and the paths of their CL2 graphics.
+func monsterGraphics(monster d1.MonsterData) ([]d1.MonsterAction, []string) {
 	actions := []d1.MonsterAction{
 		d1.MonsterActionStand,
 		d1.MonsterActionWalk,
@@ -136,77 +213,78 @@ func extractMonsterGraphics(monster d1.MonsterData) error {
 	if monster.HasSpecialGraphic {
 		actions = append(actions, d1.MonsterActionSpecial)
 	}
-	// # Spitting Terror
-	//
-	//    montage \
-	//       _dump_/monsters/acid/acid{a,d,h,n,s,w}/*_2/*.png \
-	//       _dump_/monsters/acid/acid{a,d,h,n,s,w}/*_3/*.png \
-	//       _dump_/monsters/acid/acid{a,d,h,n,s,w}/*_4/*.png \
-	//       _dump_/monsters/acid/acid{a,d,h,n,s,w}/*_5/*.png \
-	//       _dump_/monsters/acid/acid{a,d,h,n,s,w}/*_6/*.png \
-	//       _dump_/monsters/acid/acid{a,d,h,n,s,w}/*_7/*.png \
-	//       _dump_/monsters/acid/acid{a,d,h,n,s,w}/*_0/*.png \
-	//       _dump_/monsters/acid/acid{a,d,h,n,s,w}/*_1/*.png \
-	//       -geometry +0+0 -tile x8 \
-	//       -background none \
-	//       ../mods/tristram/images/enemies/spitting_terror.png
-	script := &bytes.Buffer{}
-	fmt.Fprintf(script, "echo 'Extracting graphics for %s'\n", monster.Name)
-	script.WriteString("montage \\\n")
-	for i := 0; i < 8; i++ {
-		direction := (2 + i) % 8
-		for _, action := range actions {
-			format := strings.ToLower(monster.CL2Path)
-			format = strings.Replace(format, `\`, "/", -1)
-			relCL2Path := fmt.Sprintf(format, action.Rune())
-			relCL2Dir := pathutil.TrimExt(relCL2Path)
-			trnDir := ""
-			if monster.HasTrn {
-				relTrnPath := strings.ToLower(monster.TrnPath)
-				relTrnPath = strings.Replace(relTrnPath, `\`, "/", -1)
-				dbg.Printf("using colour transition: %q.", relTrnPath)
-				trnDir = fmt.Sprintf("%s/", path.Base(relTrnPath))
-			}
-			switch relCL2Dir {
-			case "monsters/darkmage/dmagew":
-				// Skip action; darkmage has no walk animation.
-				continue
-			case "monsters/bigfall/fallgs":
-				// TODO: Try to locate the bigfall special action graphics in
-				// another MPQ archive than diabdat.mpq.
+	format := monsterCL2Format(monster)
+	var (
+		present     []d1.MonsterAction
+		relCL2Paths []string
+	)
+	for _, action := range actions {
+		relCL2Path := fmt.Sprintf(format, action.Rune())
+		switch pathutil.TrimExt(relCL2Path) {
+		case "monsters/darkmage/dmagew":
+			// Skip action; darkmage has no walk animation.
+			continue
+		case "monsters/golem/golemn", "monsters/golem/golemh":
+			// Skip actions; golem has no stand or hit animation.
+			continue
+		}
+		present = append(present, action)
+		relCL2Paths = append(relCL2Paths, relCL2Path)
+	}
+	return present, relCL2Paths
+}
 
-				// Skip action; bigfall special action graphics missing from
-				// diabdat.mpq.
-				continue
-			case "monsters/golem/golemn", "monsters/golem/golemh":
-				// Skip actions; golem has no stand, hit or foo animation.
-				continue
-			}
-			if relCL2Dir == "monsters/golem/golemd" || relCL2Dir == "monsters/golem/golems" {
-				// Golem has only one direction for die and special actions.
-				relPngPath := fmt.Sprintf("%s/%s*.png", relCL2Dir, trnDir)
-				pngPath := filepath.Join("_dump_", relPngPath)
-				fmt.Fprintf(script, "\t%s \\\n", pngPath)
-				break
-			}
-			name := path.Base(relCL2Dir)
-			relPngPath := fmt.Sprintf("%s/%s%s_%d/*.png", relCL2Dir, trnDir, name, direction)
-			pngPath := filepath.Join("_dump_", relPngPath)
-			fmt.Fprintf(script, "\t%s \\\n", pngPath)
+// hasMonsterGraphics reports whether the graphics of any action of the given
+// monster are present in the MPQ archives.
+func hasMonsterGraphics(monster d1.MonsterData) bool {
+	_, relCL2Paths := monsterGraphics(monster)
+	for _, relCL2Path := range relCL2Paths {
+		if assets.Exists(relCL2Path) {
+			return true
 		}
 	}
-	fmt.Fprintf(script, "\t-gravity south -geometry %dx+0+0 \\\n", monster.FrameWidth)
-	script.WriteString("\t-tile x8 \\\n")
-	script.WriteString("\t-background none \\\n")
-	dstName := monsterName(monster)
-	dstPath := fmt.Sprintf("../mods/tristram/images/monster/%s.png", dstName)
-	fmt.Fprintf(script, "\t%s", dstPath)
+	return false
+}
 
-	fmt.Println(script)
-	return nil
+// monsterCL2Format returns the path format of the CL2 graphics of the given
+// monster, with %c replaced by the action rune (e.g.
+// "monsters/acid/acid%c.cl2").
+func monsterCL2Format(monster d1.MonsterData) string {
+	format := strings.ToLower(monster.CL2Path)
+	return strings.Replace(format, `\`, "/", -1)
 }
 
-// extractMonsterSounds extracts the sounds of the given monster.
+// monsterPalette returns the palette of the given monster, with colours
+// translated by the colour translation of the monster if present.
+func monsterPalette(monster d1.MonsterData) (color.Palette, error) {
+	const palPath = "levels/towndata/town.pal"
+	buf, err := assets.ReadFile(palPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pal, err := gfx.ParsePalette(buf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse palette %q", palPath)
+	}
+	if !monster.HasTrn {
+		return pal, nil
+	}
+	relTrnPath := strings.ToLower(monster.TrnPath)
+	relTrnPath = strings.Replace(relTrnPath, `\`, "/", -1)
+	dbg.Printf("using colour transition: %q.", relTrnPath)
+	trn, err := assets.ReadFile(relTrnPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pal, err = gfx.Translate(pal, trn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to apply colour translation %q", relTrnPath)
+	}
+	return pal, nil
+}
+
+// extractMonsterSounds extracts the sounds of the given monster, converting
+// them from WAV to Ogg.
 func extractMonsterSounds(monster d1.MonsterData) error {
 	actions := []d1.MonsterAction{
 		//d1.MonsterActionStand,
@@ -218,29 +296,58 @@ func extractMonsterSounds(monster d1.MonsterData) error {
 	if monster.HasSpecialSound {
 		actions = append(actions, d1.MonsterActionSpecial)
 	}
-	script := &bytes.Buffer{}
-	fmt.Fprintf(script, "echo 'Extracting sounds for %s'\n", monster.Name)
+	dbg.Printf("extracting sounds of %q.", monster.Name)
 	// # Spitting Terror
 	//
-	//    ffmpeg -loglevel error -y -i diabdat/monsters/acid/acida1.wav ../mods/tristram/sounds/monster/spitting_terror_attack_1.ogg
-	//    ffmpeg -loglevel error -y -i diabdat/monsters/acid/acida2.wav ../mods/tristram/sounds/monster/spitting_terror_attack_2.ogg
-	//    ffmpeg -loglevel error -y -i diabdat/monsters/acid/acidh1.wav ../mods/tristram/sounds/monster/spitting_terror_hit_1.ogg
-	//    ffmpeg -loglevel error -y -i diabdat/monsters/acid/acidh2.wav ../mods/tristram/sounds/monster/spitting_terror_hit_2.ogg
-	//    ffmpeg -loglevel error -y -i diabdat/monsters/acid/acidd1.wav ../mods/tristram/sounds/monster/spitting_terror_die_1.ogg
-	//    ffmpeg -loglevel error -y -i diabdat/monsters/acid/acidd2.wav ../mods/tristram/sounds/monster/spitting_terror_die_2.ogg
-	//    ffmpeg -loglevel error -y -i diabdat/monsters/acid/acids1.wav ../mods/tristram/sounds/monster/spitting_terror_special_1.ogg
-	//    ffmpeg -loglevel error -y -i diabdat/monsters/acid/acids2.wav ../mods/tristram/sounds/monster/spitting_terror_special_2.ogg
+	//    diabdat/monsters/acid/acida1.wav -> ../mods/tristram/sounds/monster/spitting_terror_attack_1.ogg
+	//    diabdat/monsters/acid/acida2.wav -> ../mods/tristram/sounds/monster/spitting_terror_attack_2.ogg
+	//    diabdat/monsters/acid/acidh1.wav -> ../mods/tristram/sounds/monster/spitting_terror_hit_1.ogg
+	//    diabdat/monsters/acid/acidh2.wav -> ../mods/tristram/sounds/monster/spitting_terror_hit_2.ogg
+	//    diabdat/monsters/acid/acidd1.wav -> ../mods/tristram/sounds/monster/spitting_terror_die_1.ogg
+	//    diabdat/monsters/acid/acidd2.wav -> ../mods/tristram/sounds/monster/spitting_terror_die_2.ogg
+	//    diabdat/monsters/acid/acids1.wav -> ../mods/tristram/sounds/monster/spitting_terror_special_1.ogg
+	//    diabdat/monsters/acid/acids2.wav -> ../mods/tristram/sounds/monster/spitting_terror_special_2.ogg
 	for _, action := range actions {
 		for i := 1; i <= 2; i++ {
 			format := strings.ToLower(monster.WavPath)
 			format = strings.Replace(format, `\`, "/", -1)
 			format = strings.Replace(format, "%i", "%d", -1)
 			relWavPath := fmt.Sprintf(format, action.Rune(), i)
-			wavPath := filepath.Join("diabdat", relWavPath)
-			fmt.Fprintf(script, "ffmpeg -loglevel error -y -i %s ../mods/tristram/sounds/monster/%s_%s_%d.ogg\n", wavPath, monsterName(monster), action.String(), i)
+			if !assets.Exists(relWavPath) {
+				// Skip sound; missing from the MPQ archives.
+				dbg.Printf("skipping sound %q of %q; unable to locate file.", relWavPath, monster.Name)
+				continue
+			}
+			oggPath := fmt.Sprintf("../mods/tristram/sounds/monster/%s_%s_%d.ogg", monsterName(monster), action.String(), i)
+			if err := convertSound(oggPath, relWavPath); err != nil {
+				return errors.WithStack(err)
+			}
 		}
 	}
-	fmt.Println(script)
+	return nil
+}
+
+// convertSound converts the given WAV sound of the game assets to Ogg, storing
+// the Ogg file at oggPath.
+func convertSound(oggPath, relWavPath string) error {
+	buf, err := assets.ReadFile(relWavPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	snd, err := wav.DecodeBytes(buf)
+	if err != nil {
+		return errors.Wrapf(err, "unable to decode %q", relWavPath)
+	}
+	ogg := &bytes.Buffer{}
+	if err := vorbis.Encode(ogg, snd.Samples, snd.NChannels, snd.SampleRate, nil); err != nil {
+		return errors.Wrapf(err, "unable to encode %q", oggPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(oggPath), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ioutil.WriteFile(oggPath, ogg.Bytes(), 0644); err != nil {
+		return errors.WithStack(err)
+	}
 	return nil
 }
 
@@ -0,0 +1,179 @@
+// The extract_sounds tool extracts the sound effects of the Diablo 1 game (e.g.
+// items, doors, chests, shrines, spells, towners and hero speech), converting
+// them from WAV to Ogg.
+//
+// The sound effects are located by the sound effect table of diablo.exe, and an
+// index is generated mapping Diablo sound IDs to the paths of the converted
+// sound effects, relative to the FLARE mod directory.
+//
+// Note, this tool requires an original copy of diablo.exe. None of the Diablo 1
+// game assets are provided by this project.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mewkiz/pkg/term"
+	"github.com/pkg/errors"
+	"github.com/sanctuary/ember/asset"
+	"github.com/sanctuary/ember/vorbis"
+	"github.com/sanctuary/ember/wav"
+)
+
+// dbg represents a logger with the "extract_sounds:" prefix, which logs debug
+// messages to standard error.
+var dbg = log.New(os.Stderr, term.MagentaBold("extract_sounds:")+" ", 0)
+
+func usage() {
+	const use = `
+Extract the sound effects of the Diablo 1 game, converting them from WAV to Ogg.
+
+Usage:
+
+	extract_sounds [OPTION]... diablo.exe
+
+The sound effects of diablo.exe (e.g. "sfx/misc/walk1.wav") are stored in the
+"soundfx" directory of the mod (e.g. "../mods/ember/soundfx/misc/walk1.ogg"),
+together with an index (soundfx/index.txt) mapping Diablo sound IDs to the
+paths of the sound effects; one line per sound ID.
+
+	sound=0,soundfx/misc/walk1.ogg
+
+Sound effects missing from the MPQ archives (e.g. of the shareware spawn.mpq)
+are skipped.
+
+Examples:
+
+	# Extract sound effects of extracted MPQ archives.
+	extract_sounds diablo.exe
+
+	# Extract sound effects straight from the MPQ archives.
+	extract_sounds -mpq diabdat.mpq diablo.exe
+
+Flags:
+`
+	fmt.Fprint(os.Stderr, use[1:])
+	flag.PrintDefaults()
+}
+
+func main() {
+	// Parse command line arguments.
+	var (
+		// assetDir specifies the path to the directory containing the extracted
+		// MPQ archives (e.g. "diabdat" and "hellfire").
+		assetDir string
+		// mpqList specifies a comma-separated list of MPQ archives to read
+		// directly, without extraction.
+		mpqList string
+		// modDir specifies the path to the FLARE mod directory.
+		modDir string
+		// quiet specifies whether to suppress non-error messages.
+		quiet bool
+	)
+	flag.Usage = usage
+	flag.StringVar(&assetDir, "assetdir", ".", `path to directory containing extracted MPQ archives (e.g. "diabdat" and "hellfire")`)
+	flag.StringVar(&mpqList, "mpq", "", `comma-separated list of MPQ archives to read directly (e.g. "diabdat.mpq,hellfire.mpq"); overrides -assetdir`)
+	flag.StringVar(&modDir, "mod", "../mods/ember", "path to FLARE mod directory")
+	flag.BoolVar(&quiet, "q", false, "suppress non-error messages")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	exePath := flag.Arg(0)
+	// Mute debug messages if `-q` is set.
+	if quiet {
+		dbg.SetOutput(ioutil.Discard)
+	}
+	assets, err := asset.Open(assetDir, mpqList)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+
+	// Extract sound effects of diablo.exe.
+	sfxs, err := parseSoundEffects(exePath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	dbg.Printf("located %d sound effects in %q.", len(sfxs), exePath)
+	if err := extractSounds(assets, modDir, sfxs); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+// extractSounds extracts the given sound effects to the "soundfx" directory of
+// the mod, and writes the index of sound effects.
+func extractSounds(assets *asset.Resolver, modDir string, sfxs []soundEffect) error {
+	index := &bytes.Buffer{}
+	index.WriteString("# Sound effects of Diablo 1, as generated by extract_sounds; one line per\n")
+	index.WriteString("# Diablo sound ID (index into the sound effect table of diablo.exe).\n")
+	index.WriteString("#\n")
+	index.WriteString("#    sound=ID,PATH\n\n")
+	// Sound effects may be referred to by more than one sound ID.
+	done := make(map[string]bool)
+	for _, sfx := range sfxs {
+		relOggPath := soundPath(sfx)
+		if !done[relOggPath] {
+			if !assets.Exists(sfx.path) {
+				// Skip sound effect; missing from the MPQ archives.
+				dbg.Printf("skipping sound ID %d; unable to locate %q.", sfx.id, sfx.path)
+				continue
+			}
+			oggPath := filepath.Join(modDir, filepath.FromSlash(relOggPath))
+			dbg.Printf("extracting %q to %q.", sfx.path, oggPath)
+			if err := convertSound(assets, oggPath, sfx.path); err != nil {
+				return errors.WithStack(err)
+			}
+			done[relOggPath] = true
+		}
+		fmt.Fprintf(index, "sound=%d,%s\n", sfx.id, relOggPath)
+	}
+	indexPath := filepath.Join(modDir, "soundfx", "index.txt")
+	if err := os.MkdirAll(filepath.Dir(indexPath), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ioutil.WriteFile(indexPath, index.Bytes(), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// soundPath returns the path of the converted sound effect, relative to the mod
+// directory (e.g. "soundfx/misc/walk1.ogg" of "sfx/misc/walk1.wav").
+func soundPath(sfx soundEffect) string {
+	relPath := strings.TrimPrefix(sfx.path, "sfx/")
+	relPath = strings.TrimSuffix(relPath, path.Ext(relPath)) + ".ogg"
+	return path.Join("soundfx", relPath)
+}
+
+// convertSound converts the given WAV sound of the game assets to Ogg, storing
+// the Ogg file at oggPath.
+func convertSound(assets *asset.Resolver, oggPath, relWavPath string) error {
+	buf, err := assets.ReadFile(relWavPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	snd, err := wav.DecodeBytes(buf)
+	if err != nil {
+		return errors.Wrapf(err, "unable to decode %q", relWavPath)
+	}
+	ogg := &bytes.Buffer{}
+	if err := vorbis.Encode(ogg, snd.Samples, snd.NChannels, snd.SampleRate, nil); err != nil {
+		return errors.Wrapf(err, "unable to encode %q", oggPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(oggPath), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ioutil.WriteFile(oggPath, ogg.Bytes(), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A soundEffect is an entry of the sound effect table of diablo.exe.
+type soundEffect struct {
+	// Diablo sound ID; index into the sound effect table.
+	id int
+	// Sound flags (e.g. streamed, or hero speech of a given class).
+	flags uint8
+	// Path to the WAV file (e.g. "sfx/misc/walk1.wav").
+	path string
+}
+
+// Size in bytes of entries of the sound effect table.
+//
+//    struct TSFX {
+//       uint8_t  bFlags;
+//       char    *pszName;
+//       TSnd    *pSnd;    // NULL within the executable
+//    };
+const sfxEntrySize = 12
+
+// minSoundEffects specifies the minimum number of entries of the sound effect
+// table; shorter runs of entries are not considered.
+const minSoundEffects = 100
+
+// parseSoundEffects parses the sound effect table of the given diablo.exe
+// executable.
+//
+// The location of the table differs between versions of the game (e.g. 1.00,
+// 1.09 and spawn), and is therefore located by its contents; the table is the
+// longest run of entries within the data sections of the executable with a
+// name pointing to a WAV file path starting with "sfx\", and a nil sound
+// pointer.
+func parseSoundEffects(exePath string) ([]soundEffect, error) {
+	f, err := pe.Open(exePath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	opt, ok := f.OptionalHeader.(*pe.OptionalHeader32)
+	if !ok {
+		return nil, errors.Errorf("invalid executable %q; expected 32-bit PE", exePath)
+	}
+	img := &image{base: opt.ImageBase}
+	for _, sect := range f.Sections {
+		data, err := sect.Data()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		img.sects = append(img.sects, section{addr: sect.VirtualAddress, data: data})
+	}
+	var best []soundEffect
+	for _, sect := range img.sects {
+		for off := 0; off+sfxEntrySize <= len(sect.data); off += 4 {
+			sfxs := img.parseTable(sect.data[off:])
+			if len(sfxs) > len(best) {
+				best = sfxs
+			}
+			if len(sfxs) > 0 {
+				// Skip past parsed table.
+				off += len(sfxs)*sfxEntrySize - 4
+			}
+		}
+	}
+	if len(best) < minSoundEffects {
+		return nil, errors.Errorf("unable to locate sound effect table in %q", exePath)
+	}
+	return best, nil
+}
+
+// image is the memory image of an executable.
+type image struct {
+	// Image base address.
+	base uint32
+	// Sections of the image.
+	sects []section
+}
+
+// section is a section of an executable.
+type section struct {
+	// Relative virtual address of the section.
+	addr uint32
+	// Contents of the section.
+	data []byte
+}
+
+// parseTable parses the sound effect table entries at the start of the given
+// data, stopping at the first invalid entry.
+func (img *image) parseTable(data []byte) []soundEffect {
+	var sfxs []soundEffect
+	for len(data) >= sfxEntrySize {
+		flags := data[0]
+		namePtr := binary.LittleEndian.Uint32(data[4:])
+		sndPtr := binary.LittleEndian.Uint32(data[8:])
+		if sndPtr != 0 {
+			break
+		}
+		name, ok := img.cstring(namePtr)
+		if !ok {
+			break
+		}
+		path := strings.ToLower(strings.Replace(name, `\`, "/", -1))
+		if !strings.HasPrefix(path, "sfx/") || !strings.HasSuffix(path, ".wav") {
+			break
+		}
+		sfx := soundEffect{
+			id:    len(sfxs),
+			flags: flags,
+			path:  path,
+		}
+		sfxs = append(sfxs, sfx)
+		data = data[sfxEntrySize:]
+	}
+	return sfxs
+}
+
+// cstring returns the NULL-terminated string at the given virtual address.
+func (img *image) cstring(addr uint32) (string, bool) {
+	if addr < img.base {
+		return "", false
+	}
+	rva := addr - img.base
+	for _, sect := range img.sects {
+		if rva < sect.addr || rva-sect.addr >= uint32(len(sect.data)) {
+			continue
+		}
+		data := sect.data[rva-sect.addr:]
+		end := bytes.IndexByte(data, 0)
+		if end == -1 {
+			return "", false
+		}
+		return string(data[:end]), true
+	}
+	return "", false
+}
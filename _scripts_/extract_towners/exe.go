@@ -0,0 +1,85 @@
+package main
+
+import (
+	"debug/pe"
+
+	"github.com/pkg/errors"
+)
+
+// Dimensions of the animation order table of diablo.exe; one row of frame
+// numbers (1-based) per towner with a custom animation order, terminated by -1
+// and padded with zeros.
+//
+//    char AnimOrder[6][148];
+const (
+	nAnimOrders  = 6
+	animOrderLen = 148
+)
+
+// parseAnimOrders parses the animation order table of the given diablo.exe
+// executable, returning the frame numbers (1-based) of each row.
+//
+// The location of the table differs between versions of the game (e.g. 1.00,
+// 1.09 and spawn), and is therefore located by its contents; the table is the
+// first run of rows within the sections of the executable, each consisting of
+// frame numbers terminated by -1 and padded with zeros.
+//
+// ref: AnimOrder
+func parseAnimOrders(exePath string) ([][]int, error) {
+	f, err := pe.Open(exePath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	for _, sect := range f.Sections {
+		data, err := sect.Data()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for off := 0; off+nAnimOrders*animOrderLen <= len(data); off++ {
+			if orders, ok := parseAnimOrderTable(data[off:]); ok {
+				return orders, nil
+			}
+		}
+	}
+	return nil, errors.Errorf("unable to locate animation order table in %q", exePath)
+}
+
+// parseAnimOrderTable parses the animation order table at the start of the
+// given data.
+func parseAnimOrderTable(data []byte) ([][]int, bool) {
+	var orders [][]int
+	for i := 0; i < nAnimOrders; i++ {
+		order, ok := parseAnimOrder(data[i*animOrderLen : (i+1)*animOrderLen])
+		if !ok {
+			return nil, false
+		}
+		orders = append(orders, order)
+	}
+	return orders, true
+}
+
+// maxAnimFrame specifies the highest frame number of animation orders.
+const maxAnimFrame = 32
+
+// parseAnimOrder parses the given row of the animation order table.
+func parseAnimOrder(row []byte) ([]int, bool) {
+	var order []int
+	for i, b := range row {
+		switch {
+		case b >= 1 && b <= maxAnimFrame:
+			order = append(order, int(b))
+		case b == 0xFF && len(order) > 1:
+			// Frame numbers terminated by -1, followed by zero padding.
+			for _, pad := range row[i+1:] {
+				if pad != 0 {
+					return nil, false
+				}
+			}
+			return order, true
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
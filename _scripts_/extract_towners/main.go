@@ -0,0 +1,318 @@
+// The extract_towners tool extracts the inhabitants of Tristram (e.g.
+// Griswold, Pepin, Ogden, Adria, Cain, Farnham, Gillian, Wirt and the cows)
+// from the Diablo 1 game, generating FLARE NPC definitions, animations and
+// spawn entries of the Tristram map.
+//
+// The towners are placed at their original locations in Tristram, and animated
+// in the frame order of the animation order table of diablo.exe.
+//
+// Note, this tool requires an original copy of diablo.exe. None of the Diablo 1
+// game assets are provided by this project.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mewkiz/pkg/imgutil"
+	"github.com/mewkiz/pkg/term"
+	"github.com/pkg/errors"
+	"github.com/sanctuary/ember/asset"
+	"github.com/sanctuary/ember/gfx"
+)
+
+// dbg represents a logger with the "extract_towners:" prefix, which logs debug
+// messages to standard error.
+var dbg = log.New(os.Stderr, term.MagentaBold("extract_towners:")+" ", 0)
+
+func usage() {
+	const use = `
+Extract the inhabitants of Tristram from the Diablo 1 game, generating FLARE NPC
+definitions, animations and spawn entries.
+
+Usage:
+
+	extract_towners [OPTION]... diablo.exe
+
+The following files are generated for each towner (e.g. Griswold) in the mod
+directory.
+
+	npcs/griswold.txt               NPC definition
+	animations/npcs/griswold.txt    animations
+	images/npcs/griswold.png        sprite sheet
+
+The NPC sections of maps/tristram.txt are replaced by spawn entries of the
+towners, at their original locations in Tristram.
+
+	[npc]
+	# Griswold the Blacksmith
+	type=npc
+	location=62,63,1,1
+	filename=npcs/griswold.txt
+
+Towners missing from the MPQ archives are skipped.
+
+Examples:
+
+	# Extract towners of extracted MPQ archives.
+	extract_towners diablo.exe
+
+	# Extract towners straight from the MPQ archives.
+	extract_towners -mpq diabdat.mpq diablo.exe
+
+Flags:
+`
+	fmt.Fprint(os.Stderr, use[1:])
+	flag.PrintDefaults()
+}
+
+func main() {
+	// Parse command line arguments.
+	var (
+		// assetDir specifies the path to the directory containing the extracted
+		// MPQ archives (e.g. "diabdat" and "hellfire").
+		assetDir string
+		// mpqList specifies a comma-separated list of MPQ archives to read
+		// directly, without extraction.
+		mpqList string
+		// modDir specifies the path to the FLARE mod directory.
+		modDir string
+		// quiet specifies whether to suppress non-error messages.
+		quiet bool
+	)
+	flag.Usage = usage
+	flag.StringVar(&assetDir, "assetdir", ".", `path to directory containing extracted MPQ archives (e.g. "diabdat" and "hellfire")`)
+	flag.StringVar(&mpqList, "mpq", "", `comma-separated list of MPQ archives to read directly (e.g. "diabdat.mpq,hellfire.mpq"); overrides -assetdir`)
+	flag.StringVar(&modDir, "mod", "../mods/ember", "path to FLARE mod directory")
+	flag.BoolVar(&quiet, "q", false, "suppress non-error messages")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	exePath := flag.Arg(0)
+	// Mute debug messages if `-q` is set.
+	if quiet {
+		dbg.SetOutput(ioutil.Discard)
+	}
+	assets, err := asset.Open(assetDir, mpqList)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+
+	// Extract towners of diablo.exe.
+	animOrders, err := parseAnimOrders(exePath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if err := extractTowners(assets, modDir, animOrders); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+// extractTowners extracts the towners of Tristram to the given mod directory,
+// and adds their spawn entries to the Tristram map.
+func extractTowners(assets *asset.Resolver, modDir string, animOrders [][]int) error {
+	const palPath = "levels/towndata/town.pal"
+	buf, err := assets.ReadFile(palPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	pal, err := gfx.ParsePalette(buf)
+	if err != nil {
+		return errors.Wrapf(err, "unable to parse palette %q", palPath)
+	}
+	// Graphics and animations may be shared by more than one towner (e.g. the
+	// cows).
+	done := make(map[string]bool)
+	var spawned []towner
+	for _, t := range towners {
+		if !assets.Exists(t.celPath) {
+			// Skip towner; graphics missing from the MPQ archives.
+			dbg.Printf("skipping %q; unable to locate %q.", t.title, t.celPath)
+			continue
+		}
+		if !done[t.gfx] {
+			dbg.Printf("extracting graphics of %q.", t.title)
+			rows, err := townerRows(assets, t)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			sheet := gfx.SpriteSheet(rows, 0, 0)
+			pngPath := filepath.Join(modDir, "images", "npcs", t.gfx+".png")
+			if err := os.MkdirAll(filepath.Dir(pngPath), 0755); err != nil {
+				return errors.WithStack(err)
+			}
+			if err := imgutil.WriteFile(pngPath, sheet.Render(pal)); err != nil {
+				return errors.WithStack(err)
+			}
+			anim, err := townerAnim(t, rows, animOrders)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			animPath := filepath.Join(modDir, "animations", "npcs", t.gfx+".txt")
+			if err := writeFile(animPath, anim); err != nil {
+				return errors.WithStack(err)
+			}
+			done[t.gfx] = true
+		}
+		npcPath := filepath.Join(modDir, "npcs", t.name+".txt")
+		if err := writeFile(npcPath, townerDef(t)); err != nil {
+			return errors.WithStack(err)
+		}
+		spawned = append(spawned, t)
+	}
+	mapPath := filepath.Join(modDir, "maps", "tristram.txt")
+	dbg.Printf("adding %d towners to %q.", len(spawned), mapPath)
+	if err := updateMap(mapPath, spawned); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// townerRows returns the frames of the given towner, with one row per FLARE
+// direction for towners with graphics of each direction, and a single row
+// otherwise.
+func townerRows(assets *asset.Resolver, t towner) ([][]*gfx.Image, error) {
+	buf, err := assets.ReadFile(t.celPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	groups, err := gfx.DecodeCELGroups(buf, t.width)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decode %q", t.celPath)
+	}
+	if t.dir == -1 {
+		return groups[:1], nil
+	}
+	const ndirs = 8
+	if len(groups) != ndirs {
+		return nil, errors.Errorf("invalid number of directions in %q; expected %d, got %d", t.celPath, ndirs, len(groups))
+	}
+	rows := make([][]*gfx.Image, ndirs)
+	for i := range rows {
+		// The first row faces west (direction 2 of Diablo 1).
+		rows[i] = groups[(2+i)%ndirs]
+	}
+	return rows, nil
+}
+
+// townerAnim returns the animation definition of the given towner, based on the
+// frames of its sprite sheet. Each frame of the animation refers to a cell of
+// the sprite sheet, as the animation order of diablo.exe may repeat frames.
+func townerAnim(t towner, rows [][]*gfx.Image, animOrders [][]int) ([]byte, error) {
+	// Cell dimensions of the sprite sheet, as computed by gfx.SpriteSheet.
+	cellWidth, cellHeight := 0, 0
+	for _, row := range rows {
+		for _, frame := range row {
+			if frame.Width > cellWidth {
+				cellWidth = frame.Width
+			}
+			if frame.Height > cellHeight {
+				cellHeight = frame.Height
+			}
+		}
+	}
+	nframes := len(rows[0])
+	// Frame numbers (1-based) in play order.
+	var order []int
+	if t.animOrder == -1 {
+		for frame := 1; frame <= nframes; frame++ {
+			order = append(order, frame)
+		}
+	} else {
+		if t.animOrder >= len(animOrders) {
+			return nil, errors.Errorf("invalid animation order %d of %q; expected < %d", t.animOrder, t.title, len(animOrders))
+		}
+		order = animOrders[t.animOrder]
+	}
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "image=images/npcs/%s.png\n", t.gfx)
+	buf.WriteString("\n")
+	buf.WriteString("[stance]\n")
+	fmt.Fprintf(buf, "frames=%d\n", len(order))
+	// Diablo 1 runs at 20 FPS; thus 50ms per game tick.
+	fmt.Fprintf(buf, "duration=%dms\n", 50*t.delay*len(order))
+	buf.WriteString("type=looped\n")
+	const ndirs = 8
+	for i, frame := range order {
+		if frame < 1 || frame > nframes {
+			return nil, errors.Errorf("invalid frame number %d in animation order of %q; expected 1-%d", frame, t.title, nframes)
+		}
+		for dir := 0; dir < ndirs; dir++ {
+			row := 0
+			if len(rows) == ndirs {
+				row = dir
+			}
+			// frame=index,direction,x,y,w,h,offset_x,offset_y
+			x := (frame - 1) * cellWidth
+			y := row * cellHeight
+			fmt.Fprintf(buf, "frame=%d,%d,%d,%d,%d,%d,%d,%d\n", i, dir, x, y, cellWidth, cellHeight, cellWidth/2, cellHeight-16)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// townerDef returns the NPC definition of the given towner.
+func townerDef(t towner) []byte {
+	// TODO: Add dialog and vendor stock of towners.
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "name=%s\n", t.title)
+	fmt.Fprintf(buf, "gfx=animations/npcs/%s.txt\n", t.gfx)
+	if t.dir != -1 {
+		fmt.Fprintf(buf, "direction=%d\n", flareDir(t.dir))
+	}
+	return buf.Bytes()
+}
+
+// updateMap replaces the NPC sections of the given map with spawn entries of
+// the specified towners.
+func updateMap(mapPath string, ts []towner) error {
+	buf, err := ioutil.ReadFile(mapPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	out := &bytes.Buffer{}
+	skip := false
+	for _, line := range strings.SplitAfter(string(buf), "\n") {
+		if strings.HasPrefix(line, "[") {
+			skip = strings.TrimSpace(line) == "[npc]"
+		}
+		if !skip {
+			out.WriteString(line)
+		}
+	}
+	contents := strings.TrimRight(out.String(), "\n") + "\n"
+	out.Reset()
+	out.WriteString(contents)
+	for _, t := range ts {
+		out.WriteString("\n")
+		out.WriteString("[npc]\n")
+		fmt.Fprintf(out, "# %s\n", t.title)
+		out.WriteString("type=npc\n")
+		fmt.Fprintf(out, "location=%d,%d,1,1\n", t.pos.X, t.pos.Y)
+		fmt.Fprintf(out, "filename=npcs/%s.txt\n", t.name)
+	}
+	if err := ioutil.WriteFile(mapPath, out.Bytes(), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// writeFile writes the given contents to the specified file, creating its
+// parent directory if not present.
+func writeFile(path string, contents []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import "image"
+
+// A towner is an inhabitant of Tristram.
+type towner struct {
+	// Base name of the NPC definition (e.g. "griswold").
+	name string
+	// NPC name (e.g. "Griswold the Blacksmith").
+	title string
+	// Base name of the graphics and animations of the towner (e.g. "griswold");
+	// shared by the cows.
+	gfx string
+	// Path to the CEL graphics (e.g. "towners/smith/smithn.cel").
+	celPath string
+	// Frame width in pixels of the CEL graphics.
+	width int
+	// Number of game ticks each frame of the animation is displayed.
+	delay int
+	// Index into the animation order table of diablo.exe, specifying the order
+	// in which the frames are played; -1 if the frames are played in order.
+	animOrder int
+	// Location in the map of Tristram.
+	pos image.Point
+	// Direction faced by towners with graphics of each direction (Diablo 1
+	// direction, e.g. dirSW); -1 if the graphics face one direction only.
+	dir int
+}
+
+// Directions of Diablo 1.
+const (
+	dirS = iota
+	dirSW
+	dirW
+	dirNW
+	dirN
+	dirNE
+	dirE
+	dirSE
+)
+
+// towners specifies the inhabitants of Tristram, as initialized by diablo.exe.
+//
+// ref: InitTowners
+var towners = []towner{
+	// ref: InitSmith
+	{name: "griswold", title: "Griswold the Blacksmith", gfx: "griswold", celPath: "towners/smith/smithn.cel", width: 96, delay: 3, animOrder: 0, pos: image.Pt(62, 63), dir: -1},
+	// ref: InitHealer
+	{name: "pepin", title: "Pepin the Healer", gfx: "pepin", celPath: "towners/healer/healer.cel", width: 96, delay: 6, animOrder: 1, pos: image.Pt(55, 79), dir: -1},
+	// ref: InitBarOwner
+	{name: "ogden", title: "Ogden the Tavern owner", gfx: "ogden", celPath: "towners/twnf/twnfn.cel", width: 96, delay: 3, animOrder: 3, pos: image.Pt(55, 62), dir: -1},
+	// ref: InitWitch
+	{name: "adria", title: "Adria the Witch", gfx: "adria", celPath: "towners/townwmn1/witch.cel", width: 96, delay: 6, animOrder: 5, pos: image.Pt(80, 20), dir: -1},
+	// ref: InitTeller
+	{name: "cain", title: "Cain the Elder", gfx: "cain", celPath: "towners/strytell/strytell.cel", width: 96, delay: 3, animOrder: 2, pos: image.Pt(62, 71), dir: -1},
+	// ref: InitDrunk
+	{name: "farnham", title: "Farnham the Drunk", gfx: "farnham", celPath: "towners/drunk/twndrunk.cel", width: 96, delay: 3, animOrder: 4, pos: image.Pt(71, 84), dir: -1},
+	// ref: InitBarmaid
+	{name: "gillian", title: "Gillian the Barmaid", gfx: "gillian", celPath: "towners/townwmn1/wmnn.cel", width: 96, delay: 6, animOrder: -1, pos: image.Pt(43, 66), dir: -1},
+	// ref: InitBoy
+	{name: "wirt", title: "Wirt the Peg-legged boy", gfx: "wirt", celPath: "towners/townboy/pegkid1.cel", width: 96, delay: 6, animOrder: -1, pos: image.Pt(11, 53), dir: -1},
+	// ref: InitCows (TownCowX, TownCowY and TownCowDir)
+	{name: "cow_1", title: "Cow", gfx: "cow", celPath: "towners/animals/cow.cel", width: 128, delay: 3, animOrder: -1, pos: image.Pt(58, 16), dir: dirSW},
+	{name: "cow_2", title: "Cow", gfx: "cow", celPath: "towners/animals/cow.cel", width: 128, delay: 3, animOrder: -1, pos: image.Pt(56, 14), dir: dirNW},
+	{name: "cow_3", title: "Cow", gfx: "cow", celPath: "towners/animals/cow.cel", width: 128, delay: 3, animOrder: -1, pos: image.Pt(59, 20), dir: dirN},
+}
+
+// flareDir returns the FLARE direction of the given Diablo 1 direction; the
+// first FLARE direction faces west (direction 2 of Diablo 1).
+func flareDir(dir int) int {
+	return (dir + 6) % 8
+}
@@ -1,10 +1,24 @@
+// The fixarches tool draws arches onto the dungeon pieces of each dungeon type,
+// and stores the resulting dungeon piece images.
+//
+// The dungeon pieces and arches are decoded in-process from the level graphics
+// of the game assets (extracted MPQ archives, or MPQ archives read directly);
+// dungeon pieces with arches are stored in the output directory, together with a
+// manifest listing the modified dungeon pieces. Arches placed on neighbouring
+// dungeon pieces (e.g. the doorway arches of the Catacombs) depend on the
+// surrounding map, and are thus not drawn.
+//
+// fixarches is not part of the opensourceami pipeline; gentilesetdef draws
+// arches onto the dungeon pieces of tileset images itself. The output of
+// fixarches is meant for inspecting the arch tables of dungeon types, and the
+// game assets are only read, never modified.
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
-	"image"
-	"image/draw"
+	"image/color"
 	"io/ioutil"
 	"log"
 	"os"
@@ -13,149 +27,248 @@ import (
 	"github.com/mewkiz/pkg/imgutil"
 	"github.com/mewkiz/pkg/term"
 	"github.com/pkg/errors"
-	"github.com/sanctuary/formats/image/cel/config"
+	"github.com/sanctuary/ember/asset"
+	"github.com/sanctuary/ember/dtype"
+	"github.com/sanctuary/ember/gfx"
 )
 
 // dbg represents a logger with the "fixarches:" prefix, which logs debug
 // messages to standard error.
 var dbg = log.New(os.Stderr, term.BlueBold("fixarches:")+" ", 0)
 
+func usage() {
+	const use = `
+Draw arches onto the dungeon pieces of each dungeon type.
+
+Usage:
+
+	fixarches [OPTION]...
+
+The dungeon pieces with arches are stored in the output directory (e.g.
+"_dpieces_arches_/l1/l1_1.pal/dpiece_0011.png"), and listed in the manifest
+of the output directory (manifest.txt); one line per dungeon piece with the
+fields dtype, palette, dungeon piece ID and arch ID. The game assets are only
+read, never modified.
+
+Examples:
+
+	# Draw arches onto the dungeon pieces of the extracted MPQ archives.
+	fixarches -assetdir .
+
+	# Draw arches onto the dungeon pieces of MPQ archives read directly.
+	fixarches -mpq diabdat.mpq,hellfire.mpq
+
+	# List the dungeon pieces with arches, without drawing arches.
+	fixarches -n
+
+Flags:
+`
+	fmt.Fprintln(os.Stderr, use[1:])
+	flag.PrintDefaults()
+}
+
 func main() {
 	// Parse command line flags.
 	var (
-		// mpqDir specifies the path to an extracted "diabdat.mpq".
-		mpqDir string
+		// dtypesPath specifies the path to additional dungeon type definitions.
+		dtypesPath string
+		// assetDir specifies the path to the directory containing the extracted
+		// MPQ archives (e.g. "diabdat" and "hellfire").
+		assetDir string
+		// mpqList specifies a comma-separated list of MPQ archives to read
+		// directly, without extraction.
+		mpqList string
+		// outputDir specifies the output directory of dungeon pieces with arches.
+		outputDir string
+		// dryRun specifies whether to list the dungeon pieces with arches,
+		// without drawing arches.
+		dryRun bool
 	)
-	flag.StringVar(&mpqDir, "mpqdir", "diabdat", `path to extracted "diabdat.mpq"`)
+	flag.StringVar(&dtypesPath, "dtypes", "", "path to additional dungeon type definitions")
+	flag.StringVar(&assetDir, "assetdir", ".", `path to directory containing extracted MPQ archives (e.g. "diabdat" and "hellfire")`)
+	flag.StringVar(&mpqList, "mpq", "", `comma-separated list of MPQ archives to read directly (e.g. "diabdat.mpq,hellfire.mpq"); overrides -assetdir`)
+	flag.StringVar(&outputDir, "o", "_dpieces_arches_", "output directory of dungeon pieces with arches")
+	flag.BoolVar(&dryRun, "n", false, "dry run; list dungeon pieces with arches (dtype, palette, dungeon piece ID and arch ID) without drawing arches")
+	flag.Usage = usage
 	flag.Parse()
+	if len(dtypesPath) > 0 {
+		if err := dtype.Load(dtypesPath); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+	// Locate the game assets containing the level graphics.
+	var assets *asset.Resolver
+	if !dryRun {
+		var err error
+		assets, err = asset.Open(assetDir, mpqList)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
 
-	dtypes := []string{"l1", "l2", "l3", "l4", "town"}
-	for _, dtype := range dtypes {
-		if err := fixArches(dtype, mpqDir); err != nil {
+	manifest := &bytes.Buffer{}
+	manifest.WriteString("# dtype,palette,dpiece,arch\n")
+	for _, dtypeName := range dtype.Names() {
+		dt, err := dtype.Get(dtypeName)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		if len(dt.Arches) == 0 {
+			continue
+		}
+		if assets != nil {
+			if !assets.Has(dt.Archive) {
+				dbg.Printf("skipping dungeon type %q; unable to locate %q", dt.Name, dt.Archive+".mpq")
+				continue
+			}
+			if assets.Spawn() && !dt.Spawn {
+				dbg.Printf("skipping dungeon type %q; not contained within the shareware spawn.mpq", dt.Name)
+				continue
+			}
+		}
+		if err := fixArches(manifest, assets, dt, outputDir); err != nil {
 			log.Fatalf("%+v", err)
 		}
 	}
+	if dryRun {
+		fmt.Print(manifest)
+		return
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Fatalf("%+v", errors.WithStack(err))
+	}
+	manifestPath := filepath.Join(outputDir, "manifest.txt")
+	if err := ioutil.WriteFile(manifestPath, manifest.Bytes(), 0644); err != nil {
+		log.Fatalf("%+v", errors.WithStack(err))
+	}
 }
 
-// fixArches draws arches on the relevant tiles of the given dungeon type.
-func fixArches(dtype, mpqDir string) error {
-	// Parse SOL file.
-	relSolPath := fmt.Sprintf("levels/%sdata/%s.sol", dtype, dtype)
-	solPath := filepath.Join(mpqDir, relSolPath)
-	sol, err := ioutil.ReadFile(solPath)
-	if err != nil {
-		return errors.WithStack(err)
+// fixArches draws arches on the relevant dungeon pieces of the given dungeon
+// type. The dungeon pieces with arches are stored in outputDir, and recorded in
+// the manifest. If assets is nil (i.e. dry run), the dungeon pieces are only
+// recorded in the manifest.
+func fixArches(manifest *bytes.Buffer, assets *asset.Resolver, dt *dtype.DungeonType, outputDir string) error {
+	var l *levelGfx
+	if assets != nil {
+		var err error
+		if l, err = parseLevelGfx(assets, dt); err != nil {
+			return errors.WithStack(err)
+		}
 	}
-
-	// Number of dungeon pieces in the tileset.
-	ndpieces := len(sol)
-	for dpieceID := 1; dpieceID <= ndpieces; dpieceID++ {
-		archID := getArch(dpieceID, dtype)
-		if archID == ArchNone {
+	for _, dpieceID := range dt.ArchDPieceIDs() {
+		archID := dt.ArchID(dpieceID)
+		if archID == dtype.ArchNone {
 			continue
 		}
-		relCelPath := fmt.Sprintf("levels/%sdata/%ss.cel", dtype, dtype)
-		celName := filepath.Base(relCelPath)
-		conf, err := config.Get(celName)
-		if err != nil {
-			return errors.WithStack(err)
+		var img *gfx.Image
+		if l != nil {
+			var err error
+			if img, err = l.dpieceWithArch(dpieceID, archID); err != nil {
+				return errors.WithStack(err)
+			}
 		}
-		for _, relPalPath := range conf.Pals {
-			palName := filepath.Base(relPalPath)
-			dpiecePath := filepath.Join("_dump_", fmt.Sprintf("_dpieces_/%s/%s/dpiece_%04d.png", dtype, palName, dpieceID))
-			dpieceImg, err := imgutil.ReadFile(dpiecePath)
+		for _, theme := range dt.Themes {
+			palName := theme.PalName()
+			fmt.Fprintf(manifest, "%s,%s,%d,%d\n", dt.Name, palName, dpieceID, archID)
+			if img == nil {
+				continue
+			}
+			pal, err := l.palette(theme.Palette)
 			if err != nil {
 				return errors.WithStack(err)
 			}
-			archPath := filepath.Join("_dump_", fmt.Sprintf("levels/%sdata/%ss/%s/%ss_%04d.png", dtype, dtype, palName, dtype, archID))
-			archImg, err := imgutil.ReadFile(archPath)
-			if err != nil {
+			dbg.Printf("Drawing arch ID %d onto dungeon piece ID %d with palette %q.", archID, dpieceID, theme.Palette)
+			relDPiecePath := fmt.Sprintf("%s/%s/dpiece_%04d.png", dt.Name, palName, dpieceID)
+			dstPath := filepath.Join(outputDir, relDPiecePath)
+			if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
 				return errors.WithStack(err)
 			}
-			bounds := dpieceImg.Bounds()
-			rect := image.Rect(0, 0, bounds.Dx(), bounds.Dy())
-			dst := image.NewRGBA(rect)
-			draw.Draw(dst, rect, dpieceImg, image.ZP, draw.Src)
-			draw.Draw(dst, rect, archImg, image.ZP, draw.Over)
-			dbg.Printf("Drawing arch ID %d onto dungeon piece ID %d with palette %q.", archID, dpieceID, relPalPath)
-			if err := imgutil.WriteFile(dpiecePath, dst); err != nil {
+			if err := imgutil.WriteFile(dstPath, img.Render(pal)); err != nil {
 				return errors.WithStack(err)
 			}
 		}
 	}
-
 	return nil
 }
 
-// getArch returns the arch ID of the given dungeon piece.
-func getArch(dpieceID int, dtype string) int {
-	switch dtype {
-	case "l1":
-		return l1ArchID(dpieceID)
-	case "l2":
-		// TODO: Implement support for layout 2.
-		return 0
-	case "l3":
-		// nothing to do; layout 3 has no arches.
-		return ArchNone
-	case "l4":
-		// nothing to do; layout 4 has no arches.
-		return ArchNone
-	case "town":
-		// TODO: Implement support for town.
-		return 0
-	default:
-		panic(fmt.Errorf("support for dungeon type %q not yet implemented", dtype))
+// levelGfx holds the decoded level graphics of a dungeon type.
+type levelGfx struct {
+	// Game assets.
+	assets *asset.Resolver
+	// Dungeon pieces of the dungeon type.
+	dpieces []gfx.DPiece
+	// Level CEL image containing the blocks of the dungeon pieces.
+	levelCEL *gfx.LevelCEL
+	// Arch images of the dungeon type, indexed by arch ID - 1.
+	arches []*gfx.Image
+	// Palettes of the dungeon type, indexed by palette path.
+	pals map[string]color.Palette
+}
+
+// parseLevelGfx parses the dungeon pieces (<dtype>.min), level CEL image
+// (<dtype>.cel) and arch images (<dtype>s.cel) of the given dungeon type.
+func parseLevelGfx(assets *asset.Resolver, dt *dtype.DungeonType) (*levelGfx, error) {
+	l := &levelGfx{
+		assets: assets,
+		pals:   make(map[string]color.Palette),
+	}
+	minData, err := assets.ReadFile(dt.DataPath(".min"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// Each row of blocks is 32 pixels tall, and contains two blocks.
+	nblocks := 2 * dt.TileHeight / 32
+	if l.dpieces, err = gfx.ParseMIN(minData, nblocks); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	celData, err := assets.ReadFile(dt.DataPath(".cel"))
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
+	if l.levelCEL, err = gfx.ParseLevelCEL(celData); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	archData, err := assets.ReadFile(dt.DataPath("s.cel"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if l.arches, err = gfx.DecodeCEL(archData, dtype.TileWidth); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return l, nil
 }
 
-const ArchNone = 0
-
-// Arch IDs for layout 1.
-const (
-	L1ArchSe        = 2
-	L1ArchSeBroken  = 3
-	L1ArchSeDoor    = 8
-	L1ArchSw        = 1
-	L1ArchSw2       = 5
-	L1ArchSwBroken  = 6
-	L1ArchSwBroken2 = 4
-	L1ArchSwDoor    = 7
-)
+// dpieceWithArch decodes the given dungeon piece, and draws the given arch on
+// top, aligned at the bottom of the dungeon piece.
+func (l *levelGfx) dpieceWithArch(dpieceID, archID int) (*gfx.Image, error) {
+	if dpieceID < 1 || dpieceID > len(l.dpieces) {
+		return nil, errors.Errorf("invalid dungeon piece ID %d; expected 1-%d", dpieceID, len(l.dpieces))
+	}
+	if archID < 1 || archID > len(l.arches) {
+		return nil, errors.Errorf("invalid arch ID %d of dungeon piece %d; expected 1-%d", archID, dpieceID, len(l.arches))
+	}
+	img, err := l.levelCEL.DecodeDPiece(l.dpieces[dpieceID-1])
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decode dungeon piece %d", dpieceID)
+	}
+	arch := l.arches[archID-1]
+	img.Draw(arch, 0, img.Height-arch.Height)
+	return img, nil
+}
 
-// l1ArchID returns the arch ID of the given dungeon piece.
-//
-// ref: 46E9E2
-func l1ArchID(dpieceID int) int {
-	// Dungeon piece IDs for layout 1.
-	const (
-		// Floor shadows for arches.
-		L1DPieceNone                       = 0
-		L1DPieceFloorShadowArchSe_1        = 11
-		L1DPieceFloorShadowArchSe_2        = 249
-		L1DPieceFloorShadowArchSe_3        = 325
-		L1DPieceFloorShadowArchSe_4        = 331
-		L1DPieceFloorShadowArchSe_5        = 344
-		L1DPieceFloorShadowArchSe_6        = 421
-		L1DPieceFloorShadowArchSw2_1       = 259
-		L1DPieceFloorShadowArchSwBroken2_1 = 255
-		L1DPieceFloorShadowArchSw_1        = 12
-		L1DPieceFloorShadowArchSw_2        = 71
-		L1DPieceFloorShadowArchSw_3        = 211
-		L1DPieceFloorShadowArchSw_4        = 321
-		L1DPieceFloorShadowArchSw_5        = 341
-		L1DPieceFloorShadowArchSw_6        = 418
-	)
-	switch dpieceID {
-	case L1DPieceFloorShadowArchSw_1, L1DPieceFloorShadowArchSw_2, L1DPieceFloorShadowArchSw_3, L1DPieceFloorShadowArchSw_4, L1DPieceFloorShadowArchSw_5, L1DPieceFloorShadowArchSw_6:
-		return L1ArchSw
-	case L1DPieceFloorShadowArchSe_1, L1DPieceFloorShadowArchSe_2, L1DPieceFloorShadowArchSe_3, L1DPieceFloorShadowArchSe_4, L1DPieceFloorShadowArchSe_5, L1DPieceFloorShadowArchSe_6:
-		return L1ArchSe
-	case L1DPieceFloorShadowArchSwBroken2_1:
-		return L1ArchSwBroken2
-	case L1DPieceFloorShadowArchSw2_1:
-		return L1ArchSw2
-	default:
-		return ArchNone
+// palette returns the palette at the given path.
+func (l *levelGfx) palette(palPath string) (color.Palette, error) {
+	if pal, ok := l.pals[palPath]; ok {
+		return pal, nil
+	}
+	buf, err := l.assets.ReadFile(palPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pal, err := gfx.ParsePalette(buf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse palette %q", palPath)
 	}
+	l.pals[palPath] = pal
+	return pal, nil
 }
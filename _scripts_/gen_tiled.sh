@@ -1,8 +1,14 @@
 #!/bin/bash
-gentilesetdef -mpqdir=../_assets_/diabdat -dtype town > ../mods/ember/tilesetdefs/tileset_tristram.txt
-gentilesetdef -mpqdir=../_assets_/diabdat -dtype l1 > ../mods/ember/tilesetdefs/tileset_cathedral.txt
-gentilesetdef -mpqdir=../_assets_/diabdat -dtype l2 > ../mods/ember/tilesetdefs/tileset_catacombs.txt
-gentilesetdef -mpqdir=../_assets_/diabdat -dtype l3 > ../mods/ember/tilesetdefs/tileset_caves.txt
-gentilesetdef -mpqdir=../_assets_/diabdat -dtype l4 > ../mods/ember/tilesetdefs/tileset_hell.txt
+go run gentiled_collision.go -o ../tiled/tiled_collision.png ../tiled/mask.png
 
-gentmx -mpqdir=../_assets_/diabdat ../_assets_/testdata/l1/l1_pillars_00000000.bin > ../tiled/cathedral/cathedral_00000000.tmx
+gentilesetdef -assetdir=../_assets_ -dtype town > ../mods/ember/tilesetdefs/tileset_tristram.txt
+gentilesetdef -assetdir=../_assets_ -dtype l1 > ../mods/ember/tilesetdefs/tileset_cathedral.txt
+gentilesetdef -assetdir=../_assets_ -dtype l2 > ../mods/ember/tilesetdefs/tileset_catacombs.txt
+gentilesetdef -assetdir=../_assets_ -dtype l3 > ../mods/ember/tilesetdefs/tileset_caves.txt
+gentilesetdef -assetdir=../_assets_ -dtype l4 > ../mods/ember/tilesetdefs/tileset_hell.txt
+
+# Untrimmed tileset images of Tiled, as referred to by the maps of gentmx.
+gentilesetdef -assetdir=../_assets_ -dtype town -grid ../tiled/tileset > /dev/null
+gentilesetdef -assetdir=../_assets_ -dtype l1 -grid ../tiled/tileset > /dev/null
+
+gentmx -assetdir=../_assets_ ../_assets_/testdata/l1/l1_pillars_00000000.bin > ../tiled/cathedral/cathedral_00000000.tmx
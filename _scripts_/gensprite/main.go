@@ -0,0 +1,199 @@
+// The gensprite tool generates sprite sheets from the CEL and CL2 graphics of
+// Diablo 1.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image/color"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mewkiz/pkg/imgutil"
+	"github.com/pkg/errors"
+	"github.com/sanctuary/ember/asset"
+	"github.com/sanctuary/ember/gfx"
+)
+
+func usage() {
+	const use = `
+Generate sprite sheets from the CEL and CL2 graphics of Diablo 1.
+
+Usage:
+
+	gensprite [OPTION]... -o OUTPUT.png
+
+Examples:
+
+	# Generate sprite sheet of the Spitting Terror; one row per direction, with
+	# the frames of each action (attack, die, hit, stand, special and walk).
+	gensprite -cl2 monsters/acid/acid%c.cl2 -actions adhnsw -o spitting_terror.png
+
+	# Extract the hand cursor; frame 1 of objcurs.cel, 33 pixels wide.
+	gensprite -cel data/inv/objcurs.cel -frame 1 -width 33 -o cursor_hand.png
+
+Flags:
+`
+	fmt.Fprintln(os.Stderr, use[1:])
+	flag.PrintDefaults()
+}
+
+func main() {
+	// Parse command line flags.
+	var (
+		// assetDir specifies the path to the directory containing the extracted
+		// MPQ archives (e.g. "diabdat" and "hellfire").
+		assetDir string
+		// mpqList specifies a comma-separated list of MPQ archives to read
+		// directly, without extraction.
+		mpqList string
+		// cl2Format specifies the path format of the CL2 graphics of each action
+		// (e.g. "monsters/acid/acid%c.cl2").
+		cl2Format string
+		// actions specifies the action runes of the CL2 graphics, in column order
+		// (e.g. "adhnsw").
+		actions string
+		// celPath specifies the path to CEL graphics (e.g.
+		// "data/inv/objcurs.cel").
+		celPath string
+		// frame specifies the frame number (1-based) of the CEL graphics to
+		// extract; all frames if 0.
+		frame int
+		// width specifies the frame width in pixels of graphics without frame
+		// headers.
+		width int
+		// palPath specifies the path to the palette.
+		palPath string
+		// trnPath specifies the path to the colour translation (e.g.
+		// "monsters/zombie/bluered.trn").
+		trnPath string
+		// cellSize specifies the cell size in pixels of the sprite sheet (e.g.
+		// "160x160").
+		cellSize string
+		// output specifies the output path.
+		output string
+	)
+	flag.StringVar(&assetDir, "assetdir", ".", `path to directory containing extracted MPQ archives (e.g. "diabdat" and "hellfire")`)
+	flag.StringVar(&mpqList, "mpq", "", `comma-separated list of MPQ archives to read directly (e.g. "diabdat.mpq,hellfire.mpq"); overrides -assetdir`)
+	flag.StringVar(&cl2Format, "cl2", "", `path format of CL2 graphics of each action (e.g. "monsters/acid/acid%c.cl2")`)
+	flag.StringVar(&actions, "actions", "", `action runes of CL2 graphics, in column order (e.g. "adhnsw")`)
+	flag.StringVar(&celPath, "cel", "", `path to CEL graphics (e.g. "data/inv/objcurs.cel")`)
+	flag.IntVar(&frame, "frame", 0, "frame number (1-based) of CEL graphics to extract; all frames if 0")
+	flag.IntVar(&width, "width", 0, "frame width in pixels of graphics without frame headers")
+	flag.StringVar(&palPath, "pal", "levels/towndata/town.pal", "path to palette")
+	flag.StringVar(&trnPath, "trn", "", `path to colour translation (e.g. "monsters/zombie/bluered.trn")`)
+	flag.StringVar(&cellSize, "size", "", `cell size in pixels of sprite sheet (e.g. "160x160"); size of largest frame if empty`)
+	flag.StringVar(&output, "o", "", "output path")
+	flag.Usage = usage
+	flag.Parse()
+	if len(output) == 0 || (len(cl2Format) == 0) == (len(celPath) == 0) {
+		flag.Usage()
+		os.Exit(1)
+	}
+	var cellWidth, cellHeight int
+	if len(cellSize) > 0 {
+		if _, err := fmt.Sscanf(cellSize, "%dx%d", &cellWidth, &cellHeight); err != nil {
+			log.Fatalf("invalid cell size %q; %v", cellSize, err)
+		}
+	}
+	assets, err := asset.Open(assetDir, mpqList)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+
+	// Generate sprite sheet.
+	var rows [][]*gfx.Image
+	if len(cl2Format) > 0 {
+		rows, err = cl2Rows(assets, cl2Format, actions, width)
+	} else {
+		rows, err = celRows(assets, celPath, frame, width)
+	}
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	pal, err := loadPalette(assets, palPath, trnPath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	sheet := gfx.SpriteSheet(rows, cellWidth, cellHeight)
+	if err := imgutil.WriteFile(output, sheet.Render(pal)); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+// cl2Rows returns the frames of the given CL2 graphics, with one row per
+// direction. Each row contains the frames of each action in order. Graphics
+// with a single direction (e.g. golem die action) are used for every row.
+func cl2Rows(assets *asset.Resolver, cl2Format, actions string, width int) ([][]*gfx.Image, error) {
+	const ndirs = 8
+	rows := make([][]*gfx.Image, ndirs)
+	for _, action := range actions {
+		cl2Path := fmt.Sprintf(cl2Format, action)
+		buf, err := assets.ReadFile(cl2Path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		groups, err := gfx.DecodeCL2(buf, width)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to decode %q", cl2Path)
+		}
+		for i := range rows {
+			// The first row faces south-west (direction 2 of Diablo 1).
+			dir := (2 + i) % ndirs
+			if len(groups) == 1 {
+				dir = 0
+			}
+			if dir >= len(groups) {
+				return nil, errors.Errorf("invalid number of directions in %q; expected %d, got %d", cl2Path, ndirs, len(groups))
+			}
+			rows[i] = append(rows[i], groups[dir]...)
+		}
+	}
+	return rows, nil
+}
+
+// celRows returns the given frame of the CEL graphics, or all frames in a
+// single row if frame is 0.
+func celRows(assets *asset.Resolver, celPath string, frame, width int) ([][]*gfx.Image, error) {
+	buf, err := assets.ReadFile(celPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	frames, err := gfx.DecodeCEL(buf, width)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decode %q", celPath)
+	}
+	if frame == 0 {
+		return [][]*gfx.Image{frames}, nil
+	}
+	if frame < 1 || frame > len(frames) {
+		return nil, errors.Errorf("invalid frame number %d of %q; expected 1-%d", frame, celPath, len(frames))
+	}
+	return [][]*gfx.Image{{frames[frame-1]}}, nil
+}
+
+// loadPalette loads the given palette, with colours translated by the specified
+// colour translation if non-empty.
+func loadPalette(assets *asset.Resolver, palPath, trnPath string) (color.Palette, error) {
+	buf, err := assets.ReadFile(palPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pal, err := gfx.ParsePalette(buf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse palette %q", palPath)
+	}
+	if len(trnPath) == 0 {
+		return pal, nil
+	}
+	trn, err := assets.ReadFile(strings.ToLower(trnPath))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pal, err = gfx.Translate(pal, trn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to apply colour translation %q", trnPath)
+	}
+	return pal, nil
+}
@@ -7,12 +7,16 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"log"
 	"os"
 
 	"github.com/mewkiz/pkg/imgutil"
 	"github.com/pkg/errors"
-	"golang.org/x/image/colornames"
+	"github.com/sanctuary/ember/collision"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
 )
 
 func usage() {
@@ -22,12 +26,20 @@ Generate tiled_collision.png from a given mask image.
 Usage:
 
 	gentiled_collision [OPTION]... FILE.png
+
+Flags:
 `
 	fmt.Fprintln(os.Stderr, use[1:])
 	flag.PrintDefaults()
 }
 
 func main() {
+	// Parse command line flags.
+	var (
+		// output specifies the output path.
+		output string
+	)
+	flag.StringVar(&output, "o", "tiled_collision.png", "output path")
 	flag.Usage = usage
 	flag.Parse()
 	if flag.NArg() != 1 {
@@ -39,30 +51,128 @@ func main() {
 	if err != nil {
 		log.Fatalf("%+v", errors.WithStack(err))
 	}
-	const ncollisions = 40
-	i := 0
-	for _, c := range colornames.Map {
-		if i >= ncollisions {
-			break
-		}
-		dst := gen(mask, c)
-		out := fmt.Sprintf("mask_%04d.png", i)
-		if err := imgutil.WriteFile(out, dst); err != nil {
-			log.Fatalf("%+v", errors.WithStack(err))
+	dst := genTileset(mask)
+	if err := imgutil.WriteFile(output, dst); err != nil {
+		log.Fatalf("%+v", errors.WithStack(err))
+	}
+}
+
+// Dimensions of the collision tileset.
+const (
+	// Tile width in pixels.
+	tileWidth = 64
+	// Tile height in pixels.
+	tileHeight = 32
+	// Number of tiles per row.
+	ntilesPerRow = 8
+	// Number of tile rows.
+	nrows = 5
+	// Total number of collision tiles.
+	ncollisions = ntilesPerRow * nrows
+)
+
+// SOL study values; used by the maps in "_learn_" to visualize the individual
+// bits of <dtype>.SOL, one collision layer per bit.
+const (
+	SOL_01 = 9 + iota // block walk
+	SOL_02            // lighting?
+	SOL_04            // block missile
+	SOL_08            // transparency?
+	SOL_10            // sw wall
+	SOL_20            // se wall
+	SOL_40
+	SOL_80 // fit shrine
+)
+
+// collisionTile specifies the appearance of a collision tile.
+type collisionTile struct {
+	// Label drawn on top of the tile.
+	label string
+	// Colour of the tile.
+	c color.RGBA
+}
+
+// collisionTiles maps from collision value to collision tile. The tile of
+// collision value n is located at tile index n-1 of the tileset (i.e. first
+// GID of the collision tileset is 1), and BLOCKS_NONE has no tile.
+//
+//    value  label  colour   meaning
+//
+//    1      all    red      BLOCKS_ALL
+//    2      move   blue     BLOCKS_MOVEMENT
+//    3      all*   magenta  BLOCKS_ALL_HIDDEN
+//    4      move*  cyan     BLOCKS_MOVEMENT_HIDDEN
+//    5-8    5-8    gray     unused
+//    9      01     orange   SOL bit 0x01 (block walk)
+//    10     02     yellow   SOL bit 0x02 (lighting?)
+//    11     04     green    SOL bit 0x04 (block missile)
+//    12     08     purple   SOL bit 0x08 (transparency?)
+//    13     10     brown    SOL bit 0x10 (sw wall)
+//    14     20     olive    SOL bit 0x20 (se wall)
+//    15     40     teal     SOL bit 0x40
+//    16     80     pink     SOL bit 0x80 (fit shrine)
+//    17-40  17-40  gray     unused
+var collisionTiles = map[int]collisionTile{
+	collision.BLOCKS_ALL:             {label: "all", c: color.RGBA{R: 0xFF, A: 0xFF}},
+	collision.BLOCKS_MOVEMENT:        {label: "move", c: color.RGBA{B: 0xFF, A: 0xFF}},
+	collision.BLOCKS_ALL_HIDDEN:      {label: "all*", c: color.RGBA{R: 0xFF, B: 0xFF, A: 0xFF}},
+	collision.BLOCKS_MOVEMENT_HIDDEN: {label: "move*", c: color.RGBA{G: 0xFF, B: 0xFF, A: 0xFF}},
+	SOL_01:                           {label: "01", c: color.RGBA{R: 0xFF, G: 0xA5, A: 0xFF}},
+	SOL_02:                           {label: "02", c: color.RGBA{R: 0xFF, G: 0xFF, A: 0xFF}},
+	SOL_04:                           {label: "04", c: color.RGBA{G: 0xC0, A: 0xFF}},
+	SOL_08:                           {label: "08", c: color.RGBA{R: 0x80, B: 0x80, A: 0xFF}},
+	SOL_10:                           {label: "10", c: color.RGBA{R: 0xA5, G: 0x2A, B: 0x2A, A: 0xFF}},
+	SOL_20:                           {label: "20", c: color.RGBA{R: 0x80, G: 0x80, A: 0xFF}},
+	SOL_40:                           {label: "40", c: color.RGBA{G: 0x80, B: 0x80, A: 0xFF}},
+	SOL_80:                           {label: "80", c: color.RGBA{R: 0xFF, G: 0x69, B: 0xB4, A: 0xFF}},
+}
+
+// unusedColor specifies the colour of unused collision tiles.
+var unusedColor = color.RGBA{R: 0xC0, G: 0xC0, B: 0xC0, A: 0xFF}
+
+// genTileset generates the collision tileset, based on the given mask image.
+func genTileset(mask image.Image) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, ntilesPerRow*tileWidth, nrows*tileHeight))
+	for i := 0; i < ncollisions; i++ {
+		value := i + 1
+		tile, ok := collisionTiles[value]
+		if !ok {
+			tile = collisionTile{label: fmt.Sprintf("%d", value), c: unusedColor}
 		}
-		i++
+		x := (i % ntilesPerRow) * tileWidth
+		y := (i / ntilesPerRow) * tileHeight
+		rect := image.Rect(x, y, x+tileWidth, y+tileHeight)
+		draw.Draw(dst, rect, gen(mask, tile), image.ZP, draw.Src)
 	}
+	return dst
 }
 
-func gen(mask image.Image, c color.Color) image.Image {
-	dst := image.NewRGBA(image.Rect(0, 0, 64, 32))
-	for x := 0; x < 64; x++ {
-		for y := 0; y < 64; y++ {
+// gen generates a collision tile of the given appearance, based on the given
+// mask image.
+func gen(mask image.Image, tile collisionTile) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, tileWidth, tileHeight))
+	for x := 0; x < tileWidth; x++ {
+		for y := 0; y < tileHeight; y++ {
 			c0 := mask.At(x, y)
 			if _, _, _, a := c0.RGBA(); a != 0 {
-				dst.Set(x, y, c)
+				dst.Set(x, y, tile.c)
 			}
 		}
 	}
+	// Draw label centered on tile.
+	face := basicfont.Face7x13
+	d := &font.Drawer{
+		Dst:  dst,
+		Src:  image.Black,
+		Face: face,
+	}
+	width := d.MeasureString(tile.label).Ceil()
+	x := (tileWidth - width) / 2
+	y := (tileHeight + face.Ascent - face.Descent) / 2
+	// Draw white backdrop to keep the label readable on dark stripes.
+	backdrop := image.Rect(x-1, y-face.Ascent, x+width+1, y+face.Descent)
+	draw.Draw(dst, backdrop, image.White, image.ZP, draw.Src)
+	d.Dot = fixed.P(x, y)
+	d.DrawString(tile.label)
 	return dst
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/pkg/errors"
+	"github.com/sanctuary/ember/asset"
+	"github.com/sanctuary/ember/dtype"
+	"github.com/sanctuary/ember/gfx"
+)
+
+// Diablo 1 animates lava and water by palette colour cycling, rather than by
+// sequences of dungeon pieces. To animate the corresponding tiles in FLARE,
+// each animated dungeon piece is rendered once per frame of the palette cycle.
+// The animation frames are packed together with the dungeon piece images into
+// tileset atlas images.
+//
+// Only palette cycled dungeon pieces are animated.
+//
+// TODO: Animate the fountain and smithy fire of Tristram. They are not animated
+// by palette cycling, and the frame tables of their dungeon piece sequences
+// have yet to be located in the original game; until then, their tiles are
+// rendered as static dungeon pieces.
+
+// A paletteAnim is a palette colour cycling animation of a dungeon piece.
+type paletteAnim struct {
+	// Dungeon piece ID.
+	dpieceID int
+}
+
+// findPaletteAnims locates the dungeon pieces of the given dungeon type which
+// contain palette cycled colours. The dungeon pieces are located based on the
+// images rendered using the palette of the default theme.
+func findPaletteAnims(r *dpieceRenderer, ndpieces int) ([]paletteAnim, error) {
+	dt := r.dt
+	cycle := dt.PaletteCycle
+	if cycle == nil {
+		return nil, nil
+	}
+	theme := dt.DefaultTheme()
+	pal, err := r.palette(theme)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	indices := cycledIndices(pal, cycle)
+	var anims []paletteAnim
+	for dpieceID := 1; dpieceID <= ndpieces; dpieceID++ {
+		img, err := r.image(theme, dpieceID)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if !hasCycledColor(img, indices) {
+			continue
+		}
+		anims = append(anims, paletteAnim{dpieceID: dpieceID})
+	}
+	return anims, nil
+}
+
+// cycleFrame returns the given dungeon piece image, as displayed after the
+// specified number of frames of the palette cycle.
+func cycleFrame(img image.Image, pal color.Palette, indices map[color.NRGBA]int, cycle *dtype.PaletteCycle, frame int) image.Image {
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if i, ok := indices[c]; ok {
+				c = pal[cycle.Index(i, frame)].(color.NRGBA)
+			}
+			dst.SetNRGBA(x, y, c)
+		}
+	}
+	return dst
+}
+
+// hasCycledColor reports whether the given image contains any of the specified
+// palette cycled colours.
+func hasCycledColor(img image.Image, indices map[color.NRGBA]int) bool {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if _, ok := indices[c]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cycledIndices returns a map from colour to palette index of the palette
+// cycled colours. Colours also present outside of the cycled range of the
+// palette are static, and thus omitted.
+func cycledIndices(pal color.Palette, cycle *dtype.PaletteCycle) map[color.NRGBA]int {
+	static := make(map[color.NRGBA]bool)
+	for i, c := range pal {
+		if i < cycle.First || i > cycle.Last {
+			static[c.(color.NRGBA)] = true
+		}
+	}
+	indices := make(map[color.NRGBA]int)
+	for i := cycle.First; i <= cycle.Last && i < len(pal); i++ {
+		c := pal[i].(color.NRGBA)
+		if static[c] {
+			continue
+		}
+		indices[c] = i
+	}
+	return indices
+}
+
+// loadPalette loads the given palette of 256 RGB colours.
+func loadPalette(assets *asset.Resolver, palPath string) (color.Palette, error) {
+	buf, err := assets.ReadFile(palPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pal, err := gfx.ParsePalette(buf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse palette %q", palPath)
+	}
+	return pal, nil
+}
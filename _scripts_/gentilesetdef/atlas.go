@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mewkiz/pkg/imgutil"
+	"github.com/pkg/errors"
+	"github.com/sanctuary/ember/atlas"
+	"github.com/sanctuary/ember/dtype"
+)
+
+// genAtlas packs the tile images (i.e. dungeon pieces, arches output as separate
+// tiles, and animation frames) of the given dungeon type and palette theme into
+// a tileset atlas image, which is stored in atlasDir. The tileset definition
+// matching the atlas layout is written to w. Each tile is cropped to its given
+// bounding box.
+//
+// FLARE supports one image per tileset definition, so the tiles are packed into
+// a single atlas page. An error is returned if the tiles do not fit within a
+// page of maxSize x maxSize pixels.
+//
+// The atlas layout only depends on the bounding boxes of the tiles, and is thus
+// the same for each theme of the dungeon type.
+func genAtlas(w io.Writer, r *dpieceRenderer, theme dtype.Theme, ntiles int, bounds []image.Rectangle, anims []paletteAnim, atlasDir string, maxSize int) error {
+	dt := r.dt
+	// Each tile is packed into a group together with its animation frames, so
+	// that all frames are located on the same atlas page.
+	animOf := make(map[int]paletteAnim)
+	for _, anim := range anims {
+		animOf[anim.dpieceID] = anim
+	}
+	nframes := func(tile int) int {
+		if _, ok := animOf[tile]; ok {
+			return dt.PaletteCycle.NFrames()
+		}
+		return 1
+	}
+	groups := make([][]image.Point, ntiles)
+	for tile := 1; tile <= ntiles; tile++ {
+		for frame := 0; frame < nframes(tile); frame++ {
+			groups[tile-1] = append(groups[tile-1], bounds[tile].Size())
+		}
+	}
+	layout, err := atlas.Pack(groups, maxSize)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tileset := dt.TilesetName(theme)
+	if len(layout.Pages) > 1 {
+		return errors.Errorf("unable to pack tileset %q into a single atlas image of %dx%d pixels (requires %d pages); increase -maxsize", tileset, maxSize, maxSize, len(layout.Pages))
+	}
+
+	// Store tileset atlas image.
+	if err := os.MkdirAll(atlasDir, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	var (
+		pal     color.Palette
+		indices map[color.NRGBA]int
+	)
+	if len(anims) > 0 {
+		if pal, err = r.palette(theme); err != nil {
+			return errors.WithStack(err)
+		}
+		indices = cycledIndices(pal, dt.PaletteCycle)
+	}
+	imgs := make([][]image.Image, ntiles)
+	for tile := 1; tile <= ntiles; tile++ {
+		img, err := r.image(theme, tile)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for frame := 0; frame < nframes(tile); frame++ {
+			frameImg := img
+			if frame > 0 {
+				frameImg = cycleFrame(img, pal, indices, dt.PaletteCycle, frame)
+			}
+			imgs[tile-1] = append(imgs[tile-1], crop(frameImg, bounds[tile]))
+		}
+	}
+	pages, err := layout.Draw(imgs)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	atlasPath := filepath.Join(atlasDir, tileset+".png")
+	if err := imgutil.WriteFile(atlasPath, pages[0]); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Output tileset definition.
+	fmt.Fprintf(w, "img=images/tileset/%s.png\n\n", tileset)
+	for tile := 1; tile <= ntiles; tile++ {
+		rect := layout.Rects[tile-1][0]
+		printTile(w, dt, tile, rect.Min, bounds[tile])
+	}
+	if len(anims) > 0 {
+		fmt.Fprintln(w)
+	}
+	for _, anim := range anims {
+		var frames []image.Point
+		for _, rect := range layout.Rects[anim.dpieceID-1] {
+			frames = append(frames, rect.Min)
+		}
+		printAnim(w, dt, anim.dpieceID, frames)
+	}
+	return nil
+}
+
+// crop returns the given bounding box of the image, relative to the top-left
+// corner of the image.
+func crop(img image.Image, bounds image.Rectangle) image.Image {
+	type subImager interface {
+		SubImage(r image.Rectangle) image.Image
+	}
+	return img.(subImager).SubImage(bounds.Add(img.Bounds().Min))
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/pkg/errors"
+	"github.com/sanctuary/ember/asset"
+	"github.com/sanctuary/ember/dtype"
+	"github.com/sanctuary/ember/gfx"
+	"github.com/sanctuary/ember/light"
+)
+
+// dpieceRenderer renders the dungeon piece images of a dungeon type, with
+// arches drawn on top. The dungeon pieces are decoded in-process from the level
+// graphics of the dungeon type.
+//
+// If arches are rendered as a separate layer, arches are not drawn onto the
+// dungeon pieces. Instead, each arch is rendered as a tile of its own, which
+// follows the dungeon pieces (i.e. the tile of arch ID n is ndpieces+n). Arches
+// placed on neighbouring dungeon pieces are only rendered as separate tiles, as
+// they depend on the surrounding map.
+//
+// The see-through variants of transparent walls, if rendered, follow the
+// dungeon pieces and arches. The darkness tiles of each light level, if
+// rendered, follow last.
+type dpieceRenderer struct {
+	// Dungeon type.
+	dt *dtype.DungeonType
+	// Game assets.
+	assets *asset.Resolver
+	// Number of dungeon pieces of the dungeon type.
+	ndpieces int
+	// Specifies whether to render arches as separate tiles.
+	archLayer bool
+	// Dungeon piece IDs of the transparent walls with see-through variants.
+	transIDs []int
+	// Specifies whether to render darkness tiles.
+	darkness bool
+	// Dungeon pieces of the dungeon type.
+	dpieces []gfx.DPiece
+	// Level CEL image containing the blocks of the dungeon pieces.
+	levelCEL *gfx.LevelCEL
+	// Arch images of the dungeon type, indexed by arch ID - 1.
+	arches []*gfx.Image
+	// Decoded tiles, indexed by tile - 1.
+	cache []*gfx.Image
+	// Palettes of the dungeon type, indexed by palette path.
+	pals map[string]color.Palette
+}
+
+// newDPieceRenderer returns a new dungeon piece renderer for the given dungeon
+// type. If archLayer is set, arches are rendered as separate tiles. See-through
+// variants are rendered of the given transparent walls. If darkness is set,
+// darkness tiles are rendered.
+func newDPieceRenderer(dt *dtype.DungeonType, assets *asset.Resolver, ndpieces int, transIDs []int, archLayer, darkness bool) (*dpieceRenderer, error) {
+	r := &dpieceRenderer{
+		dt:        dt,
+		assets:    assets,
+		ndpieces:  ndpieces,
+		transIDs:  transIDs,
+		darkness:  darkness,
+		archLayer: archLayer,
+		pals:      make(map[string]color.Palette),
+	}
+	// Parse MIN file.
+	minData, err := assets.ReadFile(dt.DataPath(".min"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// Each row of blocks is 32 pixels tall, and contains two blocks.
+	nblocks := 2 * dt.TileHeight / 32
+	r.dpieces, err = gfx.ParseMIN(minData, nblocks)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	r.cache = make([]*gfx.Image, r.nbaseTiles())
+	// Parse level CEL image.
+	celData, err := assets.ReadFile(dt.DataPath(".cel"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	r.levelCEL, err = gfx.ParseLevelCEL(celData)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// Parse arch images (<dtype>s.cel).
+	if len(dt.Arches) > 0 {
+		archData, err := assets.ReadFile(dt.DataPath("s.cel"))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		r.arches, err = gfx.DecodeCEL(archData, dtype.TileWidth)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return r, nil
+}
+
+// ntiles returns the number of tiles; the dungeon pieces followed by the arches
+// if rendered as separate tiles, the see-through variants of transparent walls,
+// and the darkness tiles.
+func (r *dpieceRenderer) ntiles() int {
+	n := r.nbaseTiles() + len(r.transIDs)
+	if r.darkness {
+		n += light.MaxLevel
+	}
+	return n
+}
+
+// nbaseTiles returns the number of tiles preceding the see-through variants of
+// transparent walls.
+func (r *dpieceRenderer) nbaseTiles() int {
+	if r.archLayer {
+		return r.ndpieces + r.dt.NArches()
+	}
+	return r.ndpieces
+}
+
+// image returns the image of the given tile (i.e. dungeon piece ID, or
+// ndpieces+archID for arches rendered as separate tiles), using the palette of
+// the specified theme.
+func (r *dpieceRenderer) image(theme dtype.Theme, tile int) (image.Image, error) {
+	if tile < 1 || tile > r.ntiles() {
+		return nil, errors.Errorf("invalid tile %d; expected 1-%d", tile, r.ntiles())
+	}
+	if n := r.nbaseTiles() + len(r.transIDs); tile > n {
+		return darknessTile(r.dt, tile-n), nil
+	}
+	if n := r.nbaseTiles(); tile > n {
+		dpieceID := r.transIDs[tile-n-1]
+		img, err := r.image(theme, dpieceID)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return seeThrough(img, r.dt.TileHeight), nil
+	}
+	img := r.cache[tile-1]
+	if img == nil {
+		var err error
+		if tile > r.ndpieces {
+			img, err = r.decodeArch(tile - r.ndpieces)
+		} else {
+			img, err = r.decodeDPiece(tile)
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		r.cache[tile-1] = img
+	}
+	pal, err := r.palette(theme)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return img.Render(pal), nil
+}
+
+// decodeDPiece decodes the given dungeon piece, with its arch drawn on top
+// unless arches are rendered as separate tiles.
+func (r *dpieceRenderer) decodeDPiece(dpieceID int) (*gfx.Image, error) {
+	if dpieceID > len(r.dpieces) {
+		return nil, errors.Errorf("invalid dungeon piece ID %d; expected 1-%d", dpieceID, len(r.dpieces))
+	}
+	img, err := r.levelCEL.DecodeDPiece(r.dpieces[dpieceID-1])
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decode dungeon piece %d", dpieceID)
+	}
+	if r.archLayer {
+		return img, nil
+	}
+	// Draw arch on top of dungeon piece, aligned at the bottom.
+	if archID := r.dt.ArchID(dpieceID); archID != dtype.ArchNone {
+		arch, err := r.arch(archID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid arch of dungeon piece %d", dpieceID)
+		}
+		img.Draw(arch, 0, img.Height-arch.Height)
+	}
+	return img, nil
+}
+
+// decodeArch decodes the given arch, as a tile of the same dimensions as the
+// dungeon pieces. The arch is aligned at the bottom of the tile, and thus shares
+// the origin of the dungeon piece it belongs to.
+func (r *dpieceRenderer) decodeArch(archID int) (*gfx.Image, error) {
+	arch, err := r.arch(archID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	img := gfx.NewImage(dtype.TileWidth, r.dt.TileHeight)
+	img.Draw(arch, 0, img.Height-arch.Height)
+	return img, nil
+}
+
+// arch returns the image of the given arch.
+func (r *dpieceRenderer) arch(archID int) (*gfx.Image, error) {
+	if archID < 1 || archID > len(r.arches) {
+		return nil, errors.Errorf("invalid arch ID %d; expected 1-%d", archID, len(r.arches))
+	}
+	return r.arches[archID-1], nil
+}
+
+// seeThrough returns the see-through variant of the given transparent wall
+// image. Like Diablo 1, every other pixel of the wall is left out in a
+// checkerboard pattern. The floor (i.e. the bottom-most row of blocks) is kept
+// intact.
+func seeThrough(img image.Image, tileHeight int) image.Image {
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+	const floorHeight = 32
+	for y := 0; y < tileHeight-floorHeight && y < bounds.Dy(); y++ {
+		for x := (y + 1) % 2; x < bounds.Dx(); x += 2 {
+			dst.SetNRGBA(x, y, color.NRGBA{})
+		}
+	}
+	return dst
+}
+
+// darknessTile returns the darkness tile of the given light level; the
+// bottom-most isometric tile of the dungeon piece covered in black, with an
+// opacity proportional to the light level.
+func darknessTile(dt *dtype.DungeonType, level int) image.Image {
+	dst := image.NewNRGBA(image.Rect(0, 0, dtype.TileWidth, dt.TileHeight))
+	c := color.NRGBA{A: uint8(255 * level / light.MaxLevel)}
+	const floorHeight = 32
+	for y := 0; y < floorHeight; y++ {
+		// Half width of the isometric tile at row y.
+		dy := y
+		if y >= floorHeight/2 {
+			dy = floorHeight - 1 - y
+		}
+		half := 2 * (dy + 1)
+		for x := dtype.TileWidth/2 - half; x < dtype.TileWidth/2+half; x++ {
+			dst.SetNRGBA(x, dt.TileHeight-floorHeight+y, c)
+		}
+	}
+	return dst
+}
+
+// palette returns the palette of the given theme.
+func (r *dpieceRenderer) palette(theme dtype.Theme) (color.Palette, error) {
+	if pal, ok := r.pals[theme.Palette]; ok {
+		return pal, nil
+	}
+	pal, err := loadPalette(r.assets, theme.Palette)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	r.pals[theme.Palette] = pal
+	return pal, nil
+}
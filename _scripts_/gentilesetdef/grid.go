@@ -0,0 +1,42 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+
+	"github.com/mewkiz/pkg/imgutil"
+	"github.com/pkg/errors"
+	"github.com/sanctuary/ember/dtype"
+)
+
+// genGrid stores an untrimmed tileset image of the given dungeon type and
+// palette theme in gridDir, for use by Tiled (e.g. as referred to by maps
+// generated by gentmx). Unlike tileset atlas images, the tiles are laid out in
+// a grid of tiles_per_row columns, each tile occupying a cell of 64 x
+// tile_height pixels in tile order, as Tiled requires of tileset images.
+func genGrid(r *dpieceRenderer, theme dtype.Theme, ntiles int, gridDir string) error {
+	dt := r.dt
+	nrows := (ntiles + dt.NTilesPerRow - 1) / dt.NTilesPerRow
+	dst := image.NewNRGBA(image.Rect(0, 0, dtype.TileWidth*dt.NTilesPerRow, dt.TileHeight*nrows))
+	for tile := 1; tile <= ntiles; tile++ {
+		img, err := r.image(theme, tile)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		index := tile - 1
+		x := (index % dt.NTilesPerRow) * dtype.TileWidth
+		y := (index / dt.NTilesPerRow) * dt.TileHeight
+		rect := image.Rect(x, y, x+dtype.TileWidth, y+dt.TileHeight)
+		draw.Draw(dst, rect, img, img.Bounds().Min, draw.Src)
+	}
+	if err := os.MkdirAll(gridDir, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	gridPath := filepath.Join(gridDir, dt.TilesetName(theme)+".png")
+	if err := imgutil.WriteFile(gridPath, dst); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -1,16 +1,21 @@
 // The gentilesetdef tool generates tileset definitions based on dungeon type.
+// Optionally, the tileset images are packed into texture atlases, with tileset
+// definitions matching the atlas layout.
 package main
 
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"image"
+	"io"
 	"log"
 	"os"
-	"path/filepath"
+	"strings"
 
-	"github.com/mewkiz/pkg/osutil"
-	"github.com/pkg/errors"
+	"github.com/sanctuary/ember/asset"
+	"github.com/sanctuary/ember/dtype"
+	"github.com/sanctuary/ember/light"
+	"github.com/sanctuary/ember/trans"
 )
 
 func usage() {
@@ -21,6 +26,37 @@ Usage:
 
 	gentilesetdef [OPTION]...
 
+Examples:
+
+	# Generate tileset definition of the Cathedral, without tileset images.
+	gentilesetdef -dtype l1 > tileset_cathedral_theme_1.txt
+
+	# Pack the dungeon pieces of the Cathedral into tileset images, and generate
+	# the matching tileset definition.
+	gentilesetdef -dtype l1 -trim -atlas ../mods/ember/images/tileset > tileset_cathedral_theme_1.txt
+
+	# Generate tileset definition of the gray palette theme of the Cathedral.
+	gentilesetdef -dtype l1 -theme gray > tileset_cathedral_gray.txt
+
+	# Generate tileset definition of the Cathedral, with arches as separate
+	# tiles following the dungeon pieces (for use in the object layer of maps
+	# generated by gentmx -archlayer).
+	gentilesetdef -dtype l1 -trim -archlayer -atlas ../mods/ember/images/tileset > tileset_cathedral_theme_1.txt
+
+	# Generate tileset definition of the Cathedral, with see-through variants of
+	# transparent walls as separate tiles following the dungeon pieces (for use
+	# by maps generated by gentmx -transparent).
+	gentilesetdef -dtype l1 -trim -transparent -atlas ../mods/ember/images/tileset > tileset_cathedral_theme_1.txt
+
+	# Generate an untrimmed tileset image of the Cathedral for Tiled, as referred
+	# to by maps generated by gentmx.
+	gentilesetdef -dtype l1 -grid ../tiled/tileset > /dev/null
+
+	# Generate tileset definition of the Caves, with darkness tiles of each light
+	# level following the other tiles (for use in the darkness layer of maps
+	# generated by gentmx -darkness).
+	gentilesetdef -dtype l3 -trim -darkness -atlas ../mods/ember/images/tileset > tileset_caves_theme_1.txt
+
 Flags:
 `
 	fmt.Fprintln(os.Stderr, use[1:])
@@ -30,78 +66,208 @@ Flags:
 func main() {
 	// Parse command line flags.
 	var (
-		// dtype specifies the dungeon type (town, l1, l2, l3 or l4).
-		dtype string
-		// mpqDir specifies the path to an extracted "diabdat.mpq".
-		mpqDir string
+		// dtypeName specifies the dungeon type (town, l1, l2, l3, l4, hftown, l5
+		// or l6).
+		dtypeName string
+		// themeName specifies the palette theme (e.g. "theme_1"); the default
+		// theme if empty.
+		themeName string
+		// dtypesPath specifies the path to additional dungeon type definitions.
+		dtypesPath string
+		// assetDir specifies the path to the directory containing the extracted
+		// MPQ archives (e.g. "diabdat" and "hellfire").
+		assetDir string
+		// mpqList specifies a comma-separated list of MPQ archives to read
+		// directly, without extraction.
+		mpqList string
+		// anim specifies whether to generate tileset animations of palette cycled
+		// dungeon pieces (e.g. lava and water).
+		anim bool
+		// trim specifies whether to trim transparent pixels from the bounding box
+		// of each tile.
+		trim bool
+		// archLayer specifies whether to output arches as separate tiles, rather
+		// than drawing arches onto dungeon pieces.
+		archLayer bool
+		// transparent specifies whether to output see-through variants of
+		// transparent walls.
+		transparent bool
+		// darkness specifies whether to output darkness tiles of each light
+		// level.
+		darkness bool
+		// atlasDir specifies the output directory of tileset atlas images.
+		atlasDir string
+		// maxSize specifies the maximum width and height in pixels of the tileset
+		// atlas image.
+		maxSize int
+		// gridDir specifies the output directory of untrimmed tileset images for
+		// Tiled.
+		gridDir string
 	)
-	flag.StringVar(&dtype, "dtype", "l1", "dungeon type (town, l1, l2, l3 or l4)")
-	flag.StringVar(&mpqDir, "mpqdir", "diabdat", `path to extracted "diabdat.mpq"`)
+	flag.StringVar(&dtypeName, "dtype", "l1", "dungeon type (town, l1, l2, l3, l4, hftown, l5 or l6)")
+	flag.StringVar(&themeName, "theme", "", `palette theme (e.g. "theme_1"); the default theme if empty`)
+	flag.StringVar(&dtypesPath, "dtypes", "", "path to additional dungeon type definitions")
+	flag.StringVar(&assetDir, "assetdir", ".", `path to directory containing extracted MPQ archives (e.g. "diabdat" and "hellfire")`)
+	flag.StringVar(&mpqList, "mpq", "", `comma-separated list of MPQ archives to read directly (e.g. "diabdat.mpq,hellfire.mpq"); overrides -assetdir`)
+	flag.BoolVar(&anim, "anim", false, "generate tileset animations of palette cycled dungeon pieces (requires -atlas)")
+	flag.BoolVar(&trim, "trim", false, "trim transparent pixels from the bounding box of each tile")
+	flag.BoolVar(&archLayer, "archlayer", false, "output arches as separate tiles following the dungeon pieces, rather than drawing arches onto dungeon pieces")
+	flag.BoolVar(&transparent, "transparent", false, "output see-through variants of transparent walls as separate tiles, following the dungeon pieces and arches")
+	flag.BoolVar(&darkness, "darkness", false, "output darkness tiles of each light level (1-15) as separate tiles, following the other tiles")
+	flag.StringVar(&atlasDir, "atlas", "", "output directory of tileset atlas images")
+	flag.IntVar(&maxSize, "maxsize", 8192, "maximum width and height in pixels of the tileset atlas image")
+	flag.StringVar(&gridDir, "grid", "", "output directory of untrimmed tileset images for Tiled, with tiles laid out in a grid of tiles_per_row columns")
 	flag.Usage = usage
 	flag.Parse()
-	if !osutil.Exists(mpqDir) {
-		log.Fatalf("unable to locate %q directory", mpqDir)
+	if anim && len(atlasDir) == 0 {
+		log.Fatal("-anim requires -atlas; animation frames are stored within tileset atlas images")
+	}
+	if len(dtypesPath) > 0 {
+		if err := dtype.Load(dtypesPath); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+	dt, err := dtype.Get(dtypeName)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	theme, err := dt.Theme(themeName)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	// Locate the extracted MPQ archives containing the level data.
+	assets, err := asset.Open(assetDir, mpqList)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if !assets.Has(dt.Archive) {
+		log.Fatalf("unable to locate extracted %q required by dungeon type %q", dt.Archive+".mpq", dt.Name)
+	}
+	if assets.Spawn() && !dt.Spawn {
+		log.Fatalf("dungeon type %q not contained within the shareware spawn.mpq", dt.Name)
 	}
 
 	// Determine dungeon type specific metrics.
 	var (
 		// Tile height in pixels of each tile within the tileset.
-		tileHeight int
+		tileHeight = dt.TileHeight
 		// Name of tileset.
-		tileset string
+		tileset = dt.TilesetName(theme)
 		// Number of tiles per row in tileset.
-		ntilesPerRow int
+		ntilesPerRow = dt.NTilesPerRow
 	)
-	switch dtype {
-	case "town":
-		tileHeight = 256
-		tileset = "tileset_tristram"
-		ntilesPerRow = 64
-	case "l1":
-		tileHeight = 160
-		tileset = "tileset_cathedral_theme_1"
-		ntilesPerRow = 32
-	case "l2":
-		tileHeight = 160
-		tileset = "tileset_catacombs_theme_1"
-		ntilesPerRow = 32
-	case "l3":
-		tileHeight = 160
-		tileset = "tileset_caves_theme_1"
-		ntilesPerRow = 32
-	case "l4":
-		tileHeight = 256
-		tileset = "tileset_hell_theme_1"
-		ntilesPerRow = 32
-	default:
-		panic(fmt.Errorf("support for dungeon type %q not yet implemented", dtype))
-	}
 
 	// Parse SOL file.
-	dtypeDataDir := fmt.Sprintf("%sdata", dtype)
-	solName := fmt.Sprintf("%s.sol", dtype)
-	solPath := filepath.Join(mpqDir, "levels", dtypeDataDir, solName)
-	sol, err := ioutil.ReadFile(solPath)
+	sol, err := assets.ReadFile(dt.SolPath())
 	if err != nil {
-		log.Fatalf("%+v", errors.WithStack(err))
+		log.Fatalf("%+v", err)
 	}
 
 	// Number of dungeon pieces contained within <dtype>.MIN
 	ndpieces := len(sol)
+	// Number of tiles; the dungeon pieces followed by the arches if output as
+	// separate tiles.
+	ntiles := ndpieces
+	if archLayer {
+		ntiles += dt.NArches()
+	}
+	// Dungeon pieces of transparent walls, with see-through variants following
+	// the other tiles.
+	var transIDs []int
+	if transparent {
+		transIDs = trans.DPieceIDs(sol)
+		ntiles += len(transIDs)
+	}
+	if darkness {
+		ntiles += light.MaxLevel
+	}
 
-	fmt.Printf("img=images/tilesets/%s.png\n\n", tileset)
-	x, y := 0, 0
-	const (
-		firstID   = 41
-		tileWidth = 64
-	)
-	for dpieceID := 1; dpieceID <= ndpieces; dpieceID++ {
-		id := firstID - 1 + dpieceID
-		fmt.Printf("tile=%d,%d,%d,64,%d,32,%d\n", id, x*tileWidth, y*tileHeight, tileHeight, tileHeight-16)
-		x++
-		if x >= ntilesPerRow {
-			x = 0
-			y++
+	// Prepare rendering of dungeon piece images, if required.
+	var r *dpieceRenderer
+	if anim || trim || len(atlasDir) > 0 || len(gridDir) > 0 {
+		r, err = newDPieceRenderer(dt, assets, ndpieces, transIDs, archLayer, darkness)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+
+	// Locate palette cycled dungeon pieces.
+	var anims []paletteAnim
+	if anim {
+		anims, err = findPaletteAnims(r, ndpieces)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+
+	// Store untrimmed tileset image for Tiled.
+	if len(gridDir) > 0 {
+		if err := genGrid(r, theme, ntiles, gridDir); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+
+	// Determine the bounding box of each tile, relative to the top-left corner
+	// of the dungeon piece image.
+	bounds := make([]image.Rectangle, ntiles+1)
+	for tile := 1; tile <= ntiles; tile++ {
+		bounds[tile] = image.Rect(0, 0, dtype.TileWidth, tileHeight)
+		if trim {
+			bounds[tile], err = trimBounds(r, tile)
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+		}
+	}
+
+	// Pack tileset atlas image, and output the matching tileset definition.
+	if len(atlasDir) > 0 {
+		if err := genAtlas(os.Stdout, r, theme, ntiles, bounds, anims, atlasDir, maxSize); err != nil {
+			log.Fatalf("%+v", err)
 		}
+		return
+	}
+
+	// pos returns the position in pixels of the given tile within the tileset
+	// image, adjusted by the bounding box of the tile. The dungeon pieces are
+	// followed by the arches output as separate tiles, the see-through variants
+	// of transparent walls, and the darkness tiles.
+	pos := func(index, tile int) image.Point {
+		x := (index%ntilesPerRow)*dtype.TileWidth + bounds[tile].Min.X
+		y := (index/ntilesPerRow)*tileHeight + bounds[tile].Min.Y
+		return image.Pt(x, y)
+	}
+	fmt.Printf("img=images/tileset/%s.png\n\n", tileset)
+	for tile := 1; tile <= ntiles; tile++ {
+		printTile(os.Stdout, dt, tile, pos(tile-1, tile), bounds[tile])
+	}
+}
+
+// firstID specifies the tile ID of the first dungeon piece; tile IDs below are
+// reserved for collision tiles.
+const firstID = 41
+
+// printTile prints the tileset definition of the given tile (i.e. dungeon piece
+// ID, ndpieces+archID for arches output as separate tiles, see-through
+// variant of transparent wall, or darkness tile), located at pos within the
+// tileset image, and with the specified bounding box relative to the top-left
+// corner of the dungeon piece image.
+func printTile(w io.Writer, dt *dtype.DungeonType, tile int, pos image.Point, bounds image.Rectangle) {
+	id := firstID - 1 + tile
+	// The origin of each tile is located at the center of the bottom-most 64x32
+	// isometric tile of the dungeon piece.
+	ox, oy := dtype.TileWidth/2-bounds.Min.X, dt.TileHeight-16-bounds.Min.Y
+	fmt.Fprintf(w, "tile=%d,%d,%d,%d,%d,%d,%d\n", id, pos.X, pos.Y, bounds.Dx(), bounds.Dy(), ox, oy)
+}
+
+// printAnim prints the tileset animation of the given dungeon piece, with
+// animation frames located at the specified positions within the tileset image.
+func printAnim(w io.Writer, dt *dtype.DungeonType, dpieceID int, frames []image.Point) {
+	id := firstID - 1 + dpieceID
+	duration := dt.PaletteCycle.FrameDuration
+	var fs []string
+	for _, frame := range frames {
+		fs = append(fs, fmt.Sprintf("%d,%d,%dms", frame.X, frame.Y, duration))
 	}
+	fmt.Fprintf(w, "animation=%d,%s\n", id, strings.Join(fs, ";"))
 }
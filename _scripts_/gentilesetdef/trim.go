@@ -0,0 +1,37 @@
+package main
+
+import (
+	"image"
+
+	"github.com/pkg/errors"
+	"github.com/sanctuary/ember/dtype"
+)
+
+// trimBounds returns the bounding box of the non-transparent pixels of the
+// given tile (i.e. dungeon piece or arch), relative to the top-left corner of
+// the tile image. The bounding box of fully transparent tiles is a single pixel
+// at the origin of the tile.
+func trimBounds(r *dpieceRenderer, tile int) (image.Rectangle, error) {
+	dt := r.dt
+	img, err := r.image(dt.DefaultTheme(), tile)
+	if err != nil {
+		return image.Rectangle{}, errors.WithStack(err)
+	}
+	bounds := img.Bounds()
+	var trimmed image.Rectangle
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a == 0 {
+				continue
+			}
+			pt := image.Pt(x-bounds.Min.X, y-bounds.Min.Y)
+			trimmed = trimmed.Union(image.Rectangle{Min: pt, Max: pt.Add(image.Pt(1, 1))})
+		}
+	}
+	if trimmed.Empty() {
+		// Transparent tile.
+		x, y := dtype.TileWidth/2, dt.TileHeight-16
+		return image.Rect(x, y, x+1, y+1), nil
+	}
+	return trimmed, nil
+}
@@ -7,17 +7,24 @@ import (
 	"encoding/binary"
 	"flag"
 	"fmt"
+	"image"
 	"io"
 	"io/ioutil"
 	"log"
 	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"text/template"
 
-	"github.com/mewkiz/pkg/osutil"
+	"github.com/mewkiz/pkg/pathutil"
 	"github.com/pkg/errors"
+	"github.com/sanctuary/ember/asset"
+	"github.com/sanctuary/ember/collision"
+	"github.com/sanctuary/ember/dtype"
+	"github.com/sanctuary/ember/light"
+	"github.com/sanctuary/ember/trans"
 )
 
 func usage() {
@@ -28,6 +35,39 @@ Usage:
 
 	gentmx [OPTION]... FILE.bin
 
+The palette theme of the map is chosen based on the level seed, as given by
+-seed or by the hexadecimal suffix of the file name (e.g. "l1_00A3F2C1.bin"),
+unless specified by -theme. The default theme is used if the level seed is
+unknown.
+
+If -archlayer is set, arches are placed in the object layer of the map, rather
+than drawn onto the dungeon pieces of the background layer, so that the arches
+are depth sorted against actors. The arches are located by the tiles following
+the dungeon pieces of the tileset (as output by gentilesetdef -archlayer).
+Arches placed on neighbouring dungeon pieces (e.g. the doorway arches of the
+Catacombs) depend on the surrounding map, and are thus only placed with
+-archlayer.
+
+If -transparent is set, the see-through variants of transparent walls are placed
+in the hidden transparent layer of the map (as output by gentilesetdef
+-transparent), to replace the walls of the background layer when the player is
+located within the transparency region of the walls. The transparency region of
+each cell is stored in the file given by -regions, as comma-separated values
+with the same layout as the layers of the map.
+
+The tileset of the map refers to the untrimmed tileset image of Tiled (e.g.
+"../tiled/tileset/tileset_cathedral_theme_1.png", as output by gentilesetdef
+-grid), rather than the packed tileset atlas image of FLARE, as Tiled requires
+tiles laid out in a grid. The tileset image must be generated with the same
+-archlayer, -transparent and -darkness flags as the map.
+
+If -darkness is set, the darkness of dark dungeon types is placed in the
+darkness layer of the map, as darkness tiles following the other tiles of the
+tileset (as output by gentilesetdef -darkness). The darkness is given by the
+light sources of the map (e.g. lava). FLARE has no dynamic lighting, so the
+light radius of the player is accounted for by capping the darkness at the
+light level of the player's light at the edge of the view.
+
 Flags:
 `
 	fmt.Fprintln(os.Stderr, use[1:])
@@ -37,15 +77,45 @@ Flags:
 func main() {
 	// Parse command line flags.
 	var (
-		// dtype specifies the dungeon type (town, l1, l2, l3 or l4).
-		dtype string
-		// mpqDir specifies the path to an extracted "diabdat.mpq".
-		mpqDir string
+		// dtypeName specifies the dungeon type (town, l1, l2, l3, l4, hftown, l5
+		// or l6).
+		dtypeName string
+		// themeName specifies the palette theme (e.g. "theme_1"); chosen based on
+		// the level seed if empty.
+		themeName string
+		// seedHex specifies the level seed in hexadecimal; parsed from the file
+		// name if empty.
+		seedHex string
+		// dtypesPath specifies the path to additional dungeon type definitions.
+		dtypesPath string
+		// assetDir specifies the path to the directory containing the extracted
+		// MPQ archives (e.g. "diabdat" and "hellfire").
+		assetDir string
+		// mpqList specifies a comma-separated list of MPQ archives to read
+		// directly, without extraction.
+		mpqList string
+		// archLayer specifies whether to place arches in the object layer.
+		archLayer bool
+		// transparent specifies whether to place see-through variants of
+		// transparent walls in the transparent layer.
+		transparent bool
+		// regionsPath specifies the output path of the transparency regions.
+		regionsPath string
+		// darkness specifies whether to place darkness in the darkness layer.
+		darkness bool
 		// output specifies the output path.
 		output string
 	)
-	flag.StringVar(&dtype, "dtype", "l1", "dungeon type (town, l1, l2, l3 or l4)")
-	flag.StringVar(&mpqDir, "mpqdir", "diabdat", `path to extracted "diabdat.mpq"`)
+	flag.StringVar(&dtypeName, "dtype", "l1", "dungeon type (town, l1, l2, l3, l4, hftown, l5 or l6)")
+	flag.StringVar(&themeName, "theme", "", `palette theme (e.g. "theme_1"); chosen based on the level seed if empty`)
+	flag.StringVar(&seedHex, "seed", "", "level seed in hexadecimal; parsed from the file name if empty")
+	flag.StringVar(&dtypesPath, "dtypes", "", "path to additional dungeon type definitions")
+	flag.StringVar(&assetDir, "assetdir", ".", `path to directory containing extracted MPQ archives (e.g. "diabdat" and "hellfire")`)
+	flag.StringVar(&mpqList, "mpq", "", `comma-separated list of MPQ archives to read directly (e.g. "diabdat.mpq,hellfire.mpq"); overrides -assetdir`)
+	flag.BoolVar(&archLayer, "archlayer", false, "place arches in the object layer, as tiles following the dungeon pieces of the tileset")
+	flag.BoolVar(&transparent, "transparent", false, "place see-through variants of transparent walls in the transparent layer, as tiles following the dungeon pieces and arches of the tileset")
+	flag.StringVar(&regionsPath, "regions", "", "output path of transparency regions")
+	flag.BoolVar(&darkness, "darkness", false, "place darkness of dark dungeon types in the darkness layer, as tiles following the other tiles of the tileset")
 	flag.StringVar(&output, "o", "", "output path")
 	flag.Usage = usage
 	flag.Parse()
@@ -54,8 +124,29 @@ func main() {
 		os.Exit(1)
 	}
 	binPath := flag.Arg(0)
-	if !osutil.Exists(mpqDir) {
-		log.Fatalf("unable to locate %q directory", mpqDir)
+	if len(dtypesPath) > 0 {
+		if err := dtype.Load(dtypesPath); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+	dt, err := dtype.Get(dtypeName)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	theme, err := levelTheme(dt, themeName, seedHex, binPath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	// Locate the extracted MPQ archives containing the level data.
+	assets, err := asset.Open(assetDir, mpqList)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if !assets.Has(dt.Archive) {
+		log.Fatalf("unable to locate extracted %q required by dungeon type %q", dt.Archive+".mpq", dt.Name)
+	}
+	if assets.Spawn() && !dt.Spawn {
+		log.Fatalf("dungeon type %q not contained within the shareware spawn.mpq", dt.Name)
 	}
 
 	// Create output file if specified by `-o`.
@@ -70,68 +161,55 @@ func main() {
 	}
 
 	// Generate TMX map.
-	if err := gentmx(w, binPath, dtype, mpqDir); err != nil {
+	if err := gentmx(w, binPath, dt, theme, assets, archLayer, transparent, regionsPath, darkness); err != nil {
 		log.Fatalf("%+v", err)
 	}
 }
 
-// gentmx generates a TMX map for the specified dungeon type, based on the
-// dungeon pieces contained within the given file.
-func gentmx(w io.Writer, binPath, dtype, mpqDir string) error {
+// levelTheme returns the palette theme of the given level. The theme is given
+// by name if non-empty, and chosen based on the level seed otherwise. The level
+// seed is given in hexadecimal by seedHex if non-empty, and by the suffix of the
+// base name of binPath otherwise (e.g. "l1_00A3F2C1.bin").
+func levelTheme(dt *dtype.DungeonType, themeName, seedHex, binPath string) (dtype.Theme, error) {
+	if len(themeName) > 0 {
+		return dt.Theme(themeName)
+	}
+	if len(seedHex) == 0 {
+		name := pathutil.TrimExt(filepath.Base(binPath))
+		pos := strings.LastIndex(name, "_")
+		if pos == -1 || len(name[pos+1:]) != 8 {
+			// Level seed unknown; use default theme.
+			return dt.DefaultTheme(), nil
+		}
+		seedHex = name[pos+1:]
+	}
+	seed, err := strconv.ParseUint(seedHex, 16, 32)
+	if err != nil {
+		return dtype.Theme{}, errors.Wrapf(err, "unable to parse level seed %q", seedHex)
+	}
+	return dt.LevelTheme(int32(seed))
+}
+
+// gentmx generates a TMX map for the specified dungeon type and palette theme,
+// based on the dungeon pieces contained within the given file. If archLayer is
+// set, arches are placed in the object layer. If transparent is set, see-through
+// variants of transparent walls are placed in the transparent layer. The
+// transparency regions are stored in regionsPath if non-empty. If darkness is
+// set, the darkness of dark dungeon types is placed in the darkness layer.
+func gentmx(w io.Writer, binPath string, dt *dtype.DungeonType, theme dtype.Theme, assets *asset.Resolver, archLayer, transparent bool, regionsPath string, darkness bool) error {
 	// Determine dungeon type specific properties.
 	var (
 		// Map width in number of cels.
-		mapWidth int
+		mapWidth = dt.MapWidth
 		// Map height in number of cels
-		mapHeight int
-		// Map title.
-		title string
+		mapHeight = dt.MapHeight
 		// Name of tileset.
-		tileset string
+		tileset = dt.TilesetName(theme)
 		// Number of tiles per row in tileset.
-		ntilesPerRow int
+		ntilesPerRow = dt.NTilesPerRow
 		// Tile height in pixels of each tile within the tileset.
-		tileHeight int
+		tileHeight = dt.TileHeight
 	)
-	switch dtype {
-	case "town":
-		mapWidth = 96
-		mapHeight = 96
-		title = "tristram"
-		tileset = "tileset_tristram"
-		ntilesPerRow = 64
-		tileHeight = 256
-	case "l1":
-		mapWidth = 112
-		mapHeight = 112
-		title = "cathedral"
-		tileset = "tileset_cathedral_theme_1"
-		ntilesPerRow = 32
-		tileHeight = 160
-	case "l2":
-		mapWidth = 112
-		mapHeight = 112
-		title = "catacombs"
-		tileset = "tileset_catacombs_theme_1"
-		ntilesPerRow = 32
-		tileHeight = 160
-	case "l3":
-		mapWidth = 112
-		mapHeight = 112
-		title = "caves"
-		tileset = "tileset_caves_theme_1"
-		ntilesPerRow = 32
-		tileHeight = 160
-	case "l4":
-		mapWidth = 112
-		mapHeight = 112
-		title = "hell"
-		tileset = "tileset_hell_theme_1"
-		ntilesPerRow = 32
-		tileHeight = 256
-	default:
-		panic(fmt.Errorf("support for dungeon type %q not yet implemented", dtype))
-	}
 
 	// Parse file containing sequence of dungeon pieces (i.e. miniture tiles).
 	bin, err := ioutil.ReadFile(binPath)
@@ -145,44 +223,125 @@ func gentmx(w io.Writer, binPath, dtype, mpqDir string) error {
 	}
 
 	// Parse SOL file.
-	relSolPath := fmt.Sprintf("levels/%sdata/%s.sol", dtype, dtype)
-	solPath := filepath.Join(mpqDir, relSolPath)
-	sol, err := ioutil.ReadFile(solPath)
+	sol, err := assets.ReadFile(dt.SolPath())
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
 	// Number of dungeon pieces contained within <dtype>.MIN
 	ndpieces := len(sol)
-	// Tile width in pixels of each tile within the tileset.
-	const tileWidth = 64
+	// Number of tiles; the dungeon pieces followed by the arches if placed in the
+	// object layer.
+	ntiles := ndpieces
+	if archLayer {
+		ntiles += dt.NArches()
+	}
+	// Tile IDs of see-through variants of transparent walls, which follow the
+	// dungeon pieces and arches.
+	transTileIDs := make(map[int]int)
+	if transparent {
+		for i, dpieceID := range trans.DPieceIDs(sol) {
+			transTileIDs[dpieceID] = firstID + ntiles + i
+		}
+		ntiles += len(transTileIDs)
+	}
+	// Tile ID of the first darkness tile (light level 1), which follow the other
+	// tiles.
+	firstDarknessID := firstID + ntiles
+	if darkness {
+		ntiles += light.MaxLevel
+	}
 	// Tileset width in pixels.
-	tilesetWidth := tileWidth * ntilesPerRow
+	tilesetWidth := dtype.TileWidth * ntilesPerRow
 	// Tileset height in pixels.
-	tilesetHeight := tileHeight * int(math.Ceil(float64(ndpieces)/float64(ntilesPerRow)))
+	tilesetHeight := tileHeight * int(math.Ceil(float64(ntiles)/float64(ntilesPerRow)))
 	background := make([][]int, mapWidth)
 	for i := range background {
 		background[i] = make([]int, mapHeight)
 	}
-	collision := make([][]int, mapWidth)
-	for i := range collision {
-		collision[i] = make([]int, mapHeight)
+	dpieces := make([][]int, mapWidth)
+	for i := range dpieces {
+		dpieces[i] = make([]int, mapHeight)
+	}
+	var transLayer [][]int
+	if transparent {
+		transLayer = make([][]int, mapWidth)
+		for i := range transLayer {
+			transLayer[i] = make([]int, mapHeight)
+		}
+	}
+	collisions := make([][]int, mapWidth)
+	for i := range collisions {
+		collisions[i] = make([]int, mapHeight)
 	}
 	r := bytes.NewReader(bin)
-	const firstID = 41
 	for y := 0; y < mapHeight; y++ {
 		for x := 0; x < mapWidth; x++ {
 			var dpieceID int32
 			if err := binary.Read(r, binary.LittleEndian, &dpieceID); err != nil {
 				return errors.WithStack(err)
 			}
-			collision[x][y] = solid(sol, dpieceID)
+			col, err := collision.Solid(sol, dt.Name, int(dpieceID))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			collisions[x][y] = col
+			dpieces[x][y] = int(dpieceID)
+			if transparent {
+				transLayer[x][y] = transTileIDs[int(dpieceID)]
+			}
 			if dpieceID != 0 {
 				background[x][y] = firstID - 1 + int(dpieceID)
 			}
 		}
 	}
 
+	// Place arches in the object layer, including the arches placed on
+	// neighbouring dungeon pieces.
+	var object [][]int
+	if archLayer {
+		object = dt.PlaceArches(dpieces)
+		for x := range object {
+			for y, archID := range object[x] {
+				if archID == dtype.ArchNone {
+					continue
+				}
+				object[x][y] = firstID - 1 + ndpieces + archID
+			}
+		}
+	}
+
+	// Store transparency regions.
+	if len(regionsPath) > 0 {
+		regions := trans.Regions(dpieces, sol, dt.IsDoor)
+		if err := writeCSV(regionsPath, regions); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	// Place darkness of dark dungeon types, as lit by the light sources of the
+	// map.
+	var darknessLayer [][]int
+	if darkness && dt.Dark {
+		var sources []light.Source
+		for x := range dpieces {
+			for y, dpieceID := range dpieces[x] {
+				if radius := dt.LightRadius(dpieceID); radius > 0 {
+					sources = append(sources, light.Source{Pos: image.Pt(x, y), Radius: radius})
+				}
+			}
+		}
+		darknessLayer = light.Darkness(mapWidth, mapHeight, sources)
+		for x := range darknessLayer {
+			for y, level := range darknessLayer[x] {
+				if level == 0 {
+					continue
+				}
+				darknessLayer[x][y] = firstDarknessID - 1 + level
+			}
+		}
+	}
+
 	funcMap := map[string]interface{}{
 		"title": strings.Title,
 	}
@@ -193,14 +352,18 @@ func gentmx(w io.Writer, binPath, dtype, mpqDir string) error {
 	m := map[string]interface{}{
 		"MapWidth":      mapWidth,
 		"MapHeight":     mapHeight,
-		"Title":         title,
+		"Title":         dt.Title,
+		"Music":         dt.Music,
 		"Tileset":       tileset,
 		"FirstID":       firstID,
 		"TileHeight":    tileHeight,
 		"TilesetWidth":  tilesetWidth,
 		"TilesetHeight": tilesetHeight,
 		"Background":    background,
-		"Collision":     collision,
+		"Object":        object,
+		"Transparent":   transLayer,
+		"Darkness":      darknessLayer,
+		"Collision":     collisions,
 	}
 	if err := t.Execute(w, m); err != nil {
 		return errors.WithStack(err)
@@ -208,11 +371,37 @@ func gentmx(w io.Writer, binPath, dtype, mpqDir string) error {
 	return nil
 }
 
+// writeCSV stores the given values of each cell of a map as comma-separated
+// values, with the same layout as the layers of TMX maps.
+func writeCSV(path string, cells [][]int) error {
+	buf := &bytes.Buffer{}
+	for i, row := range cells {
+		if i != 0 {
+			buf.WriteString(",\n")
+		}
+		for j, v := range row {
+			if j != 0 {
+				buf.WriteString(",")
+			}
+			fmt.Fprintf(buf, "%d", v)
+		}
+	}
+	buf.WriteString("\n")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// firstID specifies the tile ID of the first dungeon piece; tile IDs below are
+// reserved for collision tiles.
+const firstID = 41
+
 const tmxData = `
 <?xml version="1.0" encoding="UTF-8"?>
 <map version="1.0" orientation="isometric" width="{{ .MapWidth }}" height="{{ .MapHeight }}" tilewidth="64" tileheight="32">
  <properties>
-  <property name="music" value="music/{{ .Title }}.ogg"/>
+  <property name="music" value="{{ .Music }}"/>
   <property name="tileset" value="tilesetdefs/{{ .Tileset }}.txt"/>
   <property name="title" value="{{ title .Title }}"/>
  </properties>
@@ -220,7 +409,7 @@ const tmxData = `
   <image source="../tiled_collision.png" width="512" height="160"/>
  </tileset>
  <tileset firstgid="{{ .FirstID }}" name="{{ .Title }}" tilewidth="64" tileheight="{{ .TileHeight }}">
-  <image source="../../mods/ember/images/tilesets/{{ .Tileset }}.png" width="{{ .TilesetWidth }}" height="{{ .TilesetHeight }}"/>
+  <image source="../tileset/{{ .Tileset }}.png" width="{{ .TilesetWidth }}" height="{{ .TilesetHeight }}"/>
  </tileset>
  <layer name="background" width="{{ .MapWidth }}" height="{{ .MapWidth }}">
   <data encoding="csv">
@@ -237,6 +426,57 @@ const tmxData = `
 {{- end }}
   </data>
  </layer>
+{{- if .Object }}
+ <layer name="object" width="{{ .MapWidth }}" height="{{ .MapWidth }}">
+  <data encoding="csv">
+{{ range $i, $v := .Object }}
+	{{- if ne $i 0 }}
+		{{- printf ",\n" }}
+	{{- end }}
+	{{- range $j, $u := . }}
+		{{- if ne $j 0 }}
+			{{- printf "," }}
+		{{- end }}
+		{{- printf "%d" $u }}
+	{{- end }}
+{{- end }}
+  </data>
+ </layer>
+{{- end }}
+{{- if .Transparent }}
+ <layer name="transparent" width="{{ .MapWidth }}" height="{{ .MapWidth }}" visible="0">
+  <data encoding="csv">
+{{ range $i, $v := .Transparent }}
+	{{- if ne $i 0 }}
+		{{- printf ",\n" }}
+	{{- end }}
+	{{- range $j, $u := . }}
+		{{- if ne $j 0 }}
+			{{- printf "," }}
+		{{- end }}
+		{{- printf "%d" $u }}
+	{{- end }}
+{{- end }}
+  </data>
+ </layer>
+{{- end }}
+{{- if .Darkness }}
+ <layer name="darkness" width="{{ .MapWidth }}" height="{{ .MapWidth }}">
+  <data encoding="csv">
+{{ range $i, $v := .Darkness }}
+	{{- if ne $i 0 }}
+		{{- printf ",\n" }}
+	{{- end }}
+	{{- range $j, $u := . }}
+		{{- if ne $j 0 }}
+			{{- printf "," }}
+		{{- end }}
+		{{- printf "%d" $u }}
+	{{- end }}
+{{- end }}
+  </data>
+ </layer>
+{{- end }}
  <layer name="collision" width="{{ .MapWidth }}" height="{{ .MapWidth }}" visible="0">
   <data encoding="csv">
 {{ range $i, $v := .Collision }}
@@ -254,72 +494,3 @@ const tmxData = `
  </layer>
 </map>
 `
-
-const (
-	BLOCKS_NONE            = 0
-	BLOCKS_ALL             = 1 // block all
-	BLOCKS_MOVEMENT        = 2 // block movement
-	BLOCKS_ALL_HIDDEN      = 3 // block all (not visible on mini map)
-	BLOCKS_MOVEMENT_HIDDEN = 4 // block movement (not visible on mini map)
-)
-
-func solid(sol []byte, dpieceID int32) int {
-	if dpieceID == 0 {
-		// TODO: set collision later.
-		return BLOCKS_ALL
-	}
-	if isL1Door(dpieceID) {
-		// TODO: Handle doors by replacing their tiles with open doors, and adding
-		// interactable objects (with their own collision) to display the doors.
-
-		// Return 0 to skip collision for now.
-		return 0
-	}
-	col := sol[dpieceID-1]
-	const (
-		solBlockWalk    = 0x01 // block walk
-		sol02           = 0x02 // lighting?
-		solBlockMissile = 0x04 // block missile
-		sol08           = 0x08 // transparency?
-		sol10           = 0x10 // sw wall
-		sol20           = 0x20 // se wall
-		sol40           = 0x40
-		sol80           = 0x80 // fit shrine
-	)
-
-	switch {
-	// prioritize block movement over block all.
-	case col&solBlockWalk != 0:
-		if col&solBlockMissile != 0 {
-			return BLOCKS_ALL
-		}
-		return BLOCKS_ALL
-	case col&solBlockMissile != 0:
-		return BLOCKS_MOVEMENT
-	//case col&sol02 != 0:
-	//	return 2
-	//case col&sol08 != 0:
-	//	return 4
-	//case col&sol10 != 0:
-	//	return 5
-	//case col&sol20 != 0:
-	//	return 6
-	//case col&sol40 != 0:
-	//	return 7
-	//case col&sol80 != 0:
-	//	return 8
-	default:
-		return 0
-	}
-}
-
-func isL1Door(dpieceID int32) bool {
-	if dpieceID == 0 {
-		return false
-	}
-	switch dpieceID {
-	case 44, 46, 51, 56, 214, 393, 395, 408:
-		return true
-	}
-	return false
-}
@@ -2,260 +2,353 @@
 
 // Open Source-ami converts the original Diablo 1 game assets into the file
 // formats used by Ember.
+//
+// The conversion is a pipeline of named steps (e.g. tilesets, monsters and
+// music), run in dependency order from the asset directory ("_assets_"). The
+// input and output hashes of each output are recorded in a manifest, and
+// outputs whose inputs (game assets, tools and flags) and contents are unchanged
+// since the last run are not rebuilt, unless forced. The commands of each step (e.g. one per palette
+// theme, monster or music track) are run concurrently.
 package main
 
 import (
 	"flag"
-	"html/template"
-	"io"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 
-	"github.com/mewkiz/pkg/goutil"
+	"github.com/mewkiz/pkg/pathutil"
+	"github.com/mewkiz/pkg/term"
 	"github.com/pkg/errors"
+	"github.com/sanctuary/ember/asset"
+	"github.com/sanctuary/ember/dtype"
 )
 
+// dbg represents a logger with the "opensourceami:" prefix, which logs debug
+// messages to standard error.
+var dbg = log.New(os.Stderr, term.MagentaBold("opensourceami:")+" ", 0)
+
+func usage() {
+	const use = `
+Convert the original Diablo 1 game assets into the file formats used by Ember.
+
+Usage:
+
+	opensourceami [OPTION]...
+
+Run from the asset directory ("_assets_"), containing the extracted MPQ archives
+(e.g. "diabdat" and "hellfire").
+
+Alternatively, the MPQ archives are read directly by the tools of the pipeline,
+without extraction, if given by -mpq (e.g. "diabdat.mpq,hellfire.mpq"); the
+pipeline then starts from the MPQ archives, which are hashed as the inputs of
+each output. The version of MPQ archives read directly is not identified, as
+known versions hold the file hashes of extracted MPQ archives; the shareware
+spawn.mpq is told apart by its archive name.
+
+Sound effects are converted and towners are extracted if diablo.exe is present
+in the asset directory, as the sound effects are located by the sound effect
+table of diablo.exe, and towners are animated by its animation order table.
+
+Only outputs whose inputs or contents changed since the last run are rebuilt;
+the input and output hashes of each output are recorded in the manifest
+(opensourceami_manifest.txt).
+Level graphics are decoded in-process, so there are no steps dumping
+intermediate images (_dump_) or drawing arches onto them (fixarches).
+
+Before running, the version of the game assets (1.00, 1.09, spawn) is
+identified, and missing and modified files are reported. Versions are told
+apart by the table of known file hashes given by -versions, as generated from a
+verified copy of the game assets (-dumpversion); no table is built in. Game
+assets not matching any known version are identified by directory layout; e.g.
+spawn.mpq lacks the Catacombs level data.
+
+The shareware spawn.mpq may be used in place of diabdat.mpq, extracted into
+"_assets_/spawn". Only Tristram and the Cathedral are converted from spawn.mpq,
+and assets missing from spawn.mpq (e.g. most monsters) are skipped.
+
+Examples:
+
+	# List the steps of the pipeline.
+	opensourceami -list
+
+	# Convert game assets, regenerating monster graphics.
+	opensourceami -force monsters
+
+	# Convert game assets read directly from the MPQ archives.
+	opensourceami -hellfire -mpq diabdat.mpq,hellfire.mpq,hfmusic.mpq
+
+	# Convert game assets, running up to 4 commands concurrently.
+	opensourceami -j 4
+
+	# Record the file hashes of a verified copy of Diablo 1.09.
+	opensourceami -dumpversion 1.09 > versions.txt
+
+	# Convert game assets, verifying the file hashes of known versions.
+	opensourceami -versions versions.txt
+
+	# Output the shell script of the pipeline, without running it.
+	opensourceami -script -o opensourceami.sh
+
+Flags:
+`
+	fmt.Fprintln(os.Stderr, use[1:])
+	flag.PrintDefaults()
+}
+
 func main() {
 	// Parse command line arguments.
 	var (
-		// output specifies the output path.
+		// dtypesPath specifies the path to additional dungeon type definitions.
+		dtypesPath string
+		// hellfire specifies whether to convert the Hellfire game assets.
+		hellfire bool
+		// script specifies whether to output the shell script of the pipeline
+		// instead of running it.
+		script bool
+		// output specifies the output path of the shell script.
 		output string
+		// list specifies whether to list the steps of the pipeline.
+		list bool
+		// skip specifies a comma-separated list of steps to skip.
+		skip string
+		// force specifies a comma-separated list of steps to run even if their
+		// output is up to date; "all" forces every step.
+		force string
+		// manifestPath specifies the path to the manifest of input and output
+		// hashes.
+		manifestPath string
+		// njobs specifies the maximum number of concurrent commands.
+		njobs int
+		// versionsPath specifies the path to a table of known versions of the
+		// game assets.
+		versionsPath string
+		// dumpVersion specifies the version name of table entries to output for
+		// the game assets; empty if not set.
+		dumpVersion string
+		// mpqList specifies a comma-separated list of MPQ archives to read
+		// directly, without extraction.
+		mpqList string
 	)
-	flag.StringVar(&output, "o", "", "output path")
+	flag.StringVar(&dtypesPath, "dtypes", "", "path to additional dungeon type definitions")
+	flag.BoolVar(&hellfire, "hellfire", false, `convert Hellfire game assets (requires "hellfire" directory)`)
+	flag.BoolVar(&script, "script", false, "output shell script instead of running the pipeline")
+	flag.StringVar(&output, "o", "", "output path of shell script")
+	flag.BoolVar(&list, "list", false, "list steps of the pipeline")
+	flag.StringVar(&skip, "skip", "", `comma-separated list of steps to skip (e.g. "music,cursors")`)
+	flag.StringVar(&force, "force", "", `comma-separated list of steps to run even if their output is up to date, or "all"`)
+	flag.StringVar(&manifestPath, "manifest", "opensourceami_manifest.txt", "path to manifest of input and output hashes")
+	flag.IntVar(&njobs, "j", runtime.NumCPU(), "maximum number of concurrent commands")
+	flag.StringVar(&versionsPath, "versions", "", "path to table of known versions of game assets (file hashes, as output by -dumpversion)")
+	flag.StringVar(&dumpVersion, "dumpversion", "", `output table entries of the given version name (e.g. "1.09") for the game assets, without running the pipeline`)
+	flag.StringVar(&mpqList, "mpq", "", `comma-separated list of MPQ archives to read directly (e.g. "diabdat.mpq,hellfire.mpq"), rather than extracted MPQ archives`)
+	flag.Usage = usage
 	flag.Parse()
+	if len(mpqList) > 0 && (len(versionsPath) > 0 || len(dumpVersion) > 0) {
+		log.Fatal("-versions and -dumpversion require extracted MPQ archives; not supported with -mpq")
+	}
+	if len(dtypesPath) > 0 {
+		if err := dtype.Load(dtypesPath); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+
+	m, err := loadManifest(manifestPath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	dts, err := dungeonTypes(hellfire)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
 
-	// Create output file if specified by `-o`.
-	w := os.Stdout
-	if len(output) > 0 {
-		f, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	// Identify version of game assets.
+	cfg := &config{
+		hellfire:   hellfire,
+		dtypesPath: dtypesPath,
+	}
+	var report *preflightReport
+	if len(mpqList) > 0 {
+		cfg.mpqList = mpqList
+		cfg.mpqs, cfg.assets, err = openMPQs(mpqList)
 		if err != nil {
-			log.Fatalf("unable to create %q; %v", output, err)
+			log.Fatalf("%+v", err)
+		}
+		report = identifyMPQ(cfg.assets)
+	} else {
+		var versions []*knownVersion
+		if len(versionsPath) > 0 {
+			if versions, err = loadVersions(versionsPath); err != nil {
+				log.Fatalf("%+v", err)
+			}
+		}
+		if report, err = identify(versions, m); err != nil {
+			log.Fatalf("%+v", err)
 		}
-		defer f.Close()
-		w = f
 	}
+	cfg.diabdatDir = report.diabdatDir
+	cfg.spawn = report.spawn
 
-	// Generate game assets script.
-	if err := opensourceami(w); err != nil {
-		log.Fatalf("%+v", err)
+	// Output table entries of game assets if specified by `-dumpversion`.
+	if len(dumpVersion) > 0 {
+		cfg.dts = dts
+		required, err := archiveInputs(pipeline(cfg))
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		if err := writeVersion(os.Stdout, m, dumpVersion, required); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
 	}
-}
 
-// opensourceami generates a script for converting the original Diablo 1 game
-// assets into the file formats used by Ember.
-func opensourceami(w io.Writer) error {
-	blizzconvDir, err := goutil.SrcDir("github.com/mewrnd/blizzconv")
+	// Setup pipeline.
+	for _, dt := range dts {
+		if report.supportsDType(dt) {
+			cfg.dts = append(cfg.dts, dt)
+		}
+	}
+	steps, err := order(pipeline(cfg))
 	if err != nil {
-		return errors.WithStack(err)
+		log.Fatalf("%+v", err)
+	}
+	if list {
+		for _, s := range steps {
+			fmt.Printf("%-10s %s\n", s.name, s.desc)
+		}
+		return
+	}
+	required, err := archiveInputs(steps)
+	if err != nil {
+		log.Fatalf("%+v", err)
 	}
-	t, err := template.New("opensourceami").Parse(script[1:])
+	report.locateMissing(required)
+	report.log()
+	skipped, err := stepSet(steps, skip)
 	if err != nil {
-		return errors.WithStack(err)
+		log.Fatalf("%+v", err)
+	}
+	forced, err := stepSet(steps, force)
+	if err != nil {
+		log.Fatalf("%+v", err)
 	}
-	m := map[string]interface{}{
-		"BlizzconvDir": blizzconvDir,
+	var selected []*step
+	for _, s := range steps {
+		if !skipped[s.name] {
+			selected = append(selected, s)
+		}
 	}
-	if err := t.Execute(w, m); err != nil {
-		return errors.WithStack(err)
+
+	// Output shell script if specified by `-script`.
+	if script {
+		w := os.Stdout
+		if len(output) > 0 {
+			f, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+			if err != nil {
+				log.Fatalf("unable to create %q; %v", output, err)
+			}
+			defer f.Close()
+			w = f
+		}
+		writeScript(w, selected, func(name string) bool { return forced[name] })
+		return
+	}
+
+	// Run pipeline.
+	if err := runPipeline(selected, m, forced, njobs); err != nil {
+		log.Fatalf("%+v", err)
 	}
-	return nil
 }
 
-const script = `
-#!/bin/bash
-
-# Locate extracted diabdat.mpq
-if [ ! -f "diabdat/levels/towndata/town.cel" ]; then
-	echo "Unable to locate \"diabdat\" directory containing the contents of diabdat.mpq"
-	echo ""
-	echo "   Please extract diabdat.mpq to \"_assets_/diabdat/\" using"
-	echo "   Ladislav Zezula's MPQ Editor [1]."
-	echo ""
-	echo "   [1]: http://www.zezula.net/en/mpq/download.html"
-	exit 1
-fi
-
-# Convert CEL, CL2 and MIN files to PNG images.
-echo "Converting CEL, CL2 and MIN files to PNG images."
-if [ ! -d "_dump_" ]; then
-	mkdir -p _dump_
-	time cel_dump -a
-	time min_dump -a
-fi
-
-# Draw arches onto tileset dungeon pieces.
-echo "Draw arches onto tileset dungeon pieces."
-fixarches
-
-# Generate tilesets.
-echo "Generate tilesets."
-if [ ! -d "../mods/ember/images/tileset" ]; then
-	mkdir -p ../mods/ember/images/tileset
-	# Cathedral.
-	echo "Generate Cathedral tilesets."
-	montage _dump_/_dpieces_/l1/l1_1.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_cathedral_theme_1.png
-	montage _dump_/_dpieces_/l1/l1_2.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_cathedral_theme_2.png
-	montage _dump_/_dpieces_/l1/l1_3.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_cathedral_theme_3.png
-	montage _dump_/_dpieces_/l1/l1_4.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_cathedral_theme_4.png
-	montage _dump_/_dpieces_/l1/l1_5.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_cathedral_theme_5.png
-	montage _dump_/_dpieces_/l1/l1palg.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_cathedral_gray.png
-	# Catacombs.
-	echo "Generate Catacombs tilesets."
-	montage _dump_/_dpieces_/l2/l2_1.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_catacombs_theme_1.png
-	montage _dump_/_dpieces_/l2/l2_2.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_catacombs_theme_2.png
-	montage _dump_/_dpieces_/l2/l2_3.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_catacombs_theme_3.png
-	montage _dump_/_dpieces_/l2/l2_4.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_catacombs_theme_4.png
-	montage _dump_/_dpieces_/l2/l2_5.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_catacombs_theme_5.png
-	montage _dump_/_dpieces_/l2/l2palg.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_catacombs_gray.png
-	# Caves.
-	echo "Generate Caves tilesets."
-	montage _dump_/_dpieces_/l3/l3_1.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_caves_theme_1.png
-	montage _dump_/_dpieces_/l3/l3_2.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_caves_theme_2.png
-	montage _dump_/_dpieces_/l3/l3_3.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_caves_theme_3.png
-	montage _dump_/_dpieces_/l3/l3_4.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_caves_theme_4.png
-	montage _dump_/_dpieces_/l3/l3_i.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_caves_theme_ice.png
-	montage _dump_/_dpieces_/l3/l3palg.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_caves_gray.png
-	montage _dump_/_dpieces_/l3/l3pfoul.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_caves_theme_foul_water.png
-	montage _dump_/_dpieces_/l3/l3pwater.pal/dpiece_*.png -background none -tile 32x -geometry 64x160 ../mods/ember/images/tileset/tileset_caves_theme_water.png
-	# Hell.
-	echo "Generate Hell tilesets."
-	montage _dump_/_dpieces_/l4/l4_1.pal/dpiece_*.png -background none -tile 32x -geometry 64x256 ../mods/ember/images/tileset/tileset_hell_theme_1.png
-	montage _dump_/_dpieces_/l4/l4_2.pal/dpiece_*.png -background none -tile 32x -geometry 64x256 ../mods/ember/images/tileset/tileset_hell_theme_2.png
-	montage _dump_/_dpieces_/l4/l4_3.pal/dpiece_*.png -background none -tile 32x -geometry 64x256 ../mods/ember/images/tileset/tileset_hell_theme_3.png
-	montage _dump_/_dpieces_/l4/l4_4.pal/dpiece_*.png -background none -tile 32x -geometry 64x256 ../mods/ember/images/tileset/tileset_hell_theme_4.png
-	# Tristram.
-	echo "Generate Tristram tilesets."
-	montage _dump_/_dpieces_/town/ltpalg.pal/dpiece_*.png -background none -tile 64x -geometry 64x256 ../mods/ember/images/tileset/tileset_tristram_gray.png
-	montage _dump_/_dpieces_/town/town.pal/dpiece_*.png -background none -tile 64x -geometry 64x256 ../mods/ember/images/tileset/tileset_tristram.png
-fi
-
-# Generate tileset definitions.
-gentilesetdef -dtype town > ../mods/ember/tileset/tileset_tristram.txt
-gentilesetdef -dtype l1 > ../mods/ember/tileset/tileset_cathedral_theme_1.txt
-gentilesetdef -dtype l2 > ../mods/ember/tileset/tileset_catacombs_theme_1.txt
-gentilesetdef -dtype l3 > ../mods/ember/tileset/tileset_caves_theme_1.txt
-gentilesetdef -dtype l4 > ../mods/ember/tileset/tileset_hell_theme_1.txt
-
-# Generate monster graphics.
-echo "Generate monster graphics."
-if [ ! -d "../mods/ember/images/monster" ]; then
-	mkdir -p ../mods/ember/images/monster
-	# Spitting Terror
-	echo "Generating Spitting Terror graphics."
-	montage _dump_/monsters/acid/acid{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/acid/acid{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/acid/acid{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/acid/acid{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/acid/acid{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/acid/acid{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/acid/acid{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/acid/acid{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/spitting_terror.png
-	# Winged Fiend
-	echo "Generating Winged Fiend graphics."
-	montage _dump_/monsters/bat/bat{a,d,h,n,w}/*_2/*.png _dump_/monsters/bat/bat{a,d,h,n,w}/*_3/*.png _dump_/monsters/bat/bat{a,d,h,n,w}/*_4/*.png _dump_/monsters/bat/bat{a,d,h,n,w}/*_5/*.png _dump_/monsters/bat/bat{a,d,h,n,w}/*_6/*.png _dump_/monsters/bat/bat{a,d,h,n,w}/*_7/*.png _dump_/monsters/bat/bat{a,d,h,n,w}/*_0/*.png _dump_/monsters/bat/bat{a,d,h,n,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/winged_fiend.png
-	# Devil Kin Brute
-	echo "Generating Devil Kin Brute graphics."
-	montage _dump_/monsters/bigfall/fallg{a,d,h,n,w}/*_2/*.png _dump_/monsters/bigfall/fallg{a,d,h,n,w}/*_3/*.png _dump_/monsters/bigfall/fallg{a,d,h,n,w}/*_4/*.png _dump_/monsters/bigfall/fallg{a,d,h,n,w}/*_5/*.png _dump_/monsters/bigfall/fallg{a,d,h,n,w}/*_6/*.png _dump_/monsters/bigfall/fallg{a,d,h,n,w}/*_7/*.png _dump_/monsters/bigfall/fallg{a,d,h,n,w}/*_0/*.png _dump_/monsters/bigfall/fallg{a,d,h,n,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/devil_kin_brute.png
-	# Black Knight
-	echo "Generating Black Knight graphics."
-	montage _dump_/monsters/black/black{a,d,h,n,w}/*_2/*.png _dump_/monsters/black/black{a,d,h,n,w}/*_3/*.png _dump_/monsters/black/black{a,d,h,n,w}/*_4/*.png _dump_/monsters/black/black{a,d,h,n,w}/*_5/*.png _dump_/monsters/black/black{a,d,h,n,w}/*_6/*.png _dump_/monsters/black/black{a,d,h,n,w}/*_7/*.png _dump_/monsters/black/black{a,d,h,n,w}/*_0/*.png _dump_/monsters/black/black{a,d,h,n,w}/*_1/*.png -gravity south -geometry 160x160+0+0 -tile x8 -background none ../mods/ember/images/monster/black_knight.png
-	# Dark Mage
-	echo "Generating Dark Mage graphics."
-	montage _dump_/monsters/darkmage/dmage{a,d,h,n,s}/*_2/*.png _dump_/monsters/darkmage/dmage{a,d,h,n,s}/*_3/*.png _dump_/monsters/darkmage/dmage{a,d,h,n,s}/*_4/*.png _dump_/monsters/darkmage/dmage{a,d,h,n,s}/*_5/*.png _dump_/monsters/darkmage/dmage{a,d,h,n,s}/*_6/*.png _dump_/monsters/darkmage/dmage{a,d,h,n,s}/*_7/*.png _dump_/monsters/darkmage/dmage{a,d,h,n,s}/*_0/*.png _dump_/monsters/darkmage/dmage{a,d,h,n,s}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/dark_mage.png
-	# Bone Demon
-	echo "Generating Bone Demon graphics."
-	montage _dump_/monsters/demskel/demskl{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/demskel/demskl{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/demskel/demskl{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/demskel/demskl{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/demskel/demskl{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/demskel/demskl{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/demskel/demskl{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/demskel/demskl{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/bone_demon.png
-	# Diablo
-	echo "Generating Diablo graphics."
-	montage _dump_/monsters/diablo/diablo{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/diablo/diablo{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/diablo/diablo{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/diablo/diablo{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/diablo/diablo{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/diablo/diablo{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/diablo/diablo{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/diablo/diablo{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/diablo.png
-	# Fallen One Spear Wielder
-	echo "Generating Fallen One Spear Wielder graphics."
-	montage _dump_/monsters/falspear/phall{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/falspear/phall{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/falspear/phall{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/falspear/phall{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/falspear/phall{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/falspear/phall{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/falspear/phall{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/falspear/phall{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/fallen_one_spear_wielder.png
-	# Fallen One Sword Wielder
-	echo "Generating Fallen One Sword Wielder graphics."
-	montage _dump_/monsters/falsword/fall{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/falsword/fall{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/falsword/fall{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/falsword/fall{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/falsword/fall{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/falsword/fall{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/falsword/fall{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/falsword/fall{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/fallen_one_sword_wielder.png
-	# Overlord
-	echo "Generating Overlord graphics."
-	montage _dump_/monsters/fat/fat{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/fat/fat{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/fat/fat{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/fat/fat{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/fat/fat{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/fat/fat{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/fat/fat{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/fat/fat{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/overlord.png
-	# Butcher
-	echo "Generating Butcher graphics."
-	montage _dump_/monsters/fatc/fatc{a,d,h,n,w}/*_2/*.png _dump_/monsters/fatc/fatc{a,d,h,n,w}/*_3/*.png _dump_/monsters/fatc/fatc{a,d,h,n,w}/*_4/*.png _dump_/monsters/fatc/fatc{a,d,h,n,w}/*_5/*.png _dump_/monsters/fatc/fatc{a,d,h,n,w}/*_6/*.png _dump_/monsters/fatc/fatc{a,d,h,n,w}/*_7/*.png _dump_/monsters/fatc/fatc{a,d,h,n,w}/*_0/*.png _dump_/monsters/fatc/fatc{a,d,h,n,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/butcher.png
-	# Fireman
-	echo "Generating Fireman graphics."
-	montage _dump_/monsters/fireman/firem{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/fireman/firem{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/fireman/firem{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/fireman/firem{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/fireman/firem{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/fireman/firem{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/fireman/firem{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/fireman/firem{a,d,h,n,s,w}/*_1/*.png -gravity south -geometry 128x171+0+0 -tile x8 -background none ../mods/ember/images/monster/fireman.png
-	# Gargoyle
-	echo "Generating Gargoyle graphics."
-	montage _dump_/monsters/gargoyle/gargo{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/gargoyle/gargo{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/gargoyle/gargo{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/gargoyle/gargo{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/gargoyle/gargo{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/gargoyle/gargo{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/gargoyle/gargo{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/gargoyle/gargo{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/gargoyle.png
-	# Goat Archer
-	echo "Generating Goat Archer graphics."
-	montage _dump_/monsters/goatbow/goatb{a,d,h,n,w}/*_2/*.png _dump_/monsters/goatbow/goatb{a,d,h,n,w}/*_3/*.png _dump_/monsters/goatbow/goatb{a,d,h,n,w}/*_4/*.png _dump_/monsters/goatbow/goatb{a,d,h,n,w}/*_5/*.png _dump_/monsters/goatbow/goatb{a,d,h,n,w}/*_6/*.png _dump_/monsters/goatbow/goatb{a,d,h,n,w}/*_7/*.png _dump_/monsters/goatbow/goatb{a,d,h,n,w}/*_0/*.png _dump_/monsters/goatbow/goatb{a,d,h,n,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/goat_archer.png
-	# Goat Lord
-	echo "Generating Goat Lord graphics."
-	montage _dump_/monsters/goatlord/goatl{a,d,h,n,w}/*_2/*.png _dump_/monsters/goatlord/goatl{a,d,h,n,w}/*_3/*.png _dump_/monsters/goatlord/goatl{a,d,h,n,w}/*_4/*.png _dump_/monsters/goatlord/goatl{a,d,h,n,w}/*_5/*.png _dump_/monsters/goatlord/goatl{a,d,h,n,w}/*_6/*.png _dump_/monsters/goatlord/goatl{a,d,h,n,w}/*_7/*.png _dump_/monsters/goatlord/goatl{a,d,h,n,w}/*_0/*.png _dump_/monsters/goatlord/goatl{a,d,h,n,w}/*_1/*.png -gravity south -geometry 160x160+0+0 -tile x8 -background none ../mods/ember/images/monster/goat_lord.png
-	# Goat Mace Wielder
-	echo "Generating Goat Mace Wielder graphics."
-	montage _dump_/monsters/goatmace/goat{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/goatmace/goat{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/goatmace/goat{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/goatmace/goat{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/goatmace/goat{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/goatmace/goat{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/goatmace/goat{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/goatmace/goat{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/goat_mace_wielder.png
-	# Golem
-	echo "Generating Golem graphics."
-	montage _dump_/monsters/golem/golema/*_2/*.png _dump_/monsters/golem/golemd/*.png _dump_/monsters/golem/golems/*.png _dump_/monsters/golem/golemw/*_2/*.png _dump_/monsters/golem/golema/*_3/*.png _dump_/monsters/golem/golemd/*.png _dump_/monsters/golem/golems/*.png _dump_/monsters/golem/golemw/*_3/*.png _dump_/monsters/golem/golema/*_4/*.png _dump_/monsters/golem/golemd/*.png _dump_/monsters/golem/golems/*.png _dump_/monsters/golem/golemw/*_4/*.png _dump_/monsters/golem/golema/*_5/*.png _dump_/monsters/golem/golemd/*.png _dump_/monsters/golem/golems/*.png _dump_/monsters/golem/golemw/*_5/*.png _dump_/monsters/golem/golema/*_6/*.png _dump_/monsters/golem/golemd/*.png _dump_/monsters/golem/golems/*.png _dump_/monsters/golem/golemw/*_6/*.png _dump_/monsters/golem/golema/*_7/*.png _dump_/monsters/golem/golemd/*.png _dump_/monsters/golem/golems/*.png _dump_/monsters/golem/golemw/*_7/*.png _dump_/monsters/golem/golema/*_0/*.png _dump_/monsters/golem/golemd/*.png _dump_/monsters/golem/golems/*.png _dump_/monsters/golem/golemw/*_0/*.png _dump_/monsters/golem/golema/*_1/*.png _dump_/monsters/golem/golemd/*.png _dump_/monsters/golem/golems/*.png _dump_/monsters/golem/golemw/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/golem.png
-	# Mage
-	echo "Generating Mage graphics."
-	montage _dump_/monsters/mage/mage{a,d,h,n,s}/*_2/*.png _dump_/monsters/mage/mage{a,d,h,n,s}/*_3/*.png _dump_/monsters/mage/mage{a,d,h,n,s}/*_4/*.png _dump_/monsters/mage/mage{a,d,h,n,s}/*_5/*.png _dump_/monsters/mage/mage{a,d,h,n,s}/*_6/*.png _dump_/monsters/mage/mage{a,d,h,n,s}/*_7/*.png _dump_/monsters/mage/mage{a,d,h,n,s}/*_0/*.png _dump_/monsters/mage/mage{a,d,h,n,s}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/mage.png
-	# Magma Demon
-	echo "Generating Magma Demon graphics."
-	montage _dump_/monsters/magma/magma{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/magma/magma{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/magma/magma{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/magma/magma{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/magma/magma{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/magma/magma{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/magma/magma{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/magma/magma{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/magma_demon.png
-	# Balrog
-	echo "Generating Balrog graphics."
-	montage _dump_/monsters/mega/mega{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/mega/mega{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/mega/mega{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/mega/mega{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/mega/mega{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/mega/mega{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/mega/mega{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/mega/mega{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/balrog.png
-	# Horned Demon
-	echo "Generating Horned Demon graphics."
-	montage _dump_/monsters/rhino/rhino{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/rhino/rhino{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/rhino/rhino{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/rhino/rhino{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/rhino/rhino{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/rhino/rhino{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/rhino/rhino{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/rhino/rhino{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/horned_demon.png
-	# Scavenger
-	echo "Generating Scavenger graphics."
-	montage _dump_/monsters/scav/scav{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/scav/scav{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/scav/scav{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/scav/scav{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/scav/scav{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/scav/scav{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/scav/scav{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/scav/scav{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/scavenger.png
-	# Skeleton Axe Wielder
-	echo "Generating Skeleton Axe Wielder graphics."
-	montage _dump_/monsters/skelaxe/sklax{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/skelaxe/sklax{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/skelaxe/sklax{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/skelaxe/sklax{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/skelaxe/sklax{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/skelaxe/sklax{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/skelaxe/sklax{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/skelaxe/sklax{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/skeleton_axe_wielder.png
-	# Skeleton Archer
-	echo "Generating Skeleton Archer graphics."
-	montage _dump_/monsters/skelbow/sklbw{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/skelbow/sklbw{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/skelbow/sklbw{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/skelbow/sklbw{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/skelbow/sklbw{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/skelbow/sklbw{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/skelbow/sklbw{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/skelbow/sklbw{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/skeleton_archer.png
-	# Skeleton Sword Wielder
-	echo "Generating Skeleton Sword Wielder graphics."
-	montage _dump_/monsters/skelsd/sklsr{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/skelsd/sklsr{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/skelsd/sklsr{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/skelsd/sklsr{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/skelsd/sklsr{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/skelsd/sklsr{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/skelsd/sklsr{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/skelsd/sklsr{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/skeleton_sword_wielder.png
-	# Skeleton King
-	echo "Generating Skeleton King graphics."
-	montage _dump_/monsters/sking/sking{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/sking/sking{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/sking/sking{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/sking/sking{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/sking/sking{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/sking/sking{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/sking/sking{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/sking/sking{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/skeleton_king.png
-	# Viper
-	echo "Generating Viper graphics."
-	montage _dump_/monsters/snake/snake{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/snake/snake{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/snake/snake{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/snake/snake{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/snake/snake{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/snake/snake{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/snake/snake{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/snake/snake{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/viper.png
-	# Hidden
-	echo "Generating Hidden graphics."
-	montage _dump_/monsters/sneak/sneak{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/sneak/sneak{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/sneak/sneak{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/sneak/sneak{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/sneak/sneak{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/sneak/sneak{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/sneak/sneak{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/sneak/sneak{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/hidden.png
-	# Succubus
-	echo "Generating Succubus graphics."
-	montage _dump_/monsters/succ/scbs{a,d,h,n,w}/*_2/*.png _dump_/monsters/succ/scbs{a,d,h,n,w}/*_3/*.png _dump_/monsters/succ/scbs{a,d,h,n,w}/*_4/*.png _dump_/monsters/succ/scbs{a,d,h,n,w}/*_5/*.png _dump_/monsters/succ/scbs{a,d,h,n,w}/*_6/*.png _dump_/monsters/succ/scbs{a,d,h,n,w}/*_7/*.png _dump_/monsters/succ/scbs{a,d,h,n,w}/*_0/*.png _dump_/monsters/succ/scbs{a,d,h,n,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/succubus.png
-	# Litch Demon
-	echo "Generating Litch Demon graphics."
-	montage _dump_/monsters/thin/thin{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/thin/thin{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/thin/thin{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/thin/thin{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/thin/thin{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/thin/thin{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/thin/thin{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/thin/thin{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/litch_demon.png
-	# Invisible Lord
-	echo "Generating Invisible Lord graphics."
-	montage _dump_/monsters/tsneak/tsneak{a,d,h,n,w}/*_2/*.png _dump_/monsters/tsneak/tsneak{a,d,h,n,w}/*_3/*.png _dump_/monsters/tsneak/tsneak{a,d,h,n,w}/*_4/*.png _dump_/monsters/tsneak/tsneak{a,d,h,n,w}/*_5/*.png _dump_/monsters/tsneak/tsneak{a,d,h,n,w}/*_6/*.png _dump_/monsters/tsneak/tsneak{a,d,h,n,w}/*_7/*.png _dump_/monsters/tsneak/tsneak{a,d,h,n,w}/*_0/*.png _dump_/monsters/tsneak/tsneak{a,d,h,n,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/invisible_lord.png
-	# Unraveler
-	echo "Generating Unraveler graphics."
-	montage _dump_/monsters/unrav/unrav{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/unrav/unrav{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/unrav/unrav{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/unrav/unrav{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/unrav/unrav{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/unrav/unrav{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/unrav/unrav{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/unrav/unrav{a,d,h,n,s,w}/*_1/*.png -gravity south -geometry 96x128+0+0 -tile x8 -background none ../mods/ember/images/monster/unraveler.png
-	# Zombie
-	echo "Generating Zombie graphics."
-	montage _dump_/monsters/zombie/zombie{a,d,h,n,s,w}/*_2/*.png _dump_/monsters/zombie/zombie{a,d,h,n,s,w}/*_3/*.png _dump_/monsters/zombie/zombie{a,d,h,n,s,w}/*_4/*.png _dump_/monsters/zombie/zombie{a,d,h,n,s,w}/*_5/*.png _dump_/monsters/zombie/zombie{a,d,h,n,s,w}/*_6/*.png _dump_/monsters/zombie/zombie{a,d,h,n,s,w}/*_7/*.png _dump_/monsters/zombie/zombie{a,d,h,n,s,w}/*_0/*.png _dump_/monsters/zombie/zombie{a,d,h,n,s,w}/*_1/*.png -geometry +0+0 -tile x8 -background none ../mods/ember/images/monster/zombie.png
-fi
-
-# Copy cursor graphics.
-if [ ! -d "../mods/ember/images/cursor" ]; then
-	mkdir -p ../mods/ember/images/cursor
-	cp _dump_/data/inv/objcurs/objcurs_0001.png ../mods/ember/images/cursor/cursor_hand.png
-fi
-
-# Convert music from wav to ogg.
-echo "Converting music from wav to ogg."
-if [ ! -d "../mods/ember/music" ]; then
-	mkdir -p ../mods/ember/music
-	ffmpeg -loglevel error -y -i diabdat/music/dintro.wav ../mods/ember/music/intro.ogg
-	ffmpeg -loglevel error -y -i diabdat/music/dlvla.wav ../mods/ember/music/cathedral.ogg
-	ffmpeg -loglevel error -y -i diabdat/music/dlvlb.wav ../mods/ember/music/catacombs.ogg
-	ffmpeg -loglevel error -y -i diabdat/music/dlvlc.wav ../mods/ember/music/caves.ogg
-	ffmpeg -loglevel error -y -i diabdat/music/dlvld.wav ../mods/ember/music/hell.ogg
-	ffmpeg -loglevel error -y -i diabdat/music/dtowne.wav ../mods/ember/music/tristram.ogg
-fi
-`
+// runPipeline runs the given steps in order, running up to j commands of each
+// step concurrently. Steps depending on failed steps are skipped, and the
+// errors of all failed steps are reported together.
+func runPipeline(steps []*step, m *manifest, forced map[string]bool, j int) error {
+	failed := make(map[string]bool)
+	var errs []error
+	for _, s := range steps {
+		var failedDeps []string
+		for _, dep := range s.deps {
+			if failed[dep] {
+				failedDeps = append(failedDeps, dep)
+			}
+		}
+		if len(failedDeps) > 0 {
+			dbg.Printf("skipping step %q; failed dependencies %s.", s.name, strings.Join(failedDeps, ", "))
+			failed[s.name] = true
+			continue
+		}
+		if err := s.run(m, forced[s.name], j); err != nil {
+			failed[s.name] = true
+			errs = append(errs, err)
+		}
+	}
+	return joinErrors("pipeline", errs)
+}
+
+// openMPQs opens the MPQ archives of the given comma-separated list, to be read
+// directly by the tools. The MPQ archives are returned by archive name (e.g.
+// "diabdat"), as given by the base name of the MPQ archive.
+func openMPQs(mpqList string) (map[string]string, *asset.Resolver, error) {
+	mpqPaths := strings.Split(mpqList, ",")
+	mpqs := make(map[string]string)
+	for _, mpqPath := range mpqPaths {
+		name := strings.ToLower(pathutil.TrimExt(filepath.Base(mpqPath)))
+		mpqs[name] = mpqPath
+	}
+	assets, err := asset.NewFromMPQ(mpqPaths)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return mpqs, assets, nil
+}
+
+// dungeonTypes returns the registered dungeon types to convert. Hellfire
+// dungeon types are included if hellfire is set.
+func dungeonTypes(hellfire bool) ([]*dtype.DungeonType, error) {
+	var dts []*dtype.DungeonType
+	for _, dtypeName := range dtype.Names() {
+		dt, err := dtype.Get(dtypeName)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if dt.Archive == dtype.ArchiveHellfire && !hellfire {
+			// Skip Hellfire dungeon type.
+			continue
+		}
+		dts = append(dts, dt)
+	}
+	return dts, nil
+}
+
+// stepSet parses the given comma-separated list of step names, where "all"
+// denotes every step.
+func stepSet(steps []*step, list string) (map[string]bool, error) {
+	set := make(map[string]bool)
+	if len(list) == 0 {
+		return set, nil
+	}
+	known := make(map[string]bool)
+	var names []string
+	for _, s := range steps {
+		known[s.name] = true
+		names = append(names, s.name)
+	}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "all" {
+			for _, s := range steps {
+				set[s.name] = true
+			}
+			continue
+		}
+		if !known[name] {
+			return nil, errors.Errorf("unknown step %q; valid steps: %s", name, strings.Join(names, ", "))
+		}
+		set[name] = true
+	}
+	return set, nil
+}
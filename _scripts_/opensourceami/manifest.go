@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mewkiz/pkg/osutil"
+	"github.com/pkg/errors"
+)
+
+// A manifest records the hash of the inputs of each output of the pipeline, as
+// of the last successful run of the command producing the output, and the hash
+// of the contents of the output as produced by the run.
+//
+// The hash of a command covers its command line arguments, the executable of
+// the program run, the contents written and the contents of its input and
+// updated files.
+// Thus, a command is only rerun if any of these changed, or if any of its
+// outputs are missing or changed since the run (e.g. corrupted or edited by
+// hand).
+type manifest struct {
+	// Path to the manifest file.
+	path string
+	// Input hashes, mapping from output path to hash of command.
+	hashes map[string]string
+	// Output hashes, mapping from output path to hash of contents as produced
+	// by the last run of the command.
+	outputHashes map[string]string
+	// Hashes of files, mapping from file path to hash of contents; cached
+	// between commands.
+	fileHashes map[string]string
+}
+
+// newManifest returns a new empty manifest, stored at the given path.
+func newManifest(path string) *manifest {
+	return &manifest{
+		path:         path,
+		hashes:       make(map[string]string),
+		outputHashes: make(map[string]string),
+		fileHashes:   make(map[string]string),
+	}
+}
+
+// loadManifest loads the given manifest file. An empty manifest is returned if
+// the manifest file is not present.
+//
+// Each line of the manifest file holds the hash of a command, the hash of the
+// contents of its output and the output path, separated by spaces; lines
+// starting with '#' are comments. Lines of earlier manifest files lacking the
+// hash of the output are accepted, and their outputs are rebuilt.
+func loadManifest(path string) (*manifest, error) {
+	m := newManifest(path)
+	if !osutil.Exists(path) {
+		return m, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 3)
+		switch {
+		case len(parts) == 3 && isHash(parts[1]):
+			m.hashes[parts[2]] = parts[0]
+			m.outputHashes[parts[2]] = parts[1]
+		case len(parts) >= 2:
+			// Entry without output hash.
+			pos := strings.Index(line, " ")
+			m.hashes[line[pos+1:]] = line[:pos]
+		default:
+			return nil, errors.Errorf("%s:%d: invalid manifest entry %q; expected HASH OUTPUT_HASH OUTPUT", path, lineNum, line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return m, nil
+}
+
+// isHash reports whether the given string is a hex-encoded SHA-1 hash.
+func isHash(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// save writes the manifest to its manifest file.
+func (m *manifest) save() error {
+	var outputs []string
+	for output := range m.hashes {
+		outputs = append(outputs, output)
+	}
+	sort.Strings(outputs)
+	buf := &bytes.Buffer{}
+	buf.WriteString("# Input and output hashes of outputs, as generated by opensourceami; remove\n")
+	buf.WriteString("# to force a full rebuild.\n")
+	for _, output := range outputs {
+		fmt.Fprintf(buf, "%s %s %s\n", m.hashes[output], m.outputHashes[output], output)
+	}
+	if err := ioutil.WriteFile(m.path, buf.Bytes(), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// upToDate reports whether the outputs of the given command are present,
+// unchanged since the last run, and were produced from the inputs of the given
+// hash.
+func (m *manifest) upToDate(cmd *command, hash string) bool {
+	outputs := cmd.outputPaths()
+	if len(outputs) == 0 {
+		return false
+	}
+	for _, output := range outputs {
+		if !osutil.Exists(output) || m.hashes[output] != hash {
+			return false
+		}
+		outputHash, err := m.fileHash(output)
+		if err != nil || outputHash != m.outputHashes[output] {
+			return false
+		}
+	}
+	return true
+}
+
+// record records the given hash of the command in the manifest, together with
+// the hashes of the contents of its outputs, and saves the manifest.
+func (m *manifest) record(cmd *command, hash string) error {
+	outputs := cmd.outputPaths()
+	if len(outputs) == 0 {
+		return nil
+	}
+	// The outputs were written by the command.
+	m.invalidate(outputs)
+	for _, output := range outputs {
+		if !osutil.Exists(output) {
+			// Missing outputs are rebuilt by the next run.
+			delete(m.hashes, output)
+			delete(m.outputHashes, output)
+			continue
+		}
+		outputHash, err := m.fileHash(output)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		m.hashes[output] = hash
+		m.outputHashes[output] = outputHash
+	}
+	return m.save()
+}
+
+// forget removes the command from the manifest, so that it is rerun.
+func (m *manifest) forget(cmd *command) error {
+	found := false
+	for _, output := range cmd.outputPaths() {
+		if _, ok := m.hashes[output]; ok {
+			found = true
+			delete(m.hashes, output)
+			delete(m.outputHashes, output)
+		}
+	}
+	if !found {
+		return nil
+	}
+	return m.save()
+}
+
+// hash returns the hash of the given command, covering its command line
+// arguments, the executable of the program run, the contents written and the
+// contents of its input and updated files.
+func (m *manifest) hash(cmd *command) (string, error) {
+	h := sha1.New()
+	for _, arg := range cmd.args {
+		fmt.Fprintf(h, "arg %q\n", arg)
+	}
+	if len(cmd.args) > 0 {
+		// Rerun commands when their tool is rebuilt.
+		toolPath, err := exec.LookPath(cmd.args[0])
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		toolHash, err := m.fileHash(toolPath)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		fmt.Fprintf(h, "tool %s\n", toolHash)
+	}
+	fmt.Fprintf(h, "output %q\n", cmd.output)
+	fmt.Fprintf(h, "contents %q\n", cmd.contents)
+	for _, input := range cmd.inputs {
+		paths, err := inputFiles(input)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		if len(paths) == 0 {
+			fmt.Fprintf(h, "input %q missing\n", input)
+			continue
+		}
+		for _, path := range paths {
+			fileHash, err := m.fileHash(path)
+			if err != nil {
+				return "", errors.WithStack(err)
+			}
+			fmt.Fprintf(h, "input %q %s\n", path, fileHash)
+		}
+	}
+	for _, path := range cmd.updates {
+		if !osutil.Exists(path) {
+			fmt.Fprintf(h, "update %q missing\n", path)
+			continue
+		}
+		fileHash, err := m.fileHash(path)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		fmt.Fprintf(h, "update %q %s\n", path, fileHash)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// invalidate drops the cached hashes of the given files, as their contents have
+// changed.
+func (m *manifest) invalidate(paths []string) {
+	for _, path := range paths {
+		delete(m.fileHashes, path)
+	}
+}
+
+// fileHash returns the hash of the contents of the given file.
+func (m *manifest) fileHash(path string) (string, error) {
+	if fileHash, ok := m.fileHashes[path]; ok {
+		return fileHash, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.WithStack(err)
+	}
+	fileHash := hex.EncodeToString(h.Sum(nil))
+	m.fileHashes[path] = fileHash
+	return fileHash, nil
+}
+
+// inputFiles returns the paths of the files of the given input, in sorted
+// order; the files contained within the directory tree of a directory input,
+// the file itself of a file input, and none of a missing input.
+func inputFiles(input string) ([]string, error) {
+	fi, err := os.Stat(input)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !fi.IsDir() {
+		return []string{input}, nil
+	}
+	var paths []string
+	walk := func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if !fi.IsDir() {
+			paths = append(paths, path)
+		}
+		return nil
+	}
+	// filepath.Walk visits files in lexical order.
+	if err := filepath.Walk(input, walk); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return paths, nil
+}
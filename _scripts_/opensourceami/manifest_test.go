@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "manifest.txt")
+	m := newManifest(manifestPath)
+	outputPaths := []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")}
+	cmd := &command{output: outputPaths[0], outputs: outputPaths[1:], contents: "a"}
+	hash, err := m.hash(cmd)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	// Outputs are missing before the first run.
+	if m.upToDate(cmd, hash) {
+		t.Errorf("expected outputs missing before the first run to be outdated")
+	}
+
+	// run writes the outputs of the command, and records them in the manifest.
+	run := func() {
+		for _, outputPath := range outputPaths {
+			if err := ioutil.WriteFile(outputPath, []byte("a"), 0644); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+		if err := m.record(cmd, hash); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	run()
+	if !m.upToDate(cmd, hash) {
+		t.Errorf("expected outputs to be up to date after the first run")
+	}
+	if m.upToDate(cmd, "other") {
+		t.Errorf("expected outputs of changed inputs to be outdated")
+	}
+
+	// Each output is compared against the contents of the last run, including
+	// outputs other than the first.
+	for _, outputPath := range outputPaths {
+		if err := ioutil.WriteFile(outputPath, []byte("edited by hand"), 0644); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		m.invalidate([]string{outputPath})
+		if m.upToDate(cmd, hash) {
+			t.Errorf("expected changed output %q to be outdated", filepath.Base(outputPath))
+		}
+		run()
+	}
+
+	// The hashes of each output are kept by the manifest file.
+	loaded, err := loadManifest(manifestPath)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !loaded.upToDate(cmd, hash) {
+		t.Errorf("expected outputs to be up to date after loading the manifest")
+	}
+	if err := ioutil.WriteFile(outputPaths[1], []byte("corrupted"), 0644); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if loaded, err = loadManifest(manifestPath); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if loaded.upToDate(cmd, hash) {
+		t.Errorf("expected corrupted output to be outdated after loading the manifest")
+	}
+
+	// Forgotten commands are rerun.
+	run()
+	if err := m.forget(cmd); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if m.upToDate(cmd, hash) {
+		t.Errorf("expected forgotten outputs to be outdated")
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	const hash = "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+	golden := []struct {
+		line string
+		// Expected input hash, output hash and output path.
+		want    [3]string
+		wantErr bool
+	}{
+		{line: hash + " " + hash + " a.txt", want: [3]string{hash, hash, "a.txt"}},
+		{line: hash + " " + hash + " dir/with space.txt", want: [3]string{hash, hash, "dir/with space.txt"}},
+		// Entries of earlier manifests lack the output hash.
+		{line: hash + " a.txt", want: [3]string{hash, "", "a.txt"}},
+		{line: hash + " dir/with space.txt", want: [3]string{hash, "", "dir/with space.txt"}},
+		{line: hash, wantErr: true},
+	}
+	for _, g := range golden {
+		manifestPath := filepath.Join(t.TempDir(), "manifest.txt")
+		if err := ioutil.WriteFile(manifestPath, []byte("# Test.\n"+g.line+"\n"), 0644); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		m, err := loadManifest(manifestPath)
+		if g.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected error, got nil", g.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", g.line, err)
+			continue
+		}
+		inputHash, outputHash, output := g.want[0], g.want[1], g.want[2]
+		if got := m.hashes[output]; got != inputHash {
+			t.Errorf("%q: input hash mismatch; expected %q, got %q", g.line, inputHash, got)
+		}
+		if got := m.outputHashes[output]; got != outputHash {
+			t.Errorf("%q: output hash mismatch; expected %q, got %q", g.line, outputHash, got)
+		}
+		if n := len(m.hashes); n != 1 {
+			t.Errorf("%q: expected 1 entry, got %d", g.line, n)
+		}
+	}
+}
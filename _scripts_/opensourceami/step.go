@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mewkiz/pkg/osutil"
+	"github.com/pkg/errors"
+)
+
+// A step is a named step of the asset conversion pipeline.
+type step struct {
+	// Step name (e.g. "tilesets").
+	name string
+	// Short description of the step, as listed by -list (e.g. "generate
+	// tilesets and tileset definitions").
+	desc string
+	// Comment describing the step (e.g. "Generate tilesets and tileset
+	// definitions.").
+	comment string
+	// Message output when running the step (e.g. "Generate tilesets.").
+	msg string
+	// Names of the steps to run before the step.
+	deps []string
+	// Files required by the step.
+	requires []requirement
+	// Output directory of the step. The shell script of the pipeline skips the
+	// step if the output directory is present, unless forced. Empty if the step
+	// is never skipped.
+	outputDir string
+	// Additional output directories of the step, created before running the
+	// step.
+	dirs []string
+	// Commands of the step.
+	cmds []*command
+}
+
+// A requirement is a file required by a step.
+type requirement struct {
+	// Comment describing the requirement (e.g. "Locate extracted
+	// diabdat.mpq").
+	comment string
+	// Path to the required file.
+	path string
+	// Error message output if the required file is missing; one line per
+	// element.
+	msg []string
+}
+
+// A command is a command of a step, which either runs a program or writes
+// contents to a file.
+type command struct {
+	// Comment describing the command (e.g. "Cathedral."); empty if none.
+	comment string
+	// Message output before running the command; empty if none.
+	msg string
+	// Command line arguments of the program to run; nil if the command writes
+	// contents to a file.
+	args []string
+	// Path to the output file of the command, to which the standard output of
+	// the program or the contents are written; standard output if empty.
+	output string
+	// Contents to write to the output file, if the command runs no program.
+	contents string
+	// Additional output files of the command, not written to standard output
+	// (e.g. sprite sheets written by gensprite -o).
+	outputs []string
+	// Input files and directories of the command; the command is rerun if their
+	// contents change.
+	inputs []string
+	// Files both read and written by the command (e.g. maps to which spawn
+	// entries are added); the command is rerun if their contents change, as
+	// compared to their contents after the last run.
+	updates []string
+	// Path to a file or directory required by the command; the command is
+	// skipped if missing. Empty if the command is always run.
+	cond string
+}
+
+// order returns the given steps ordered by dependency. Steps are otherwise kept
+// in the given order.
+func order(steps []*step) ([]*step, error) {
+	known := make(map[string]bool)
+	for _, s := range steps {
+		known[s.name] = true
+	}
+	for _, s := range steps {
+		for _, dep := range s.deps {
+			if !known[dep] {
+				return nil, errors.Errorf("unknown dependency %q of step %q", dep, s.name)
+			}
+		}
+	}
+	var ordered []*step
+	done := make(map[string]bool)
+	for len(ordered) < len(steps) {
+		progress := false
+		for _, s := range steps {
+			if done[s.name] || !depsDone(s, done) {
+				continue
+			}
+			ordered = append(ordered, s)
+			done[s.name] = true
+			progress = true
+		}
+		if !progress {
+			var pending []string
+			for _, s := range steps {
+				if !done[s.name] {
+					pending = append(pending, s.name)
+				}
+			}
+			return nil, errors.Errorf("dependency cycle between steps %s", strings.Join(pending, ", "))
+		}
+	}
+	return ordered, nil
+}
+
+// depsDone reports whether the dependencies of the given step are done.
+func depsDone(s *step, done map[string]bool) bool {
+	for _, dep := range s.deps {
+		if !done[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// run runs the given step, running up to j commands concurrently. Commands
+// whose outputs are up to date with their inputs, as recorded by the manifest,
+// are skipped unless forced.
+//
+// The log output of each command is buffered and written in command order, so
+// the log output is the same regardless of the number of concurrent commands.
+// The errors of all failed commands are reported together.
+func (s *step) run(m *manifest, force bool, j int) error {
+	for _, req := range s.requires {
+		if !osutil.Exists(req.path) {
+			return errors.Errorf("unable to locate %q required by step %q; %s", req.path, s.name, strings.Join(req.msg, " "))
+		}
+	}
+	if len(s.cmds) == 0 {
+		return nil
+	}
+	// Locate outdated commands.
+	var (
+		outdated []*command
+		hashes   []string
+	)
+	for _, cmd := range s.cmds {
+		if len(cmd.cond) > 0 && !osutil.Exists(cmd.cond) {
+			dbg.Printf("skipping %q of step %q; unable to locate %q.", cmd.outputPaths()[0], s.name, cmd.cond)
+			continue
+		}
+		hash, err := m.hash(cmd)
+		if err != nil {
+			return errors.Wrapf(err, "step %q", s.name)
+		}
+		if !force && m.upToDate(cmd, hash) {
+			continue
+		}
+		outdated = append(outdated, cmd)
+		hashes = append(hashes, hash)
+	}
+	if len(outdated) == 0 {
+		dbg.Printf("skipping step %q; outputs up to date.", s.name)
+		return nil
+	}
+	if len(s.msg) > 0 {
+		dbg.Println(s.msg)
+	}
+	for _, dir := range s.allDirs() {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	// Run outdated commands concurrently.
+	type result struct {
+		// Buffered log output of the command.
+		log bytes.Buffer
+		// Error of the command; nil if successful.
+		err error
+		// Closed when the command is done.
+		done chan struct{}
+	}
+	results := make([]*result, len(outdated))
+	for i := range results {
+		results[i] = &result{done: make(chan struct{})}
+	}
+	if j < 1 {
+		j = 1
+	}
+	sem := make(chan struct{}, j)
+	go func() {
+		for i, cmd := range outdated {
+			sem <- struct{}{}
+			go func(cmd *command, res *result) {
+				res.err = cmd.run(&res.log)
+				<-sem
+				close(res.done)
+			}(cmd, results[i])
+		}
+	}()
+	var errs []error
+	for i, cmd := range outdated {
+		res := results[i]
+		<-res.done
+		os.Stderr.Write(res.log.Bytes())
+		if res.err != nil {
+			if err := m.forget(cmd); err != nil {
+				return errors.WithStack(err)
+			}
+			errs = append(errs, res.err)
+			continue
+		}
+		hash := hashes[i]
+		if len(cmd.updates) > 0 {
+			// Record the contents of updated files as of after the run, so that
+			// the update of the command does not outdate itself.
+			m.invalidate(cmd.updates)
+			var err error
+			if hash, err = m.hash(cmd); err != nil {
+				return errors.Wrapf(err, "step %q", s.name)
+			}
+		}
+		if err := m.record(cmd, hash); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return joinErrors(fmt.Sprintf("step %q", s.name), errs)
+}
+
+// joinErrors returns an error reporting the given errors, prefixed by the given
+// context; or nil if there are no errors.
+func joinErrors(context string, errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errors.Wrap(errs[0], context)
+	}
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, "\t"+err.Error())
+	}
+	return errors.Errorf("%s: %d errors:\n%s", context, len(errs), strings.Join(msgs, "\n"))
+}
+
+// allDirs returns the output directories of the step.
+func (s *step) allDirs() []string {
+	var dirs []string
+	if len(s.outputDir) > 0 {
+		dirs = append(dirs, s.outputDir)
+	}
+	return append(dirs, s.dirs...)
+}
+
+// outputPaths returns the paths to the output files of the command.
+func (cmd *command) outputPaths() []string {
+	var outputs []string
+	if len(cmd.output) > 0 {
+		outputs = append(outputs, cmd.output)
+	}
+	return append(outputs, cmd.outputs...)
+}
+
+// run runs the given command, writing its log output to w.
+func (cmd *command) run(w io.Writer) error {
+	if len(cmd.msg) > 0 {
+		logger := log.New(w, dbg.Prefix(), dbg.Flags())
+		logger.Println(cmd.msg)
+	}
+	if cmd.args == nil {
+		if err := os.MkdirAll(filepath.Dir(cmd.output), 0755); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := ioutil.WriteFile(cmd.output, []byte(cmd.contents), 0644); err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	}
+	c := exec.Command(cmd.args[0], cmd.args[1:]...)
+	c.Stdout = w
+	c.Stderr = w
+	if len(cmd.output) > 0 {
+		f, err := os.Create(cmd.output)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer f.Close()
+		c.Stdout = f
+	}
+	if err := c.Run(); err != nil {
+		return errors.Wrapf(err, "unable to run %q", strings.Join(cmd.args, " "))
+	}
+	return nil
+}
+
+// writeScript writes a shell script running the given steps to w. Forced steps
+// are run even if their output directory is present.
+func writeScript(w io.Writer, steps []*step, forced func(name string) bool) {
+	fmt.Fprintln(w, "#!/bin/bash")
+	for _, s := range steps {
+		for _, req := range s.requires {
+			fmt.Fprintln(w)
+			fmt.Fprintf(w, "# %s\n", req.comment)
+			fmt.Fprintf(w, "if [ ! -f %s ]; then\n", quote(req.path))
+			for _, line := range req.msg {
+				fmt.Fprintf(w, "\techo %s\n", quote(line))
+			}
+			fmt.Fprintln(w, "\texit 1")
+			fmt.Fprintln(w, "fi")
+		}
+		if len(s.cmds) == 0 {
+			continue
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "# %s\n", s.comment)
+		if len(s.msg) > 0 {
+			fmt.Fprintf(w, "echo %s\n", quote(s.msg))
+		}
+		indent := ""
+		guarded := len(s.outputDir) > 0 && !forced(s.name)
+		if guarded {
+			fmt.Fprintf(w, "if [ ! -d %s ]; then\n", quote(s.outputDir))
+			indent = "\t"
+		}
+		if dirs := s.allDirs(); len(dirs) > 0 {
+			fmt.Fprintf(w, "%smkdir -p %s\n", indent, quoteAll(dirs))
+		}
+		for _, cmd := range s.cmds {
+			cmd.writeScript(w, indent)
+		}
+		if guarded {
+			fmt.Fprintln(w, "fi")
+		}
+	}
+}
+
+// writeScript writes the shell script of the command to w, indented by the
+// given prefix.
+func (cmd *command) writeScript(w io.Writer, indent string) {
+	if len(cmd.comment) > 0 {
+		fmt.Fprintf(w, "%s# %s\n", indent, cmd.comment)
+	}
+	if len(cmd.msg) > 0 {
+		fmt.Fprintf(w, "%secho %s\n", indent, quote(cmd.msg))
+	}
+	if len(cmd.cond) > 0 {
+		fmt.Fprintf(w, "%sif [ -e %s ]; then\n", indent, quote(cmd.cond))
+		indent += "\t"
+	}
+	switch {
+	case cmd.args == nil:
+		// Contents are written verbatim, thus not indented.
+		fmt.Fprintf(w, "%scat > %s <<'EOF'\n", indent, quote(cmd.output))
+		fmt.Fprint(w, cmd.contents)
+		fmt.Fprintln(w, "EOF")
+	case len(cmd.output) > 0:
+		fmt.Fprintf(w, "%s%s > %s\n", indent, quoteAll(cmd.args), quote(cmd.output))
+	default:
+		fmt.Fprintf(w, "%s%s\n", indent, quoteAll(cmd.args))
+	}
+	if len(cmd.cond) > 0 {
+		fmt.Fprintf(w, "%sfi\n", indent[:len(indent)-1])
+	}
+}
+
+// quoteAll returns the given shell words quoted as required, separated by
+// spaces.
+func quoteAll(words []string) string {
+	var qs []string
+	for _, word := range words {
+		qs = append(qs, quote(word))
+	}
+	return strings.Join(qs, " ")
+}
+
+// quote returns the given shell word, quoted if containing special characters.
+func quote(word string) string {
+	if len(word) == 0 {
+		return `""`
+	}
+	safe := func(r rune) bool {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+			return true
+		}
+		return strings.ContainsRune("_-./%=,:+", r)
+	}
+	for _, r := range word {
+		if !safe(r) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`").Replace(word) + `"`
+		}
+	}
+	return word
+}
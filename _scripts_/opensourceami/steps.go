@@ -0,0 +1,518 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sanctuary/ember/asset"
+	"github.com/sanctuary/ember/dtype"
+)
+
+// modDir specifies the output directory of the converted game assets, relative
+// to the asset directory.
+const modDir = "../mods/ember"
+
+// townPal specifies the path to the palette of sprites, relative to the root of
+// the MPQ archive.
+const townPal = "levels/towndata/town.pal"
+
+// A config specifies the configuration of the pipeline.
+type config struct {
+	// Dungeon types to convert.
+	dts []*dtype.DungeonType
+	// Specifies whether to convert the Hellfire game assets.
+	hellfire bool
+	// Path to additional dungeon type definitions; empty if none.
+	dtypesPath string
+	// Directory containing the extracted Diablo 1 game assets; "diabdat", or
+	// "spawn" for the shareware spawn.mpq.
+	diabdatDir string
+	// Specifies whether the Diablo 1 game assets are those of the shareware
+	// spawn.mpq, lacking most monsters and levels. Commands of missing assets
+	// are skipped.
+	spawn bool
+	// Comma-separated list of MPQ archives read directly by the tools (e.g.
+	// "diabdat.mpq,hellfire.mpq"); empty if the tools read the extracted MPQ
+	// archives.
+	mpqList string
+	// MPQ archives read directly, mapping from archive name (e.g. "diabdat")
+	// to the path of the MPQ archive; nil if the MPQ archives are extracted.
+	mpqs map[string]string
+	// Assets of the MPQ archives read directly; nil if the MPQ archives are
+	// extracted.
+	assets *asset.Resolver
+}
+
+// archivePath returns the path to the given file of the extracted MPQ archive,
+// relative to the asset directory. The shareware spawn.mpq stands in for
+// diabdat.mpq.
+//
+// If the MPQ archives are read directly, the path to the MPQ archive itself is
+// returned, as the file is located within the MPQ archive by the tools.
+func (cfg *config) archivePath(archive, relPath string) string {
+	if archive == dtype.ArchiveDiabdat {
+		archive = cfg.diabdatDir
+	}
+	if cfg.mpqs != nil {
+		if mpqPath, ok := cfg.mpqs[archive]; ok {
+			return mpqPath
+		}
+		return archive + ".mpq"
+	}
+	return filepath.Join(archive, filepath.FromSlash(relPath))
+}
+
+// skipMissing skips the given command if the given file of the MPQ archive is
+// missing from the shareware spawn.mpq.
+func (cfg *config) skipMissing(cmd *command, archive, relPath string) {
+	if !cfg.spawn {
+		return
+	}
+	if cfg.assets != nil {
+		// The file is located within the MPQ archive read directly; the
+		// command is skipped by the path of the file as if extracted, which
+		// does not exist.
+		if !cfg.assets.Exists(relPath) {
+			cmd.cond = filepath.Join(cfg.diabdatDir, filepath.FromSlash(relPath))
+		}
+		return
+	}
+	cmd.cond = cfg.archivePath(archive, relPath)
+}
+
+// toolArgs returns the command line arguments running the given tool with the
+// given arguments. Tools read the MPQ archives directly if given by -mpq.
+func (cfg *config) toolArgs(tool string, args ...string) []string {
+	toolArgs := []string{tool}
+	if len(cfg.mpqList) > 0 {
+		toolArgs = append(toolArgs, "-mpq", cfg.mpqList)
+	}
+	return append(toolArgs, args...)
+}
+
+// pipeline returns the steps converting the original Diablo 1 game assets into
+// the file formats used by Ember.
+//
+// There are no dump or fix arches steps. The tools decode the level graphics
+// in-process, and gentilesetdef draws arches onto the dungeon pieces of tileset
+// images itself, so no intermediate images are dumped (previously _dump_, as
+// output by cel_dump and min_dump, and modified by fixarches). Extraction of
+// the MPQ archives is not a step either; the check step locates the extracted
+// archives, as the inputs of each command are hashed by their paths within the
+// extracted archives. Alternatively, the tools read the MPQ archives directly
+// (opensourceami -mpq), and the MPQ archives are hashed as inputs instead.
+func pipeline(cfg *config) []*step {
+	return []*step{
+		checkStep(cfg),
+		tilesetsStep(cfg),
+		monstersStep(cfg),
+		cursorsStep(cfg),
+		musicStep(cfg),
+		soundsStep(cfg),
+		townersStep(cfg),
+	}
+}
+
+// checkStep returns a step locating the extracted MPQ archives.
+func checkStep(cfg *config) *step {
+	if cfg.mpqs != nil {
+		return checkMPQStep(cfg)
+	}
+	s := &step{
+		name: "check",
+		desc: "locate extracted MPQ archives",
+		requires: []requirement{
+			{
+				comment: "Locate extracted diabdat.mpq",
+				path:    cfg.archivePath(dtype.ArchiveDiabdat, "levels/towndata/town.cel"),
+				msg: []string{
+					`Unable to locate "diabdat" directory containing the contents of diabdat.mpq`,
+					"",
+					`   Please extract diabdat.mpq to "_assets_/diabdat/" using`,
+					"   Ladislav Zezula's MPQ Editor [1]. Alternatively, extract the",
+					`   shareware spawn.mpq to "_assets_/spawn/".`,
+					"",
+					"   [1]: http://www.zezula.net/en/mpq/download.html",
+				},
+			},
+		},
+	}
+	if cfg.hellfire {
+		req := requirement{
+			comment: "Locate extracted hellfire.mpq",
+			path:    "hellfire/nlevels/l5data/l5.sol",
+			msg: []string{
+				`Unable to locate "hellfire" directory containing the contents of hellfire.mpq`,
+			},
+		}
+		s.requires = append(s.requires, req)
+	}
+	return s
+}
+
+// checkMPQStep returns a step locating the MPQ archives read directly.
+func checkMPQStep(cfg *config) *step {
+	s := &step{
+		name: "check",
+		desc: "locate MPQ archives",
+		requires: []requirement{
+			{
+				comment: "Locate diabdat.mpq",
+				path:    cfg.archivePath(dtype.ArchiveDiabdat, ""),
+				msg: []string{
+					`Unable to locate diabdat.mpq (or the shareware spawn.mpq) given by -mpq`,
+				},
+			},
+		},
+	}
+	if cfg.hellfire {
+		req := requirement{
+			comment: "Locate hellfire.mpq",
+			path:    cfg.archivePath(dtype.ArchiveHellfire, ""),
+			msg: []string{
+				`Unable to locate hellfire.mpq given by -mpq`,
+			},
+		}
+		s.requires = append(s.requires, req)
+	}
+	return s
+}
+
+// tilesetsStep returns a step generating the tilesets and tileset definitions
+// of the dungeon types.
+func tilesetsStep(cfg *config) *step {
+	tilesetDefDir := filepath.Join(modDir, "tileset")
+	s := &step{
+		name:      "tilesets",
+		desc:      "generate tilesets and tileset definitions",
+		comment:   "Generate tilesets and tileset definitions.",
+		msg:       "Generate tilesets.",
+		deps:      []string{"check"},
+		outputDir: filepath.Join(modDir, "images", "tileset"),
+		dirs:      []string{tilesetDefDir},
+	}
+	for _, dt := range cfg.dts {
+		title := strings.Title(dt.Title)
+		for i, theme := range dt.Themes {
+			args := cfg.toolArgs("gentilesetdef", "-dtype", dt.Name)
+			if len(cfg.dtypesPath) > 0 {
+				args = append(args, "-dtypes", cfg.dtypesPath)
+			}
+			if len(theme.Name) > 0 {
+				args = append(args, "-theme", theme.Name)
+			}
+			args = append(args, "-trim")
+			if dt.PaletteCycle != nil {
+				args = append(args, "-anim")
+			}
+			if dt.Dark {
+				args = append(args, "-darkness")
+			}
+			args = append(args, "-atlas", s.outputDir)
+			tilesetName := dt.TilesetName(theme)
+			cmd := &command{
+				args:   args,
+				output: filepath.Join(tilesetDefDir, tilesetName+".txt"),
+				// Tileset atlas image.
+				outputs: []string{filepath.Join(s.outputDir, tilesetName+".png")},
+				inputs: []string{
+					cfg.archivePath(dt.Archive, dt.DataDir),
+					cfg.archivePath(dt.Archive, theme.Palette),
+				},
+			}
+			if len(cfg.dtypesPath) > 0 {
+				cmd.inputs = append(cmd.inputs, cfg.dtypesPath)
+			}
+			cfg.skipMissing(cmd, dt.Archive, theme.Palette)
+			if i == 0 {
+				cmd.comment = title + "."
+				cmd.msg = fmt.Sprintf("Generate %s tilesets.", title)
+			}
+			s.cmds = append(s.cmds, cmd)
+		}
+	}
+	return s
+}
+
+// A monster specifies the graphics of a monster.
+type monster struct {
+	// Monster title (e.g. "Black Knight").
+	title string
+	// Base name of the sprite sheet (e.g. "black_knight").
+	name string
+	// Path format of the CL2 files of the monster, with %c replaced by the
+	// action (e.g. "monsters/black/black%c.cl2").
+	cl2 string
+	// Actions of the monster (e.g. "adhnw").
+	actions string
+	// Cell size of the sprite sheet (e.g. "160x160"); empty for the default
+	// size.
+	size string
+}
+
+// monsters specifies the graphics of monsters.
+var monsters = []monster{
+	{title: "Spitting Terror", name: "spitting_terror", cl2: "monsters/acid/acid%c.cl2", actions: "adhnsw"},
+	{title: "Winged Fiend", name: "winged_fiend", cl2: "monsters/bat/bat%c.cl2", actions: "adhnw"},
+	{title: "Devil Kin Brute", name: "devil_kin_brute", cl2: "monsters/bigfall/fallg%c.cl2", actions: "adhnw"},
+	{title: "Black Knight", name: "black_knight", cl2: "monsters/black/black%c.cl2", actions: "adhnw", size: "160x160"},
+	{title: "Dark Mage", name: "dark_mage", cl2: "monsters/darkmage/dmage%c.cl2", actions: "adhns"},
+	{title: "Bone Demon", name: "bone_demon", cl2: "monsters/demskel/demskl%c.cl2", actions: "adhnsw"},
+	{title: "Diablo", name: "diablo", cl2: "monsters/diablo/diablo%c.cl2", actions: "adhnsw"},
+	{title: "Fallen One Spear Wielder", name: "fallen_one_spear_wielder", cl2: "monsters/falspear/phall%c.cl2", actions: "adhnsw"},
+	{title: "Fallen One Sword Wielder", name: "fallen_one_sword_wielder", cl2: "monsters/falsword/fall%c.cl2", actions: "adhnsw"},
+	{title: "Overlord", name: "overlord", cl2: "monsters/fat/fat%c.cl2", actions: "adhnsw"},
+	{title: "Butcher", name: "butcher", cl2: "monsters/fatc/fatc%c.cl2", actions: "adhnw"},
+	{title: "Fireman", name: "fireman", cl2: "monsters/fireman/firem%c.cl2", actions: "adhnsw", size: "128x171"},
+	{title: "Gargoyle", name: "gargoyle", cl2: "monsters/gargoyle/gargo%c.cl2", actions: "adhnsw"},
+	{title: "Goat Archer", name: "goat_archer", cl2: "monsters/goatbow/goatb%c.cl2", actions: "adhnw"},
+	{title: "Goat Lord", name: "goat_lord", cl2: "monsters/goatlord/goatl%c.cl2", actions: "adhnw", size: "160x160"},
+	{title: "Goat Mace Wielder", name: "goat_mace_wielder", cl2: "monsters/goatmace/goat%c.cl2", actions: "adhnsw"},
+	{title: "Golem", name: "golem", cl2: "monsters/golem/golem%c.cl2", actions: "adsw"},
+	{title: "Mage", name: "mage", cl2: "monsters/mage/mage%c.cl2", actions: "adhns"},
+	{title: "Magma Demon", name: "magma_demon", cl2: "monsters/magma/magma%c.cl2", actions: "adhnsw"},
+	{title: "Balrog", name: "balrog", cl2: "monsters/mega/mega%c.cl2", actions: "adhnsw"},
+	{title: "Horned Demon", name: "horned_demon", cl2: "monsters/rhino/rhino%c.cl2", actions: "adhnsw"},
+	{title: "Scavenger", name: "scavenger", cl2: "monsters/scav/scav%c.cl2", actions: "adhnsw"},
+	{title: "Skeleton Axe Wielder", name: "skeleton_axe_wielder", cl2: "monsters/skelaxe/sklax%c.cl2", actions: "adhnsw"},
+	{title: "Skeleton Archer", name: "skeleton_archer", cl2: "monsters/skelbow/sklbw%c.cl2", actions: "adhnsw"},
+	{title: "Skeleton Sword Wielder", name: "skeleton_sword_wielder", cl2: "monsters/skelsd/sklsr%c.cl2", actions: "adhnsw"},
+	{title: "Skeleton King", name: "skeleton_king", cl2: "monsters/sking/sking%c.cl2", actions: "adhnsw"},
+	{title: "Viper", name: "viper", cl2: "monsters/snake/snake%c.cl2", actions: "adhnsw"},
+	{title: "Hidden", name: "hidden", cl2: "monsters/sneak/sneak%c.cl2", actions: "adhnsw"},
+	{title: "Succubus", name: "succubus", cl2: "monsters/succ/scbs%c.cl2", actions: "adhnw"},
+	{title: "Litch Demon", name: "litch_demon", cl2: "monsters/thin/thin%c.cl2", actions: "adhnsw"},
+	{title: "Invisible Lord", name: "invisible_lord", cl2: "monsters/tsneak/tsneak%c.cl2", actions: "adhnw"},
+	{title: "Unraveler", name: "unraveler", cl2: "monsters/unrav/unrav%c.cl2", actions: "adhnsw", size: "96x128"},
+	{title: "Zombie", name: "zombie", cl2: "monsters/zombie/zombie%c.cl2", actions: "adhnsw"},
+}
+
+// monstersStep returns a step generating monster graphics.
+func monstersStep(cfg *config) *step {
+	s := &step{
+		name:      "monsters",
+		desc:      "generate monster graphics",
+		comment:   "Generate monster graphics.",
+		msg:       "Generate monster graphics.",
+		deps:      []string{"check"},
+		outputDir: filepath.Join(modDir, "images", "monster"),
+	}
+	for _, monster := range monsters {
+		args := cfg.toolArgs("gensprite", "-cl2", monster.cl2, "-actions", monster.actions)
+		if len(monster.size) > 0 {
+			args = append(args, "-size", monster.size)
+		}
+		pngPath := filepath.Join(s.outputDir, monster.name+".png")
+		args = append(args, "-o", pngPath)
+		cmd := &command{
+			comment: monster.title,
+			msg:     fmt.Sprintf("Generating %s graphics.", monster.title),
+			args:    args,
+			outputs: []string{pngPath},
+			inputs:  []string{cfg.archivePath(dtype.ArchiveDiabdat, townPal)},
+		}
+		for _, action := range monster.actions {
+			cl2Path := fmt.Sprintf(monster.cl2, action)
+			cmd.inputs = append(cmd.inputs, cfg.archivePath(dtype.ArchiveDiabdat, cl2Path))
+		}
+		cfg.skipMissing(cmd, dtype.ArchiveDiabdat, fmt.Sprintf(monster.cl2, monster.actions[0]))
+		s.cmds = append(s.cmds, cmd)
+	}
+	return s
+}
+
+// cursorsStep returns a step generating cursor graphics.
+func cursorsStep(cfg *config) *step {
+	s := &step{
+		name:      "cursors",
+		desc:      "generate cursor graphics",
+		comment:   "Generate cursor graphics.",
+		msg:       "Generate cursor graphics.",
+		deps:      []string{"check"},
+		outputDir: filepath.Join(modDir, "images", "cursor"),
+	}
+	const celPath = "data/inv/objcurs.cel"
+	pngPath := filepath.Join(s.outputDir, "cursor_hand.png")
+	cmd := &command{
+		args:    cfg.toolArgs("gensprite", "-cel", celPath, "-frame", "1", "-width", "33", "-o", pngPath),
+		outputs: []string{pngPath},
+		inputs: []string{
+			cfg.archivePath(dtype.ArchiveDiabdat, celPath),
+			cfg.archivePath(dtype.ArchiveDiabdat, townPal),
+		},
+	}
+	cfg.skipMissing(cmd, dtype.ArchiveDiabdat, celPath)
+	s.cmds = append(s.cmds, cmd)
+	return s
+}
+
+// musicStep returns a step converting music from WAV to Ogg. The music tracks
+// of levels are looped, as specified by loop points stored in the Ogg comments.
+func musicStep(cfg *config) *step {
+	s := &step{
+		name:      "music",
+		desc:      "convert music from WAV to Ogg",
+		comment:   "Convert music from wav to ogg.",
+		msg:       "Converting music from wav to ogg.",
+		deps:      []string{"check"},
+		outputDir: filepath.Join(modDir, "music"),
+	}
+	for _, t := range dtype.Tracks {
+		cmd := convertMusic(cfg, s.outputDir, t)
+		if t.Archive == dtype.ArchiveHFMusic {
+			if !cfg.hellfire {
+				continue
+			}
+			cmd.cond = cfg.archivePath(dtype.ArchiveHFMusic, "")
+		} else {
+			cfg.skipMissing(cmd, t.Archive, t.WavPath)
+		}
+		s.cmds = append(s.cmds, cmd)
+	}
+	return s
+}
+
+// convertMusic returns a command converting the given music track from WAV to
+// Ogg (using wav2ogg), storing the Ogg file in outputDir.
+func convertMusic(cfg *config, outputDir string, t dtype.Track) *command {
+	wavPath := cfg.archivePath(t.Archive, t.WavPath)
+	oggPath := filepath.Join(outputDir, filepath.Base(t.Path()))
+	args := cfg.toolArgs("wav2ogg", "-o", oggPath)
+	if len(t.DTypes) > 0 {
+		args = append(args, "-loop")
+	}
+	// The WAV file is located within the MPQ archives read directly.
+	wavArg := wavPath
+	if cfg.mpqs != nil {
+		wavArg = t.WavPath
+	}
+	return &command{
+		args:    append(args, wavArg),
+		outputs: []string{oggPath},
+		inputs:  []string{wavPath},
+	}
+}
+
+// soundsStep returns a step extracting the sound effects of diablo.exe (using
+// extract_sounds). The step is skipped if diablo.exe is missing from the asset
+// directory.
+//
+// The sound effects are located by the sound effect table of diablo.exe, and
+// are thus only known after the first run; the sound effects listed by the
+// index of the last run are outputs of the step, so that missing sound effects
+// are regenerated.
+func soundsStep(cfg *config) *step {
+	s := &step{
+		name:      "sounds",
+		desc:      "convert sound effects from WAV to Ogg",
+		comment:   "Convert sound effects from wav to ogg.",
+		msg:       "Converting sound effects from wav to ogg.",
+		deps:      []string{"check"},
+		outputDir: filepath.Join(modDir, "soundfx"),
+	}
+	const exePath = "diablo.exe"
+	indexPath := filepath.Join(s.outputDir, "index.txt")
+	outputs := []string{indexPath}
+	seen := make(map[string]bool)
+	// sound=ID,PATH
+	for _, val := range keyValues(indexPath, "sound") {
+		relOggPath := val[strings.LastIndex(val, ",")+1:]
+		if !seen[relOggPath] {
+			seen[relOggPath] = true
+			outputs = append(outputs, filepath.Join(modDir, filepath.FromSlash(relOggPath)))
+		}
+	}
+	s.cmds = []*command{
+		{
+			args:    cfg.toolArgs("extract_sounds", "-q", "-mod", modDir, exePath),
+			outputs: outputs,
+			inputs:  []string{exePath, cfg.archivePath(dtype.ArchiveDiabdat, "sfx")},
+			cond:    exePath,
+		},
+	}
+	return s
+}
+
+// townersStep returns a step extracting the inhabitants of Tristram (using
+// extract_towners), adding them to the Tristram map. The step is skipped if
+// diablo.exe is missing from the asset directory.
+//
+// The NPC sections of the Tristram map are replaced by each run, and the step
+// is rerun if the rest of the map changes. The NPC definitions, animations and
+// sprite sheets of the towners spawned by the map are outputs of the step, so
+// that missing files are regenerated.
+func townersStep(cfg *config) *step {
+	s := &step{
+		name:      "towners",
+		desc:      "extract towners of Tristram",
+		comment:   "Extract towners of Tristram.",
+		msg:       "Extracting towners of Tristram.",
+		deps:      []string{"check"},
+		outputDir: filepath.Join(modDir, "npcs"),
+	}
+	const exePath = "diablo.exe"
+	mapPath := filepath.Join(modDir, "maps", "tristram.txt")
+	s.cmds = []*command{
+		{
+			args:    cfg.toolArgs("extract_towners", "-q", "-mod", modDir, exePath),
+			outputs: append([]string{mapPath}, townerOutputs(mapPath)...),
+			inputs:  []string{exePath, cfg.archivePath(dtype.ArchiveDiabdat, "towners")},
+			updates: []string{mapPath},
+			cond:    exePath,
+		},
+	}
+	return s
+}
+
+// townerOutputs returns the paths of the NPC definitions, animations and sprite
+// sheets of the towners spawned by the given map, as of the last run of
+// extract_towners. The files are located by following the references of spawn
+// entries to NPC definitions (filename=), of NPC definitions to animations
+// (gfx=), and of animations to sprite sheets (image=).
+func townerOutputs(mapPath string) []string {
+	var outputs []string
+	seen := make(map[string]bool)
+	add := func(relPath string) string {
+		path := filepath.Join(modDir, filepath.FromSlash(relPath))
+		if !seen[path] {
+			seen[path] = true
+			outputs = append(outputs, path)
+		}
+		return path
+	}
+	for _, relNPCPath := range keyValues(mapPath, "filename") {
+		npcPath := add(relNPCPath)
+		for _, relAnimPath := range keyValues(npcPath, "gfx") {
+			animPath := add(relAnimPath)
+			for _, relImgPath := range keyValues(animPath, "image") {
+				add(relImgPath)
+			}
+		}
+	}
+	return outputs
+}
+
+// keyValues returns the values of the given key in the file at path, holding
+// one key=value pair per line (e.g. "sound=0,soundfx/misc/walk1.ogg" of
+// soundfx/index.txt). No values are returned if the file is missing or
+// unreadable.
+func keyValues(path, key string) []string {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			dbg.Printf("unable to read %q; %v", path, err)
+		}
+		return nil
+	}
+	var vals []string
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, key+"=") {
+			vals = append(vals, line[len(key)+1:])
+		}
+	}
+	return vals
+}
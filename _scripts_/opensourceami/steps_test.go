@@ -0,0 +1,227 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/sanctuary/ember/asset"
+	"github.com/sanctuary/ember/dtype"
+)
+
+func TestPipelineMPQ(t *testing.T) {
+	dt, err := dtype.Get("l1")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// Contents of the shareware spawn.mpq, lacking most monsters.
+	assets := asset.New()
+	spawn := fstest.MapFS{
+		"levels/towndata/town.pal":    {},
+		"monsters/zombie/zombiea.cl2": {},
+		"music/dtowne.wav":            {},
+	}
+	if err := assets.AddFS(dtype.ArchiveSpawn, spawn); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	cfg := &config{
+		dts:        []*dtype.DungeonType{dt},
+		diabdatDir: dtype.ArchiveSpawn,
+		spawn:      true,
+		mpqList:    "spawn.mpq",
+		mpqs:       map[string]string{dtype.ArchiveSpawn: "spawn.mpq"},
+		assets:     assets,
+	}
+	steps := make(map[string]*step)
+	for _, s := range pipeline(cfg) {
+		steps[s.name] = s
+	}
+
+	// The MPQ archive is required in place of the extracted MPQ archive.
+	if got, want := steps["check"].requires[0].path, "spawn.mpq"; got != want {
+		t.Errorf("required file mismatch; expected %q, got %q", want, got)
+	}
+
+	// Tools read the MPQ archive directly, which is hashed as input.
+	cmd := steps["tilesets"].cmds[0]
+	if got, want := cmd.args[:3], []string{"gentilesetdef", "-mpq", "spawn.mpq"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tileset arguments mismatch; expected %q, got %q", want, got)
+	}
+	if got, want := cmd.inputs, []string{"spawn.mpq", "spawn.mpq"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tileset inputs mismatch; expected %q, got %q", want, got)
+	}
+	var music *command
+	for _, cmd := range steps["music"].cmds {
+		if cmd.args[len(cmd.args)-1] == "music/dtowne.wav" {
+			music = cmd
+		}
+	}
+	if music == nil {
+		t.Fatalf("unable to locate command converting %q", "music/dtowne.wav")
+	}
+	if got, want := music.args[:3], []string{"wav2ogg", "-mpq", "spawn.mpq"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("music arguments mismatch; expected %q, got %q", want, got)
+	}
+	if len(music.cond) > 0 {
+		t.Errorf("expected music track contained within spawn.mpq to be converted, got condition %q", music.cond)
+	}
+
+	// Commands of files missing from spawn.mpq are skipped.
+	for _, cmd := range steps["monsters"].cmds {
+		name := filepath.Base(cmd.outputs[0])
+		switch name {
+		case "zombie.png":
+			if len(cmd.cond) > 0 {
+				t.Errorf("%s: expected monster contained within spawn.mpq to be generated, got condition %q", name, cmd.cond)
+			}
+		default:
+			if len(cmd.cond) == 0 {
+				t.Errorf("%s: expected monster missing from spawn.mpq to be skipped", name)
+			}
+		}
+	}
+}
+
+func TestSoundsStep(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.Chdir(wd)
+	assetDir := filepath.Join(t.TempDir(), "_assets_")
+	if err := os.MkdirAll(assetDir, 0755); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := os.Chdir(assetDir); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	cfg := &config{diabdatDir: "diabdat"}
+	indexPath := filepath.Join(modDir, "soundfx", "index.txt")
+
+	// Before the first run, the sound effects are unknown.
+	want := []string{indexPath}
+	if got := soundsStep(cfg).cmds[0].outputPaths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("outputs mismatch before first run; expected %q, got %q", want, got)
+	}
+
+	// After the first run, the sound effects of the index are outputs.
+	const index = `# Sound effects of Diablo 1.
+#
+#    sound=ID,PATH
+
+sound=0,soundfx/misc/walk1.ogg
+sound=1,soundfx/misc/walk2.ogg
+sound=2,soundfx/misc/walk1.ogg
+`
+	if err := os.MkdirAll(filepath.Dir(indexPath), 0755); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := ioutil.WriteFile(indexPath, []byte(index), 0644); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	want = []string{
+		indexPath,
+		filepath.Join(modDir, "soundfx", "misc", "walk1.ogg"),
+		filepath.Join(modDir, "soundfx", "misc", "walk2.ogg"),
+	}
+	if got := soundsStep(cfg).cmds[0].outputPaths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("outputs mismatch after first run; expected %q, got %q", want, got)
+	}
+}
+
+func TestTownersStep(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.Chdir(wd)
+	assetDir := filepath.Join(t.TempDir(), "_assets_")
+	if err := os.MkdirAll(assetDir, 0755); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := os.Chdir(assetDir); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	cfg := &config{diabdatDir: "diabdat"}
+	mapPath := filepath.Join(modDir, "maps", "tristram.txt")
+
+	// Files of the mod directory as of the last run of extract_towners,
+	// mapping from file path relative to the mod directory to contents. The
+	// sprite sheets are missing, yet referred to.
+	files := map[string]string{
+		"maps/tristram.txt": `[header]
+title=Tristram
+
+[event]
+intermap=maps/cathedral_00000000.txt,78,70
+
+[npc]
+type=npc
+location=62,63,1,1
+filename=npcs/griswold.txt
+
+[npc]
+type=npc
+location=58,16,1,1
+filename=npcs/cow_1.txt
+
+[npc]
+type=npc
+location=56,14,1,1
+filename=npcs/cow_2.txt
+`,
+		"npcs/griswold.txt":            "name=Griswold the Blacksmith\ngfx=animations/npcs/griswold.txt\ndirection=5\n",
+		"npcs/cow_1.txt":               "name=Cow\ngfx=animations/npcs/cow.txt\n",
+		"npcs/cow_2.txt":               "name=Cow\ngfx=animations/npcs/cow.txt\n",
+		"animations/npcs/griswold.txt": "image=images/npcs/griswold.png\n\n[stance]\nframes=16\n",
+	}
+	for relPath, contents := range files {
+		path := filepath.Join(modDir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	cmd := townersStep(cfg).cmds[0]
+	want := []string{
+		mapPath,
+		filepath.Join(modDir, "npcs", "griswold.txt"),
+		filepath.Join(modDir, "animations", "npcs", "griswold.txt"),
+		filepath.Join(modDir, "images", "npcs", "griswold.png"),
+		filepath.Join(modDir, "npcs", "cow_1.txt"),
+		filepath.Join(modDir, "animations", "npcs", "cow.txt"),
+		filepath.Join(modDir, "npcs", "cow_2.txt"),
+	}
+	if got := cmd.outputPaths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("outputs mismatch; expected %q, got %q", want, got)
+	}
+	if want := []string{mapPath}; !reflect.DeepEqual(cmd.updates, want) {
+		t.Errorf("updated files mismatch; expected %q, got %q", want, cmd.updates)
+	}
+
+	// The map is hashed as an updated file of the command.
+	m := newManifest("")
+	// Omit the tool executable from the hash, as extract_towners may not be
+	// installed.
+	cmd.args = nil
+	before, err := m.hash(cmd)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := ioutil.WriteFile(mapPath, []byte("[header]\ntitle=Tristram\n"), 0644); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	m.invalidate(cmd.updates)
+	after, err := m.hash(cmd)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if before == after {
+		t.Errorf("expected hash of command to change with the contents of the map, got %s", after)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mewkiz/pkg/osutil"
+	"github.com/pkg/errors"
+	"github.com/sanctuary/ember/asset"
+	"github.com/sanctuary/ember/dtype"
+)
+
+// Versions of the Diablo 1 game assets identified by directory layout, when not
+// matching any version of the table of known versions given by -versions (e.g.
+// "1.00" and "1.09").
+const (
+	// Unidentified version of diabdat.mpq.
+	versionUnknown = "unknown"
+	// Shareware spawn.mpq, containing Tristram and the Cathedral only.
+	versionSpawn = "spawn"
+)
+
+// A knownVersion specifies the hashes of the files of a known version of the
+// game assets.
+type knownVersion struct {
+	// Version name (e.g. "1.09").
+	name string
+	// Extracted MPQ archive containing the files of the version (e.g.
+	// "diabdat", "spawn" or "hellfire").
+	archive string
+	// File hashes, mapping from file path, relative to the asset directory, to
+	// SHA-1 hash of contents.
+	hashes map[string]string
+}
+
+// loadVersions loads the table of known versions of the game assets from the
+// given file.
+func loadVersions(path string) ([]*knownVersion, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	return parseVersions(f, path)
+}
+
+// parseVersions parses the table of known versions of the game assets read from
+// r. The path of the table is used in error messages.
+//
+// Each line of the table holds the version name, SHA-1 hash of contents and
+// file path relative to the asset directory, separated by spaces; lines
+// starting with '#' are comments. The files of a version are contained within
+// a single extracted MPQ archive, given by the first directory of their paths.
+// Tables may be generated from a verified copy of the game assets using
+// -dumpversion.
+//
+//    # VERSION SHA1 PATH
+//    1.09 <SHA-1 hash of l1.cel> diabdat/levels/l1data/l1.cel
+func parseVersions(r io.Reader, path string) ([]*knownVersion, error) {
+	var versions []*knownVersion
+	index := make(map[string]*knownVersion)
+	s := bufio.NewScanner(r)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) != 3 {
+			return nil, errors.Errorf("%s:%d: invalid version entry %q; expected VERSION HASH PATH", path, lineNum, line)
+		}
+		name, hash, filePath := parts[0], parts[1], parts[2]
+		archive := strings.SplitN(filePath, "/", 2)[0]
+		v, ok := index[name]
+		if !ok {
+			v = &knownVersion{name: name, archive: archive, hashes: make(map[string]string)}
+			index[name] = v
+			versions = append(versions, v)
+		}
+		if archive != v.archive {
+			return nil, errors.Errorf("%s:%d: file %q of version %q outside of archive %q", path, lineNum, filePath, name, v.archive)
+		}
+		v.hashes[filePath] = hash
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return versions, nil
+}
+
+// A preflightReport reports the identified version of the game assets, and the
+// missing and modified files.
+type preflightReport struct {
+	// Identified version of diabdat.mpq (e.g. "1.09").
+	version string
+	// Directory containing the extracted Diablo 1 game assets; "diabdat", or
+	// "spawn" for the shareware spawn.mpq.
+	diabdatDir string
+	// Specifies whether the game assets are of the shareware spawn.mpq.
+	spawn bool
+	// Number of files of diabdat.mpq matching the known hashes of the
+	// identified version; zero if identified by directory layout.
+	nmatched int
+	// Specifies whether the Hellfire game assets are present.
+	hellfire bool
+	// Identified version of hellfire.mpq; empty if unidentified.
+	hellfireVersion string
+	// Number of files of hellfire.mpq matching the known hashes of the
+	// identified version.
+	hellfireMatched int
+	// Missing files of the identified version, or required by the pipeline.
+	missing []string
+	// Files of the identified version with unknown contents.
+	modified []string
+	// Specifies whether the game assets are read directly from MPQ archives,
+	// identified by archive name.
+	mpq bool
+}
+
+// identify identifies the version of the extracted game assets and locates
+// missing and modified files. The versions of diabdat.mpq (or spawn.mpq) and
+// hellfire.mpq are identified by the given table of known versions, as the
+// version with the most matching files.
+//
+// Game assets not matching any known version are identified by directory
+// layout instead; the shareware spawn.mpq is told apart by its lack of
+// Catacombs level data, and hellfire.mpq by the presence of Crypt level data.
+func identify(versions []*knownVersion, m *manifest) (*preflightReport, error) {
+	report := &preflightReport{
+		version:    versionUnknown,
+		diabdatDir: dtype.ArchiveDiabdat,
+	}
+	if !osutil.Exists(dtype.ArchiveDiabdat) && osutil.Exists(dtype.ArchiveSpawn) {
+		report.diabdatDir = dtype.ArchiveSpawn
+	}
+	// Identify diabdat.mpq.
+	best, n, err := report.match(versions, m, dtype.ArchiveDiabdat, dtype.ArchiveSpawn)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	switch {
+	case best != nil:
+		report.version = best.name
+		report.spawn = best.archive == dtype.ArchiveSpawn
+		report.nmatched = n
+	case !osutil.Exists(filepath.Join(report.diabdatDir, "levels", "l2data")):
+		report.version = versionSpawn
+		report.spawn = true
+	}
+	// Identify hellfire.mpq.
+	best, n, err = report.match(versions, m, dtype.ArchiveHellfire)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if best != nil {
+		report.hellfire = true
+		report.hellfireVersion = best.name
+		report.hellfireMatched = n
+	} else {
+		report.hellfire = osutil.Exists("hellfire/nlevels/l5data/l5.sol")
+	}
+	sort.Strings(report.missing)
+	sort.Strings(report.modified)
+	return report, nil
+}
+
+// identifyMPQ identifies the game assets of the given MPQ archives, read
+// directly without extraction. The version of the game assets is not
+// identified; the shareware spawn.mpq and hellfire.mpq are told apart by
+// archive name.
+func identifyMPQ(assets *asset.Resolver) *preflightReport {
+	report := &preflightReport{
+		version:    versionUnknown,
+		diabdatDir: dtype.ArchiveDiabdat,
+		hellfire:   assets.Has(dtype.ArchiveHellfire),
+		mpq:        true,
+	}
+	if assets.Spawn() {
+		report.version = versionSpawn
+		report.diabdatDir = dtype.ArchiveSpawn
+		report.spawn = true
+	}
+	return report
+}
+
+// match locates the known version with the most files matching the game
+// assets, among the versions of the given archives, and adds its missing and
+// modified files to the preflight report. The number of matching files is
+// returned together with the version; or nil if no version has any matching
+// files.
+func (report *preflightReport) match(versions []*knownVersion, m *manifest, archives ...string) (*knownVersion, int, error) {
+	var best *knownVersion
+	nmatched := 0
+	for _, v := range versions {
+		if !contains(archives, v.archive) {
+			continue
+		}
+		n := 0
+		for filePath, hash := range v.hashes {
+			if !osutil.Exists(filePath) {
+				continue
+			}
+			fileHash, err := m.fileHash(filePath)
+			if err != nil {
+				return nil, 0, errors.WithStack(err)
+			}
+			if fileHash == hash {
+				n++
+			}
+		}
+		if n > nmatched {
+			best = v
+			nmatched = n
+		}
+	}
+	if best == nil {
+		return nil, 0, nil
+	}
+	for filePath, hash := range best.hashes {
+		if !osutil.Exists(filePath) {
+			report.missing = append(report.missing, filePath)
+			continue
+		}
+		fileHash, err := m.fileHash(filePath)
+		if err != nil {
+			return nil, 0, errors.WithStack(err)
+		}
+		if fileHash != hash {
+			report.modified = append(report.modified, filePath)
+		}
+	}
+	return best, nmatched, nil
+}
+
+// locateMissing adds the missing files of the given required files to the
+// preflight report.
+func (report *preflightReport) locateMissing(required []string) {
+	for _, filePath := range required {
+		if !osutil.Exists(filePath) && !contains(report.missing, filePath) {
+			report.missing = append(report.missing, filePath)
+		}
+	}
+	sort.Strings(report.missing)
+}
+
+// log logs the preflight report.
+func (report *preflightReport) log() {
+	mpqName := "diabdat.mpq"
+	if report.spawn {
+		mpqName = "spawn.mpq"
+	}
+	switch {
+	case report.mpq:
+		dbg.Printf("identified %s version %s by archive name; MPQ archives read directly are not hashed by file.", mpqName, report.version)
+	case report.nmatched > 0:
+		dbg.Printf("identified %s version %s (%d matching files).", mpqName, report.version, report.nmatched)
+	default:
+		dbg.Printf("identified %s version %s by directory layout; no known file hashes matched.", mpqName, report.version)
+	}
+	switch {
+	case report.hellfireMatched > 0:
+		dbg.Printf("identified hellfire.mpq version %s (%d matching files).", report.hellfireVersion, report.hellfireMatched)
+	case report.hellfire && report.mpq:
+		dbg.Println("identified hellfire.mpq by archive name.")
+	case report.hellfire:
+		dbg.Println("identified hellfire.mpq by directory layout; no known file hashes matched.")
+	}
+	for _, filePath := range report.missing {
+		dbg.Printf("missing file %q.", filePath)
+	}
+	for _, filePath := range report.modified {
+		dbg.Printf("modified file %q.", filePath)
+	}
+}
+
+// supportsDType reports whether the given dungeon type is contained within the
+// game assets of the identified version.
+func (report *preflightReport) supportsDType(dt *dtype.DungeonType) bool {
+	if dt.Archive == dtype.ArchiveHellfire && !report.hellfire {
+		return false
+	}
+	if !report.spawn {
+		return true
+	}
+	return dt.Spawn
+}
+
+// archiveInputs returns the files of the extracted MPQ archives used as inputs
+// by the given steps, in sorted order. Missing inputs are included as is, except
+// for commands skipped due to missing files.
+func archiveInputs(steps []*step) ([]string, error) {
+	archives := []string{dtype.ArchiveDiabdat, dtype.ArchiveSpawn, dtype.ArchiveHellfire, dtype.ArchiveHFMusic}
+	seen := make(map[string]bool)
+	var filePaths []string
+	for _, s := range steps {
+		for _, cmd := range s.cmds {
+			if len(cmd.cond) > 0 && !osutil.Exists(cmd.cond) {
+				// Skip inputs of skipped commands.
+				continue
+			}
+			for _, input := range cmd.inputs {
+				archive := strings.SplitN(filepath.ToSlash(input), "/", 2)[0]
+				if !contains(archives, archive) {
+					continue
+				}
+				paths, err := inputFiles(input)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+				if len(paths) == 0 {
+					// Report missing inputs.
+					paths = []string{input}
+				}
+				for _, path := range paths {
+					if !seen[path] {
+						seen[path] = true
+						filePaths = append(filePaths, path)
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(filePaths)
+	return filePaths, nil
+}
+
+// writeVersion writes table entries of the given version to w, recording the
+// hashes of the given files.
+func writeVersion(w io.Writer, m *manifest, name string, filePaths []string) error {
+	for _, filePath := range filePaths {
+		if !osutil.Exists(filePath) {
+			continue
+		}
+		hash, err := m.fileHash(filePath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if _, err := fmt.Fprintf(w, "%s %s %s\n", name, hash, filepath.ToSlash(filePath)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// contains reports whether the given list contains s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseVersions(t *testing.T) {
+	const table = `
+# VERSION SHA1 PATH
+1.09 aaaa diabdat/levels/l1data/l1.cel
+1.09 bbbb diabdat/levels/l2data/l2.cel
+
+spawn-1.00 cccc spawn/levels/l1data/l1.cel
+`
+	versions, err := parseVersions(strings.NewReader(table[1:]), "versions.txt")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	want := []*knownVersion{
+		{
+			name:    "1.09",
+			archive: "diabdat",
+			hashes: map[string]string{
+				"diabdat/levels/l1data/l1.cel": "aaaa",
+				"diabdat/levels/l2data/l2.cel": "bbbb",
+			},
+		},
+		{
+			name:    "spawn-1.00",
+			archive: "spawn",
+			hashes: map[string]string{
+				"spawn/levels/l1data/l1.cel": "cccc",
+			},
+		},
+	}
+	if !reflect.DeepEqual(versions, want) {
+		t.Errorf("versions mismatch; expected %d versions %v, got %d versions %v", len(want), want, len(versions), versions)
+	}
+
+	// Invalid tables.
+	golden := []string{
+		"1.09 aaaa\n",
+		"1.09 aaaa diabdat/levels/l1data/l1.cel\n1.09 bbbb hellfire/nlevels/l5data/l5.cel\n",
+	}
+	for _, g := range golden {
+		if _, err := parseVersions(strings.NewReader(g), "versions.txt"); err == nil {
+			t.Errorf("%q: expected error, got nil", g)
+		}
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	golden := []struct {
+		// Files of the asset directory, mapping from file path to contents.
+		files map[string]string
+		// Table of known versions.
+		table string
+		want  preflightReport
+	}{
+		// Identified by directory layout.
+		{
+			files: map[string]string{
+				"diabdat/levels/l1data/l1.cel": "l1",
+				"diabdat/levels/l2data/l2.cel": "l2",
+			},
+			want: preflightReport{version: versionUnknown, diabdatDir: "diabdat"},
+		},
+		{
+			files: map[string]string{
+				"spawn/levels/l1data/l1.cel": "l1",
+			},
+			want: preflightReport{version: versionSpawn, diabdatDir: "spawn", spawn: true},
+		},
+		{
+			files: map[string]string{
+				"diabdat/levels/l1data/l1.cel":   "l1",
+				"diabdat/levels/l2data/l2.cel":   "l2",
+				"hellfire/nlevels/l5data/l5.sol": "l5",
+			},
+			want: preflightReport{version: versionUnknown, diabdatDir: "diabdat", hellfire: true},
+		},
+		// Identified by known file hashes, taking precedence over directory
+		// layout.
+		{
+			files: map[string]string{
+				"diabdat/levels/l1data/l1.cel": "l1 v1.09",
+				"diabdat/levels/l2data/l2.cel": "l2 modified",
+			},
+			table: "1.00 " + sha1Hex("l1 v1.00") + " diabdat/levels/l1data/l1.cel\n" +
+				"1.09 " + sha1Hex("l1 v1.09") + " diabdat/levels/l1data/l1.cel\n" +
+				"1.09 " + sha1Hex("l2 v1.09") + " diabdat/levels/l2data/l2.cel\n" +
+				"1.09 " + sha1Hex("l3 v1.09") + " diabdat/levels/l3data/l3.cel\n",
+			want: preflightReport{
+				version:    "1.09",
+				diabdatDir: "diabdat",
+				nmatched:   1,
+				missing:    []string{"diabdat/levels/l3data/l3.cel"},
+				modified:   []string{"diabdat/levels/l2data/l2.cel"},
+			},
+		},
+		{
+			files: map[string]string{
+				"diabdat/levels/l1data/l1.cel":   "l1 v1.09",
+				"hellfire/nlevels/l5data/l5.cel": "l5 v1.01",
+			},
+			table: "spawn-1.00 " + sha1Hex("l1 v1.09") + " spawn/levels/l1data/l1.cel\n" +
+				"1.09 " + sha1Hex("l1 v1.09") + " diabdat/levels/l1data/l1.cel\n" +
+				"hellfire-1.01 " + sha1Hex("l5 v1.01") + " hellfire/nlevels/l5data/l5.cel\n",
+			want: preflightReport{
+				version:         "1.09",
+				diabdatDir:      "diabdat",
+				nmatched:        1,
+				hellfire:        true,
+				hellfireVersion: "hellfire-1.01",
+				hellfireMatched: 1,
+			},
+		},
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.Chdir(wd)
+	for i, g := range golden {
+		assetDir := t.TempDir()
+		for filePath, contents := range g.files {
+			path := filepath.Join(assetDir, filepath.FromSlash(filePath))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+		versions, err := parseVersions(strings.NewReader(g.table), "versions.txt")
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if err := os.Chdir(assetDir); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		m := newManifest("")
+		report, err := identify(versions, m)
+		if err != nil {
+			t.Errorf("i=%d: %+v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(*report, g.want) {
+			t.Errorf("i=%d: report mismatch; expected %+v, got %+v", i, g.want, *report)
+		}
+	}
+}
+
+// sha1Hex returns the SHA-1 hash of the given contents, in hexadecimal.
+func sha1Hex(contents string) string {
+	sum := sha1.Sum([]byte(contents))
+	return hex.EncodeToString(sum[:])
+}
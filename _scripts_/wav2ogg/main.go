@@ -0,0 +1,125 @@
+// The wav2ogg tool converts WAV sound files (PCM or IMA ADPCM) of the Diablo 1
+// game assets to Ogg Vorbis, as used by FLARE.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mewkiz/pkg/pathutil"
+	"github.com/pkg/errors"
+	"github.com/sanctuary/ember/asset"
+	"github.com/sanctuary/ember/vorbis"
+	"github.com/sanctuary/ember/wav"
+)
+
+func usage() {
+	const use = `
+Convert WAV sound files (PCM or IMA ADPCM) to Ogg Vorbis.
+
+Usage:
+
+	wav2ogg [OPTION]... FILE.wav
+
+With -loop, the loop points of the sound are stored in the Ogg comments (in
+number of samples), looping the whole sound.
+
+	LOOPSTART=0
+	LOOPLENGTH=NSAMPLES
+
+Examples:
+
+	# Convert music track to Ogg Vorbis.
+	wav2ogg -o ../mods/ember/music/tristram.ogg diabdat/music/dtowne.wav
+
+	# Convert looping music track to Ogg Vorbis.
+	wav2ogg -loop -o ../mods/ember/music/cathedral.ogg diabdat/music/dlvla.wav
+
+	# Convert music track read directly from diabdat.mpq, without extraction.
+	wav2ogg -mpq diabdat.mpq -o ../mods/ember/music/tristram.ogg music/dtowne.wav
+
+Flags:
+`
+	fmt.Fprint(os.Stderr, use[1:])
+	flag.PrintDefaults()
+}
+
+func main() {
+	// Parse command line arguments.
+	var (
+		// output specifies the output path of the Ogg file.
+		output string
+		// loop specifies whether to store loop points in the Ogg comments.
+		loop bool
+		// mpqList specifies a comma-separated list of MPQ archives to read the
+		// WAV file from directly, without extraction.
+		mpqList string
+	)
+	flag.StringVar(&output, "o", "", `output path of Ogg file (default "FILE.ogg")`)
+	flag.BoolVar(&loop, "loop", false, "store loop points (LOOPSTART and LOOPLENGTH) in Ogg comments")
+	flag.StringVar(&mpqList, "mpq", "", `comma-separated list of MPQ archives to read FILE.wav from directly (e.g. "diabdat.mpq,hfmusic.mpq"); FILE.wav is then a path within the archives`)
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	wavPath := flag.Arg(0)
+	if len(output) == 0 {
+		output = pathutil.TrimExt(wavPath) + ".ogg"
+	}
+
+	// Read WAV file.
+	var buf []byte
+	if len(mpqList) > 0 {
+		assets, err := asset.NewFromMPQ(strings.Split(mpqList, ","))
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		if buf, err = assets.ReadFile(wavPath); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	} else {
+		var err error
+		if buf, err = ioutil.ReadFile(wavPath); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+
+	// Convert WAV file to Ogg Vorbis.
+	if err := convert(output, wavPath, buf, loop); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+// convert converts the given contents of a WAV file to Ogg Vorbis, storing the
+// Ogg file at oggPath. If loop is set, the loop points of the whole sound are
+// stored in the Ogg comments. The path of the WAV file is used in error
+// messages.
+func convert(oggPath, wavPath string, buf []byte, loop bool) error {
+	snd, err := wav.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return errors.Wrapf(err, "unable to decode %q", wavPath)
+	}
+	var comments []string
+	if loop {
+		// Loop points in number of samples of each channel.
+		comments = []string{
+			"LOOPSTART=0",
+			fmt.Sprintf("LOOPLENGTH=%d", snd.NFrames()),
+		}
+	}
+	out := &bytes.Buffer{}
+	if err := vorbis.Encode(out, snd.Samples, snd.NChannels, snd.SampleRate, comments); err != nil {
+		return errors.Wrapf(err, "unable to encode %q", oggPath)
+	}
+	if err := ioutil.WriteFile(oggPath, out.Bytes(), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
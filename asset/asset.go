@@ -0,0 +1,332 @@
+// Package asset resolves game asset paths across a set of layered MPQ archives
+// (e.g. diabdat, patch_rt and hellfire). The MPQ archives are either read
+// directly, or from directories containing the extracted archives.
+//
+// The shareware spawn.mpq stands in for diabdat.mpq, providing the assets of
+// Tristram and the Cathedral only.
+//
+// Asset paths are resolved case-insensitively, and accept both forward slashes
+// and backslashes as path separators (e.g. "Levels\L1Data\L1.SOL" and
+// "levels/l1data/l1.sol" refer to the same asset).
+package asset
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mewkiz/pkg/osutil"
+	"github.com/mewkiz/pkg/pathutil"
+	"github.com/pkg/errors"
+	"github.com/sanctuary/ember/mpq"
+)
+
+// Archives specifies the names of the known MPQ archives, in order of
+// increasing precedence; assets of later archives override assets of earlier
+// archives.
+var Archives = []string{
+	// Diablo 1 shareware game assets.
+	"spawn",
+	// Diablo 1 game assets.
+	"diabdat",
+	// Hellfire expansion game assets.
+	"hellfire",
+	// Hellfire monk graphics.
+	"hfmonk",
+	// Hellfire music.
+	"hfmusic",
+	// Hellfire voices.
+	"hfvoice",
+	// Diablo 1 patch.
+	"patch_rt",
+}
+
+// A Resolver resolves asset paths across layered MPQ archives.
+type Resolver struct {
+	// Archive layers, in order of decreasing precedence.
+	layers []*layer
+}
+
+// layer is an MPQ archive layer.
+type layer struct {
+	// Archive name (e.g. "diabdat").
+	name string
+	// Contents of the archive.
+	fsys fs.FS
+	// Path to the directory of the extracted archive; empty if not extracted.
+	dir string
+	// index maps from normalized asset path to the asset path within the
+	// archive; nil if the contents of the archive are located by name.
+	index map[string]string
+}
+
+// locator is implemented by archives which locate their contents by name,
+// rather than by listing (e.g. MPQ archives without list files).
+type locator interface {
+	fs.FS
+	// Exists reports whether the given file exists within the archive.
+	Exists(name string) bool
+}
+
+// New returns a new asset resolver without archive layers.
+func New() *Resolver {
+	return &Resolver{}
+}
+
+// NewFromDir returns a new asset resolver with a layer for each known MPQ
+// archive extracted into a subdirectory of the given directory (e.g.
+// "_assets_/diabdat" and "_assets_/hellfire").
+func NewFromDir(assetDir string) (*Resolver, error) {
+	r := New()
+	for _, name := range Archives {
+		dir := filepath.Join(assetDir, name)
+		if !osutil.Exists(dir) {
+			continue
+		}
+		if err := r.AddDir(name, dir); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	if len(r.layers) == 0 {
+		return nil, errors.Errorf("unable to locate extracted MPQ archive (e.g. %q or %q) in %q", "diabdat", "spawn", assetDir)
+	}
+	return r, nil
+}
+
+// NewFromMPQ returns a new asset resolver with a layer for each of the given MPQ
+// archives (e.g. "diabdat.mpq" and "hellfire.mpq"), which are read directly
+// without extraction. The archive name is given by the base name of the MPQ
+// file, and determines the precedence of the layer.
+func NewFromMPQ(mpqPaths []string) (*Resolver, error) {
+	archiveName := func(mpqPath string) string {
+		return strings.ToLower(pathutil.TrimExt(filepath.Base(mpqPath)))
+	}
+	// precedence returns the precedence of the given archive; unknown archives
+	// take precedence over known archives.
+	precedence := func(name string) int {
+		for i, archive := range Archives {
+			if archive == name {
+				return i
+			}
+		}
+		return len(Archives)
+	}
+	mpqPaths = append([]string(nil), mpqPaths...)
+	sort.SliceStable(mpqPaths, func(i, j int) bool {
+		return precedence(archiveName(mpqPaths[i])) < precedence(archiveName(mpqPaths[j]))
+	})
+	r := New()
+	for _, mpqPath := range mpqPaths {
+		if err := r.AddMPQ(archiveName(mpqPath), mpqPath); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return r, nil
+}
+
+// Open returns a new asset resolver for the MPQ archives of the given
+// comma-separated list (e.g. "diabdat.mpq,hellfire.mpq") if non-empty, and for
+// the MPQ archives extracted into subdirectories of assetDir otherwise.
+func Open(assetDir, mpqList string) (*Resolver, error) {
+	if len(mpqList) > 0 {
+		return NewFromMPQ(strings.Split(mpqList, ","))
+	}
+	return NewFromDir(assetDir)
+}
+
+// AddMPQ adds a layer for the given MPQ archive, which is read directly without
+// extraction. The layer takes precedence over previously added layers.
+func (r *Resolver) AddMPQ(name, mpqPath string) error {
+	archive, err := mpq.Open(mpqPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return r.AddFS(name, archive)
+}
+
+// AddDir adds a layer for the MPQ archive extracted into the given directory.
+// The layer takes precedence over previously added layers.
+func (r *Resolver) AddDir(name, dir string) error {
+	l, err := newLayer(name, os.DirFS(dir))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	l.dir = dir
+	r.layers = append([]*layer{l}, r.layers...)
+	return nil
+}
+
+// AddFS adds a layer for the MPQ archive with the given contents. The layer
+// takes precedence over previously added layers.
+func (r *Resolver) AddFS(name string, fsys fs.FS) error {
+	l, err := newLayer(name, fsys)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	r.layers = append([]*layer{l}, r.layers...)
+	return nil
+}
+
+// Has reports whether the given MPQ archive is part of the layers of the
+// resolver. The shareware spawn.mpq stands in for diabdat.mpq.
+func (r *Resolver) Has(archive string) bool {
+	for _, l := range r.layers {
+		if l.name == archive || (archive == "diabdat" && l.name == "spawn") {
+			return true
+		}
+	}
+	return false
+}
+
+// Spawn reports whether the Diablo 1 game assets are provided by the shareware
+// spawn.mpq (rather than diabdat.mpq), containing the assets of Tristram and the
+// Cathedral only.
+func (r *Resolver) Spawn() bool {
+	spawn := false
+	for _, l := range r.layers {
+		switch l.name {
+		case "diabdat":
+			return false
+		case "spawn":
+			spawn = true
+		}
+	}
+	return spawn
+}
+
+// Open opens the given asset of the layer with highest precedence containing
+// the asset. Open implements fs.FS.
+func (r *Resolver) Open(name string) (fs.File, error) {
+	l, relPath, ok := r.lookup(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return l.fsys.Open(relPath)
+}
+
+// ReadFile reads the contents of the given asset.
+func (r *Resolver) ReadFile(name string) ([]byte, error) {
+	l, relPath, ok := r.lookup(name)
+	if !ok {
+		return nil, errors.WithStack(&fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist})
+	}
+	// Read from the layer, as fs.ReadFile of the resolver would recurse into
+	// ReadFile.
+	buf, err := fs.ReadFile(l.fsys, relPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf, nil
+}
+
+// Exists reports whether the given asset exists in any of the layers.
+func (r *Resolver) Exists(name string) bool {
+	_, _, ok := r.lookup(name)
+	return ok
+}
+
+// Archive returns the name of the MPQ archive providing the given asset.
+func (r *Resolver) Archive(name string) (string, error) {
+	l, _, ok := r.lookup(name)
+	if !ok {
+		return "", errors.Errorf("unable to locate asset %q", name)
+	}
+	return l.name, nil
+}
+
+// LocalPath returns the file system path of the given asset. Assets of MPQ
+// archives read directly without extraction are extracted into cacheDir.
+func (r *Resolver) LocalPath(name, cacheDir string) (string, error) {
+	l, relPath, ok := r.lookup(name)
+	if !ok {
+		return "", errors.Errorf("unable to locate asset %q", name)
+	}
+	if len(l.dir) > 0 {
+		return filepath.Join(l.dir, filepath.FromSlash(relPath)), nil
+	}
+	dstPath := filepath.Join(cacheDir, filepath.FromSlash(normalize(name)))
+	if osutil.Exists(dstPath) {
+		return dstPath, nil
+	}
+	buf, err := fs.ReadFile(l.fsys, relPath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err := ioutil.WriteFile(dstPath, buf, 0644); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return dstPath, nil
+}
+
+// Path returns the file system path of the given asset, within the directory
+// of the extracted MPQ archive providing the asset.
+func (r *Resolver) Path(name string) (string, error) {
+	l, relPath, ok := r.lookup(name)
+	if !ok {
+		return "", errors.Errorf("unable to locate asset %q", name)
+	}
+	if len(l.dir) == 0 {
+		return "", errors.Errorf("unable to locate file system path of asset %q; MPQ archive %q not extracted", name, l.name)
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(relPath)), nil
+}
+
+// lookup returns the layer with highest precedence containing the given asset,
+// and the path of the asset within the layer.
+func (r *Resolver) lookup(name string) (*layer, string, bool) {
+	key := normalize(name)
+	for _, l := range r.layers {
+		if l.index == nil {
+			if l.fsys.(locator).Exists(key) {
+				return l, key, true
+			}
+			continue
+		}
+		if relPath, ok := l.index[key]; ok {
+			return l, relPath, true
+		}
+	}
+	return nil, "", false
+}
+
+// newLayer returns a new archive layer of the given contents.
+func newLayer(name string, fsys fs.FS) (*layer, error) {
+	l := &layer{
+		name: name,
+		fsys: fsys,
+	}
+	if _, ok := fsys.(locator); ok {
+		// Contents located by name; no need to index.
+		return l, nil
+	}
+	l.index = make(map[string]string)
+	walk := func(relPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		l.index[normalize(relPath)] = relPath
+		return nil
+	}
+	if err := fs.WalkDir(fsys, ".", walk); err != nil {
+		return nil, errors.Wrapf(err, "unable to index contents of MPQ archive %q", name)
+	}
+	return l, nil
+}
+
+// normalize returns the normalized version of the given asset path; lower case
+// with forward slashes as path separators.
+func normalize(name string) string {
+	name = strings.Replace(name, `\`, "/", -1)
+	name = strings.ToLower(name)
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
@@ -0,0 +1,168 @@
+package asset_test
+
+import (
+	"bytes"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/sanctuary/ember/asset"
+)
+
+// mpqPath specifies the path to the synthetic MPQ archive of the mpq package.
+var mpqPath = filepath.Join("..", "mpq", "testdata", "diabdat.mpq")
+
+func TestNewFromMPQ(t *testing.T) {
+	r, err := asset.NewFromMPQ([]string{mpqPath})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !r.Has("diabdat") || r.Has("hellfire") {
+		t.Errorf("expected diabdat layer only")
+	}
+	if r.Spawn() {
+		t.Errorf("expected Diablo 1 game assets of diabdat.mpq, got spawn.mpq")
+	}
+	golden := []struct {
+		name string
+		want []byte
+	}{
+		{name: "levels/l1data/l1.sol", want: sol()},
+		{name: `Levels\L1Data\L1.SOL`, want: sol()},
+		{name: "data/implode.txt", want: []byte("AIAIAIAIAIAIA")},
+		{name: "DATA/ZLIB.TXT", want: bytes.Repeat([]byte("The Butcher. "), 60)},
+	}
+	for _, g := range golden {
+		got, err := r.ReadFile(g.name)
+		if err != nil {
+			t.Errorf("%q: %+v", g.name, err)
+			continue
+		}
+		if !bytes.Equal(got, g.want) {
+			t.Errorf("%q: contents mismatch; expected %d bytes, got %d bytes", g.name, len(g.want), len(got))
+		}
+		archive, err := r.Archive(g.name)
+		if err != nil {
+			t.Errorf("%q: %+v", g.name, err)
+			continue
+		}
+		if archive != "diabdat" {
+			t.Errorf("%q: expected archive %q, got %q", g.name, "diabdat", archive)
+		}
+	}
+	if _, err := r.ReadFile("levels/l1data/l1.min"); err == nil {
+		t.Errorf("%q: expected error, got nil", "levels/l1data/l1.min")
+	}
+	// Assets of MPQ archives read directly have no file system path, but are
+	// extracted into the cache directory.
+	if _, err := r.Path("levels/l1data/l1.sol"); err == nil {
+		t.Errorf("%q: expected error for asset of MPQ archive not extracted, got nil", "levels/l1data/l1.sol")
+	}
+	cacheDir := t.TempDir()
+	localPath, err := r.LocalPath(`Levels\L1Data\L1.SOL`, cacheDir)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if want := filepath.Join(cacheDir, "levels", "l1data", "l1.sol"); localPath != want {
+		t.Errorf("expected local path %q, got %q", want, localPath)
+	}
+	buf, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(buf, sol()) {
+		t.Errorf("%q: contents mismatch of extracted asset", localPath)
+	}
+}
+
+func TestLayers(t *testing.T) {
+	r, err := asset.NewFromMPQ([]string{mpqPath})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	hellfire := fstest.MapFS{
+		"Data/Zlib.txt":        {Data: []byte("hellfire")},
+		"Levels/L5Data/L5.SOL": {Data: []byte{1, 2, 3}},
+	}
+	if err := r.AddFS("hellfire", hellfire); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	golden := []struct {
+		name    string
+		want    []byte
+		archive string
+	}{
+		// Assets of later layers override assets of earlier layers.
+		{name: "data/zlib.txt", want: []byte("hellfire"), archive: "hellfire"},
+		{name: "levels/l5data/l5.sol", want: []byte{1, 2, 3}, archive: "hellfire"},
+		{name: "data/implode.txt", want: []byte("AIAIAIAIAIAIA"), archive: "diabdat"},
+	}
+	for _, g := range golden {
+		got, err := r.ReadFile(g.name)
+		if err != nil {
+			t.Errorf("%q: %+v", g.name, err)
+			continue
+		}
+		if !bytes.Equal(got, g.want) {
+			t.Errorf("%q: expected %q, got %q", g.name, g.want, got)
+		}
+		archive, err := r.Archive(g.name)
+		if err != nil {
+			t.Errorf("%q: %+v", g.name, err)
+			continue
+		}
+		if archive != g.archive {
+			t.Errorf("%q: expected archive %q, got %q", g.name, g.archive, archive)
+		}
+	}
+	// fs.FS interface.
+	got, err := fs.ReadFile(r, "data/zlib.txt")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(got) != "hellfire" {
+		t.Errorf("%q: expected %q, got %q", "data/zlib.txt", "hellfire", got)
+	}
+}
+
+func TestNewFromDir(t *testing.T) {
+	assetDir := t.TempDir()
+	solPath := filepath.Join(assetDir, "spawn", "Levels", "L1Data", "L1.SOL")
+	if err := os.MkdirAll(filepath.Dir(solPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(solPath, sol(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := asset.Open(assetDir, "")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// The shareware spawn.mpq stands in for diabdat.mpq.
+	if !r.Spawn() || !r.Has("diabdat") {
+		t.Errorf("expected shareware game assets of spawn.mpq")
+	}
+	p, err := r.Path("levels/l1data/l1.sol")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if p != solPath {
+		t.Errorf("expected path %q, got %q", solPath, p)
+	}
+	if _, err := asset.NewFromDir(t.TempDir()); err == nil {
+		t.Errorf("expected error for directory without extracted MPQ archives, got nil")
+	}
+}
+
+// sol returns the contents of levels/l1data/l1.sol of the synthetic MPQ
+// archive.
+func sol() []byte {
+	buf := make([]byte, 1000)
+	for i := range buf {
+		buf[i] = byte(i * 7)
+	}
+	return buf
+}
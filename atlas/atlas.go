@@ -0,0 +1,173 @@
+// Package atlas packs images into texture atlases.
+//
+// Images are packed into shelves of one or more pages, each page being at most
+// maxSize x maxSize pixels. Images are organized into groups (e.g. a tile and
+// its animation frames), and the images of a group are always packed onto the
+// same page. The packing is deterministic; the same sizes always result in the
+// same layout.
+package atlas
+
+import (
+	"image"
+	"image/draw"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// A Layout specifies the location of packed images within the pages of a
+// texture atlas.
+type Layout struct {
+	// Size of each page.
+	Pages []image.Point
+	// Location of each image, indexed by group and image index.
+	Rects [][]Rect
+}
+
+// A Rect specifies the location of an image within a texture atlas.
+type Rect struct {
+	// Page index.
+	Page int
+	// Location of the image within the page.
+	image.Rectangle
+}
+
+// Pack packs images of the given sizes, organized into groups, into pages of at
+// most maxSize x maxSize pixels.
+func Pack(groups [][]image.Point, maxSize int) (*Layout, error) {
+	// Pack tall groups first, to reduce the space wasted by shelves.
+	order := make([]int, len(groups))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return groupHeight(groups[order[i]]) > groupHeight(groups[order[j]])
+	})
+	layout := &Layout{
+		Rects: make([][]Rect, len(groups)),
+	}
+	var pages []*page
+	for _, groupIndex := range order {
+		sizes := groups[groupIndex]
+		if len(pages) > 0 {
+			if rects, ok := pages[len(pages)-1].placeGroup(sizes, maxSize); ok {
+				layout.Rects[groupIndex] = withPage(rects, len(pages)-1)
+				continue
+			}
+		}
+		// Start new page.
+		p := &page{}
+		rects, ok := p.placeGroup(sizes, maxSize)
+		if !ok {
+			return nil, errors.Errorf("unable to pack group %d (%d images) into page of %dx%d pixels", groupIndex, len(sizes), maxSize, maxSize)
+		}
+		pages = append(pages, p)
+		layout.Rects[groupIndex] = withPage(rects, len(pages)-1)
+	}
+	for _, p := range pages {
+		layout.Pages = append(layout.Pages, image.Pt(p.width, p.height))
+	}
+	return layout, nil
+}
+
+// Draw draws the given images, organized into groups, onto the pages of the
+// texture atlas. The images are located as specified by the layout.
+func (layout *Layout) Draw(groups [][]image.Image) ([]*image.NRGBA, error) {
+	if len(groups) != len(layout.Rects) {
+		return nil, errors.Errorf("mismatch between number of groups in layout and images; expected %d, got %d", len(layout.Rects), len(groups))
+	}
+	pages := make([]*image.NRGBA, len(layout.Pages))
+	for i, size := range layout.Pages {
+		pages[i] = image.NewNRGBA(image.Rectangle{Max: size})
+	}
+	for groupIndex, imgs := range groups {
+		rects := layout.Rects[groupIndex]
+		if len(imgs) != len(rects) {
+			return nil, errors.Errorf("mismatch between number of images of group %d in layout and images; expected %d, got %d", groupIndex, len(rects), len(imgs))
+		}
+		for i, img := range imgs {
+			rect := rects[i]
+			bounds := img.Bounds()
+			if bounds.Size() != rect.Size() {
+				return nil, errors.Errorf("mismatch between size of image %d of group %d in layout and image; expected %v, got %v", i, groupIndex, rect.Size(), bounds.Size())
+			}
+			draw.Draw(pages[rect.Page], rect.Rectangle, img, bounds.Min, draw.Src)
+		}
+	}
+	return pages, nil
+}
+
+// page is a page of a texture atlas, which is being packed.
+type page struct {
+	// Location of the next image on the current shelf.
+	x, y int
+	// Height of the current shelf.
+	shelfHeight int
+	// Dimensions of the page.
+	width, height int
+}
+
+// placeGroup places images of the given sizes onto the page. The page is left
+// unmodified if unable to place all images of the group.
+func (p *page) placeGroup(sizes []image.Point, maxSize int) ([]image.Rectangle, bool) {
+	orig := *p
+	var rects []image.Rectangle
+	for _, size := range sizes {
+		rect, ok := p.place(size, maxSize)
+		if !ok {
+			*p = orig
+			return nil, false
+		}
+		rects = append(rects, rect)
+	}
+	return rects, true
+}
+
+// place places an image of the given size onto the page.
+func (p *page) place(size image.Point, maxSize int) (image.Rectangle, bool) {
+	if size.X > maxSize || size.Y > maxSize {
+		return image.Rectangle{}, false
+	}
+	if p.x+size.X > maxSize {
+		// Start new shelf.
+		p.x = 0
+		p.y += p.shelfHeight
+		p.shelfHeight = 0
+	}
+	if p.y+size.Y > maxSize {
+		return image.Rectangle{}, false
+	}
+	min := image.Pt(p.x, p.y)
+	rect := image.Rectangle{Min: min, Max: min.Add(size)}
+	p.x += size.X
+	if size.Y > p.shelfHeight {
+		p.shelfHeight = size.Y
+	}
+	if rect.Max.X > p.width {
+		p.width = rect.Max.X
+	}
+	if rect.Max.Y > p.height {
+		p.height = rect.Max.Y
+	}
+	return rect, true
+}
+
+// groupHeight returns the height of the tallest image of the given group.
+func groupHeight(sizes []image.Point) int {
+	height := 0
+	for _, size := range sizes {
+		if size.Y > height {
+			height = size.Y
+		}
+	}
+	return height
+}
+
+// withPage returns the given locations, as located on the specified page.
+func withPage(rects []image.Rectangle, pageIndex int) []Rect {
+	var rs []Rect
+	for _, rect := range rects {
+		rs = append(rs, Rect{Page: pageIndex, Rectangle: rect})
+	}
+	return rs
+}
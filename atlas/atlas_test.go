@@ -0,0 +1,212 @@
+package atlas_test
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/sanctuary/ember/atlas"
+)
+
+func TestPack(t *testing.T) {
+	golden := []struct {
+		groups  [][]image.Point
+		maxSize int
+		want    *atlas.Layout
+	}{
+		// Tall groups are packed first; images are placed left to right onto
+		// shelves, starting a new shelf when the current is full.
+		{
+			groups: [][]image.Point{
+				{image.Pt(32, 20)},
+				{image.Pt(32, 40)},
+				{image.Pt(16, 10), image.Pt(16, 10)},
+			},
+			maxSize: 64,
+			want: &atlas.Layout{
+				Pages: []image.Point{image.Pt(64, 50)},
+				Rects: [][]atlas.Rect{
+					{{Page: 0, Rectangle: image.Rect(32, 0, 64, 20)}},
+					{{Page: 0, Rectangle: image.Rect(0, 0, 32, 40)}},
+					{{Page: 0, Rectangle: image.Rect(0, 40, 16, 50)}, {Page: 0, Rectangle: image.Rect(16, 40, 32, 50)}},
+				},
+			},
+		},
+		// Groups are never split across pages; a group not fitting onto the
+		// current page starts a new page.
+		{
+			groups: [][]image.Point{
+				{image.Pt(64, 40)},
+				{image.Pt(64, 20), image.Pt(64, 20)},
+			},
+			maxSize: 64,
+			want: &atlas.Layout{
+				Pages: []image.Point{image.Pt(64, 40), image.Pt(64, 40)},
+				Rects: [][]atlas.Rect{
+					{{Page: 0, Rectangle: image.Rect(0, 0, 64, 40)}},
+					{{Page: 1, Rectangle: image.Rect(0, 0, 64, 20)}, {Page: 1, Rectangle: image.Rect(0, 20, 64, 40)}},
+				},
+			},
+		},
+		// Groups of equal height keep their relative order.
+		{
+			groups: [][]image.Point{
+				{image.Pt(8, 8)},
+				{image.Pt(8, 8)},
+				{image.Pt(8, 8)},
+			},
+			maxSize: 16,
+			want: &atlas.Layout{
+				Pages: []image.Point{image.Pt(16, 16)},
+				Rects: [][]atlas.Rect{
+					{{Page: 0, Rectangle: image.Rect(0, 0, 8, 8)}},
+					{{Page: 0, Rectangle: image.Rect(8, 0, 16, 8)}},
+					{{Page: 0, Rectangle: image.Rect(0, 8, 8, 16)}},
+				},
+			},
+		},
+	}
+	for i, g := range golden {
+		got, err := atlas.Pack(g.groups, g.maxSize)
+		if err != nil {
+			t.Errorf("i=%d: %+v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, g.want) {
+			t.Errorf("i=%d: layout mismatch; expected %+v, got %+v", i, g.want, got)
+		}
+	}
+}
+
+func TestPackTooLarge(t *testing.T) {
+	groups := [][]image.Point{
+		{image.Pt(16, 16)},
+		{image.Pt(16, 16), image.Pt(65, 16)},
+	}
+	if _, err := atlas.Pack(groups, 64); err == nil {
+		t.Errorf("expected error for image wider than page, got nil")
+	}
+}
+
+func TestPackDeterministic(t *testing.T) {
+	// Tiles of varying height, every tenth tile with animation frames.
+	var groups [][]image.Point
+	for i := 0; i < 200; i++ {
+		size := image.Pt(64, 10+(i*37)%150)
+		group := []image.Point{size}
+		if i%10 == 0 {
+			for frame := 1; frame < 8; frame++ {
+				group = append(group, size)
+			}
+		}
+		groups = append(groups, group)
+	}
+	const maxSize = 512
+	want, err := atlas.Pack(groups, maxSize)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for i := 0; i < 3; i++ {
+		got, err := atlas.Pack(groups, maxSize)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("layout mismatch between runs")
+		}
+	}
+	// Check that images are located within their page, without overlap, and
+	// that the images of each group share a page.
+	var all []atlas.Rect
+	for groupIndex, rects := range want.Rects {
+		if len(rects) != len(groups[groupIndex]) {
+			t.Fatalf("group %d: expected %d images, got %d", groupIndex, len(groups[groupIndex]), len(rects))
+		}
+		for i, rect := range rects {
+			if rect.Page != rects[0].Page {
+				t.Errorf("group %d: image %d on page %d, expected page %d", groupIndex, i, rect.Page, rects[0].Page)
+			}
+			if rect.Size() != groups[groupIndex][i] {
+				t.Errorf("group %d: image %d of size %v, expected %v", groupIndex, i, rect.Size(), groups[groupIndex][i])
+			}
+			page := image.Rectangle{Max: want.Pages[rect.Page]}
+			if !rect.In(page) {
+				t.Errorf("group %d: image %d at %v outside of page %v", groupIndex, i, rect.Rectangle, page)
+			}
+			for _, other := range all {
+				if other.Page == rect.Page && other.Overlaps(rect.Rectangle) {
+					t.Errorf("group %d: image %d at %v overlaps %v", groupIndex, i, rect.Rectangle, other.Rectangle)
+				}
+			}
+			all = append(all, rect)
+		}
+	}
+	for i, size := range want.Pages {
+		if size.X > maxSize || size.Y > maxSize {
+			t.Errorf("page %d of size %v exceeds %dx%d", i, size, maxSize, maxSize)
+		}
+	}
+}
+
+func TestDraw(t *testing.T) {
+	groups := [][]image.Point{
+		{image.Pt(2, 2)},
+		{image.Pt(2, 1)},
+	}
+	layout, err := atlas.Pack(groups, 4)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	red := color.NRGBA{R: 0xFF, A: 0xFF}
+	blue := color.NRGBA{B: 0xFF, A: 0xFF}
+	// Images with bounds not starting at the origin.
+	fill := func(rect image.Rectangle, c color.NRGBA) image.Image {
+		img := image.NewNRGBA(rect)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				img.SetNRGBA(x, y, c)
+			}
+		}
+		return img
+	}
+	imgs := [][]image.Image{
+		{fill(image.Rect(3, 3, 5, 5), red)},
+		{fill(image.Rect(1, 0, 3, 1), blue)},
+	}
+	pages, err := layout.Draw(imgs)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(pages) != 1 {
+		t.Fatalf("expected 1 page, got %d", len(pages))
+	}
+	page := pages[0]
+	if want := image.Rect(0, 0, 4, 2); page.Bounds() != want {
+		t.Fatalf("expected page bounds %v, got %v", want, page.Bounds())
+	}
+	golden := []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		{x: 0, y: 0, want: red},
+		{x: 1, y: 1, want: red},
+		{x: 2, y: 0, want: blue},
+		{x: 3, y: 0, want: blue},
+		// Unused space is transparent.
+		{x: 2, y: 1, want: color.NRGBA{}},
+	}
+	for _, g := range golden {
+		if got := page.NRGBAAt(g.x, g.y); got != g.want {
+			t.Errorf("pixel (%d, %d); expected %v, got %v", g.x, g.y, g.want, got)
+		}
+	}
+	// Mismatch between layout and images.
+	if _, err := layout.Draw(imgs[:1]); err == nil {
+		t.Errorf("expected error for mismatched number of groups, got nil")
+	}
+	imgs[1][0] = fill(image.Rect(0, 0, 1, 1), blue)
+	if _, err := layout.Draw(imgs); err == nil {
+		t.Errorf("expected error for mismatched image size, got nil")
+	}
+}
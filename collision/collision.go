@@ -0,0 +1,77 @@
+// Package collision translates the solid properties of Diablo 1 dungeon pieces
+// (i.e. miniture tiles) into FLARE collision values.
+package collision
+
+import "github.com/pkg/errors"
+
+// FLARE collision values.
+const (
+	BLOCKS_NONE            = 0
+	BLOCKS_ALL             = 1 // block all
+	BLOCKS_MOVEMENT        = 2 // block movement
+	BLOCKS_ALL_HIDDEN      = 3 // block all (not visible on mini map)
+	BLOCKS_MOVEMENT_HIDDEN = 4 // block movement (not visible on mini map)
+)
+
+// Solid properties of dungeon pieces, as stored in <dtype>.SOL.
+const (
+	SolBlockWalk    = 0x01 // block walk
+	Sol02           = 0x02 // lighting?
+	SolBlockMissile = 0x04 // block missile
+	Sol08           = 0x08 // transparent wall
+	Sol10           = 0x10 // sw wall
+	Sol20           = 0x20 // se wall
+	Sol40           = 0x40
+	Sol80           = 0x80 // fit shrine
+)
+
+// An Override returns the collision value of the given dungeon piece, and a
+// boolean indicating whether the dungeon piece is overridden. Overrides take
+// precedence over the solid properties of the SOL file.
+type Override func(dpieceID int) (int, bool)
+
+// overrides maps from dungeon type to collision override.
+var overrides = make(map[string]Override)
+
+// RegisterOverride registers the collision override of the given dungeon type,
+// replacing any previous override.
+func RegisterOverride(dtype string, override Override) {
+	overrides[dtype] = override
+}
+
+// UnregisterOverride removes the collision override of the given dungeon type,
+// if any.
+func UnregisterOverride(dtype string) {
+	delete(overrides, dtype)
+}
+
+// Solid returns the FLARE collision value of the given dungeon piece, based on
+// the contents of <dtype>.SOL and the overrides of the dungeon type. Cells
+// without a dungeon piece (ID 0) block all.
+func Solid(sol []byte, dtype string, dpieceID int) (int, error) {
+	if dpieceID == 0 {
+		return BLOCKS_ALL, nil
+	}
+	if override, ok := overrides[dtype]; ok {
+		if col, ok := override(dpieceID); ok {
+			return col, nil
+		}
+	}
+	if dpieceID < 1 || dpieceID > len(sol) {
+		return 0, errors.Errorf("invalid dungeon piece ID %d of dungeon type %q; expected 1 <= id <= %d", dpieceID, dtype, len(sol))
+	}
+	return FromSol(sol[dpieceID-1]), nil
+}
+
+// FromSol returns the FLARE collision value of the given solid properties.
+func FromSol(col byte) int {
+	switch {
+	// prioritize block movement over block all.
+	case col&SolBlockWalk != 0:
+		return BLOCKS_ALL
+	case col&SolBlockMissile != 0:
+		return BLOCKS_MOVEMENT
+	default:
+		return BLOCKS_NONE
+	}
+}
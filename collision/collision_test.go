@@ -0,0 +1,142 @@
+package collision_test
+
+import (
+	"testing"
+
+	"github.com/sanctuary/ember/collision"
+	"github.com/sanctuary/ember/dtype"
+)
+
+func TestFromSol(t *testing.T) {
+	golden := []struct {
+		sol  byte
+		want int
+	}{
+		{sol: 0x00, want: collision.BLOCKS_NONE},
+		{sol: collision.SolBlockWalk, want: collision.BLOCKS_ALL},
+		{sol: collision.SolBlockMissile, want: collision.BLOCKS_MOVEMENT},
+		// Blocked walk takes precedence over blocked missiles.
+		{sol: collision.SolBlockWalk | collision.SolBlockMissile, want: collision.BLOCKS_ALL},
+		{sol: collision.SolBlockWalk | collision.Sol02 | collision.SolBlockMissile, want: collision.BLOCKS_ALL},
+		// Flags other than blocked walk and missiles do not affect collision.
+		{sol: collision.Sol02, want: collision.BLOCKS_NONE},
+		{sol: collision.Sol08 | collision.Sol10 | collision.Sol20, want: collision.BLOCKS_NONE},
+		{sol: collision.Sol40 | collision.Sol80, want: collision.BLOCKS_NONE},
+		{sol: collision.Sol08 | collision.SolBlockMissile, want: collision.BLOCKS_MOVEMENT},
+		{sol: 0xFF, want: collision.BLOCKS_ALL},
+	}
+	for _, g := range golden {
+		got := collision.FromSol(g.sol)
+		if got != g.want {
+			t.Errorf("solid properties 0x%02X; expected %d, got %d", g.sol, g.want, got)
+		}
+	}
+}
+
+func TestSolid(t *testing.T) {
+	// Dungeon pieces 1-4 of a synthetic dungeon type; dungeon piece 3 is
+	// overridden.
+	sol := []byte{0x00, collision.SolBlockWalk, collision.SolBlockMissile, collision.SolBlockWalk}
+	collision.RegisterOverride("test", func(dpieceID int) (int, bool) {
+		if dpieceID == 3 {
+			return collision.BLOCKS_ALL_HIDDEN, true
+		}
+		return 0, false
+	})
+	golden := []struct {
+		dtype    string
+		dpieceID int
+		want     int
+		wantErr  bool
+	}{
+		// Cells without a dungeon piece.
+		{dtype: "test", dpieceID: 0, want: collision.BLOCKS_ALL},
+		{dtype: "test", dpieceID: 1, want: collision.BLOCKS_NONE},
+		{dtype: "test", dpieceID: 2, want: collision.BLOCKS_ALL},
+		// Override takes precedence over SOL.
+		{dtype: "test", dpieceID: 3, want: collision.BLOCKS_ALL_HIDDEN},
+		{dtype: "test", dpieceID: 4, want: collision.BLOCKS_ALL},
+		// Dungeon type without override.
+		{dtype: "other", dpieceID: 3, want: collision.BLOCKS_MOVEMENT},
+		// Dungeon piece IDs out of range.
+		{dtype: "test", dpieceID: 5, wantErr: true},
+		{dtype: "test", dpieceID: -1, wantErr: true},
+	}
+	for _, g := range golden {
+		got, err := collision.Solid(sol, g.dtype, g.dpieceID)
+		if g.wantErr {
+			if err == nil {
+				t.Errorf("dungeon piece %d of %q; expected error, got nil", g.dpieceID, g.dtype)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("dungeon piece %d of %q; unexpected error: %v", g.dpieceID, g.dtype, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("dungeon piece %d of %q; expected %d, got %d", g.dpieceID, g.dtype, g.want, got)
+		}
+	}
+}
+
+func TestSolidDoors(t *testing.T) {
+	dt, err := dtype.Get("l1")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// All dungeon pieces block walk; doors are overridden by the dungeon type.
+	sol := make([]byte, 500)
+	for i := range sol {
+		sol[i] = collision.SolBlockWalk
+	}
+	golden := []struct {
+		dpieceID int
+		want     int
+	}{
+		{dpieceID: 44, want: collision.BLOCKS_NONE},
+		{dpieceID: 46, want: collision.BLOCKS_NONE},
+		{dpieceID: 214, want: collision.BLOCKS_NONE},
+		{dpieceID: 408, want: collision.BLOCKS_NONE},
+		// Not doors.
+		{dpieceID: 45, want: collision.BLOCKS_ALL},
+		{dpieceID: 1, want: collision.BLOCKS_ALL},
+	}
+	for _, g := range golden {
+		if g.want == collision.BLOCKS_NONE && !dt.IsDoor(g.dpieceID) {
+			t.Errorf("dungeon piece %d of %q; expected door", g.dpieceID, dt.Name)
+		}
+		got, err := collision.Solid(sol, dt.Name, g.dpieceID)
+		if err != nil {
+			t.Errorf("dungeon piece %d of %q; unexpected error: %v", g.dpieceID, dt.Name, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("dungeon piece %d of %q; expected %d, got %d", g.dpieceID, dt.Name, g.want, got)
+		}
+	}
+}
+
+func TestSolidReregister(t *testing.T) {
+	// Dungeon piece 1 blocks walk, and is a door of the first registration of
+	// the dungeon type.
+	sol := []byte{collision.SolBlockWalk}
+	dtype.Register(&dtype.DungeonType{Name: "test_reregister", Doors: []int{1}})
+	got, err := collision.Solid(sol, "test_reregister", 1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if want := collision.BLOCKS_NONE; got != want {
+		t.Errorf("door of %q; expected %d, got %d", "test_reregister", want, got)
+	}
+	// The door override is removed when the dungeon type is registered again
+	// without doors.
+	dtype.Register(&dtype.DungeonType{Name: "test_reregister"})
+	got, err = collision.Solid(sol, "test_reregister", 1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if want := collision.BLOCKS_ALL; got != want {
+		t.Errorf("dungeon piece %d of re-registered %q; expected %d, got %d", 1, "test_reregister", want, got)
+	}
+}
@@ -0,0 +1,254 @@
+package dtype
+
+import (
+	"fmt"
+	"strings"
+)
+
+func init() {
+	dts, err := Parse(strings.NewReader(defaultTypes[1:]))
+	if err != nil {
+		panic(fmt.Errorf("unable to parse built-in dungeon types; %+v", err))
+	}
+	for _, dt := range dts {
+		// The music of each dungeon type is given by the table of music tracks.
+		if t, ok := TrackOf(dt.Name); ok {
+			dt.Music = t.Path()
+		}
+		Register(dt)
+	}
+}
+
+// defaultTypes contains the dungeon type definitions of Diablo 1 and the
+// Hellfire expansion.
+const defaultTypes = `
+# Tristram.
+[dtype]
+name=town
+title=tristram
+data_dir=levels/towndata
+map_size=96,96
+tileset=tileset_tristram
+tile_height=256
+tiles_per_row=64
+spawn=true
+theme=,levels/towndata/town.pal
+theme=gray,levels/towndata/ltpalg.pal
+# Overhangs of roofs and trees.
+#
+# ref: CreateTown
+arch=117,9
+arch=128,8
+arch=129,6
+arch=130,7
+arch=156,12
+arch=157,10
+arch=158,11
+arch=160,14
+arch=162,13
+arch=212,16
+arch=214,15
+arch=216,18
+arch=217,17
+arch=358,2
+arch=360,1
+
+# Cathedral.
+[dtype]
+name=l1
+title=cathedral
+data_dir=levels/l1data
+map_size=112,112
+tileset=tileset_cathedral
+tile_height=160
+tiles_per_row=32
+spawn=true
+theme=theme_1,levels/l1data/l1_1.pal
+theme=theme_2,levels/l1data/l1_2.pal
+theme=theme_3,levels/l1data/l1_3.pal
+theme=theme_4,levels/l1data/l1_4.pal
+theme=theme_5,levels/l1data/l1_5.pal
+theme=gray,levels/l1data/l1palg.pal
+# Palette of each level chosen at random from l1_1.pal through l1_4.pal.
+#
+# ref: LoadRndLvlPal
+level_themes=theme_1,theme_2,theme_3,theme_4
+doors=44,46,51,56,214,393,395,408
+# Floor shadows for arches.
+#
+# ref: 46E9E2
+#
+# Arch IDs of layout 1.
+#
+#    1 = sw
+#    2 = se
+#    3 = se broken
+#    4 = sw broken 2
+#    5 = sw 2
+#    6 = sw broken
+#    7 = sw door
+#    8 = se door
+arch=11,2
+arch=12,1
+arch=71,1
+arch=211,1
+arch=249,2
+arch=253,3
+arch=255,4
+arch=259,5
+arch=267,6
+arch=321,1
+arch=325,2
+arch=331,2
+arch=341,1
+arch=344,2
+arch=418,1
+arch=421,2
+
+# Catacombs.
+[dtype]
+name=l2
+title=catacombs
+data_dir=levels/l2data
+map_size=112,112
+tileset=tileset_catacombs
+tile_height=160
+tiles_per_row=32
+theme=theme_1,levels/l2data/l2_1.pal
+theme=theme_2,levels/l2data/l2_2.pal
+theme=theme_3,levels/l2data/l2_3.pal
+theme=theme_4,levels/l2data/l2_4.pal
+theme=theme_5,levels/l2data/l2_5.pal
+theme=gray,levels/l2data/l2palg.pal
+level_themes=theme_1,theme_2,theme_3,theme_4
+# Doorway arches.
+#
+# ref: DRLG_InitL2Vals
+#
+# Arches 1-4 are placed on the dungeon pieces one and two steps south-west of
+# dungeon piece 132 (arches 2 and 1), and south-east of dungeon pieces 135 and
+# 139 (arches 3 and 4).
+neighbour_arch=132,0,1,2
+neighbour_arch=132,0,2,1
+neighbour_arch=135,1,0,3
+neighbour_arch=135,2,0,4
+neighbour_arch=139,1,0,3
+neighbour_arch=139,2,0,4
+arch=13,5
+arch=17,6
+arch=178,5
+arch=541,5
+arch=542,6
+arch=551,5
+arch=553,6
+
+# Caves.
+[dtype]
+name=l3
+title=caves
+data_dir=levels/l3data
+map_size=112,112
+tileset=tileset_caves
+tile_height=160
+tiles_per_row=32
+theme=theme_1,levels/l3data/l3_1.pal
+theme=theme_2,levels/l3data/l3_2.pal
+theme=theme_3,levels/l3data/l3_3.pal
+theme=theme_4,levels/l3data/l3_4.pal
+theme=theme_ice,levels/l3data/l3_i.pal
+theme=gray,levels/l3data/l3palg.pal
+theme=theme_foul_water,levels/l3data/l3pfoul.pal
+theme=theme_water,levels/l3data/l3pwater.pal
+level_themes=theme_1,theme_2,theme_3,theme_4
+# nothing to do; layout 3 has no arches.
+# Lava and water animated by rotating palette colours 1-31 each game tick.
+#
+# ref: palette_update_caves
+palette_cycle=1,31,50
+dark=true
+# Lava lit with a light radius of 7.
+#
+# ref: CreateL3Dungeon
+light=56-147,7
+light=150,7
+light=152,7
+light=154-161,7
+
+# Hell.
+[dtype]
+name=l4
+title=hell
+data_dir=levels/l4data
+map_size=112,112
+tileset=tileset_hell
+tile_height=256
+tiles_per_row=32
+theme=theme_1,levels/l4data/l4_1.pal
+theme=theme_2,levels/l4data/l4_2.pal
+theme=theme_3,levels/l4data/l4_3.pal
+theme=theme_4,levels/l4data/l4_4.pal
+level_themes=theme_1,theme_2,theme_3,theme_4
+# nothing to do; layout 4 has no arches.
+# Lava animated by rotating light table colours 1-31 each game tick.
+#
+# ref: lighting_color_cycling
+palette_cycle=1,31,50
+
+# Tristram (Hellfire); includes the Farmer's orchard with the Hive entrance and
+# the graveyard with the Crypt entrance.
+[dtype]
+name=hftown
+title=tristram
+archive=hellfire
+data_dir=nlevels/towndata
+data_name=town
+map_size=96,96
+tileset=tileset_tristram_hellfire
+tile_height=256
+tiles_per_row=64
+theme=,levels/towndata/town.pal
+theme=gray,levels/towndata/ltpalg.pal
+
+# Crypt (Hellfire).
+[dtype]
+name=l5
+title=crypt
+archive=hellfire
+data_dir=nlevels/l5data
+map_size=112,112
+tileset=tileset_crypt
+tile_height=160
+tiles_per_row=32
+theme=,nlevels/l5data/l5base.pal
+# Left and right doors.
+#
+# ref: AddCryptObjs
+doors=77,80
+# Door arches.
+#
+# ref: DRLG_InitL5Vals
+#
+# Arch IDs of l5s.cel.
+#
+#    1 = sw door
+#    2 = se door
+arch=77,1
+arch=80,2
+
+# Hive (Hellfire).
+[dtype]
+name=l6
+title=hive
+archive=hellfire
+data_dir=nlevels/l6data
+map_size=112,112
+tileset=tileset_hive
+tile_height=160
+tiles_per_row=32
+theme=theme_1,nlevels/l6data/l6base1.pal
+theme=theme_2,nlevels/l6data/l6base2.pal
+theme=theme_3,nlevels/l6data/l6base3.pal
+theme=theme_4,nlevels/l6data/l6base4.pal
+level_themes=theme_1,theme_2,theme_3,theme_4
+# nothing to do; the Hive has no arches.
+`
@@ -0,0 +1,347 @@
+// Package dtype provides a registry of dungeon types (e.g. town, l1, l2, l3 and
+// l4), describing the map dimensions, tileset geometry, palette themes and
+// special dungeon pieces of each dungeon type.
+//
+// The built-in dungeon types may be extended by mods, through dungeon type
+// definition files in the format described by Parse.
+package dtype
+
+import (
+	"fmt"
+	"image"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sanctuary/ember/collision"
+)
+
+// TileWidth specifies the tile width in pixels of each tile within a tileset.
+const TileWidth = 64
+
+// A DungeonType describes the properties of a dungeon type.
+type DungeonType struct {
+	// Dungeon type name (e.g. "l1").
+	Name string
+	// Map title (e.g. "cathedral").
+	Title string
+	// Name of the MPQ archive containing the level data (e.g. "diabdat" or
+	// "hellfire").
+	Archive string
+	// Directory containing the level data, relative to the root of the MPQ
+	// archive (e.g. "levels/l1data").
+	DataDir string
+	// Base name of the level data files; defaults to the dungeon type name.
+	DataName string
+	// Map width in number of cels.
+	MapWidth int
+	// Map height in number of cels.
+	MapHeight int
+	// Base name of tileset (e.g. "tileset_cathedral").
+	Tileset string
+	// Tile height in pixels of each tile within the tileset.
+	TileHeight int
+	// Number of tiles per row in tileset.
+	NTilesPerRow int
+	// Path to music track, relative to the mod directory (e.g.
+	// "music/cathedral.ogg"); as given by Tracks for built-in dungeon types.
+	Music string
+	// Palette themes of the dungeon type; the first theme is the default.
+	Themes []Theme
+	// Names of the palette themes randomly chosen between for each level of the
+	// dungeon type; empty if the default theme is always used.
+	LevelThemes []string
+	// Arch IDs of dungeon pieces, mapping from dungeon piece ID to the frame
+	// number of <dtype>S.CEL drawn on top of the dungeon piece.
+	Arches map[int]int
+	// Arches placed on neighbouring dungeon pieces, mapping from dungeon piece
+	// ID to the arches placed relative to the dungeon piece.
+	NeighbourArches map[int][]NeighbourArch
+	// Door dungeon piece IDs.
+	Doors []int
+	// Palette colour cycling of the dungeon type (e.g. lava and water of the
+	// caves); nil if the palette is static.
+	PaletteCycle *PaletteCycle
+	// Specifies whether levels of the dungeon type are dark, and only lit by
+	// light sources; requires light sources.
+	Dark bool
+	// Light radii of dungeon pieces emitting light (e.g. lava), mapping from
+	// dungeon piece ID to light radius in number of cels.
+	Lights map[int]int
+	// Specifies whether the dungeon type is contained within the shareware
+	// spawn.mpq.
+	Spawn bool
+}
+
+// A NeighbourArch is an arch placed on a neighbouring dungeon piece, rather
+// than on the dungeon piece itself (e.g. the doorway arches of the Catacombs).
+type NeighbourArch struct {
+	// Offset in number of cels to the neighbouring dungeon piece; x increases
+	// towards the south-east and y towards the south-west (as dPiece[x][y] of
+	// Diablo 1).
+	Offset image.Point
+	// Arch ID.
+	ArchID int
+}
+
+// A PaletteCycle describes the palette colour cycling of a dungeon type. Each
+// frame, the colours of the cycled palette range are rotated one step, so that
+// palette index i is displayed using the colour of index i+1 (wrapping within
+// the range).
+type PaletteCycle struct {
+	// First palette index of the cycled range.
+	First int
+	// Last palette index of the cycled range (inclusive).
+	Last int
+	// Frame duration in milliseconds.
+	FrameDuration int
+}
+
+// NFrames returns the number of frames of the palette colour cycle.
+func (cycle *PaletteCycle) NFrames() int {
+	return cycle.Last - cycle.First + 1
+}
+
+// Index returns the palette index displayed by the given palette index after
+// the specified number of frames.
+func (cycle *PaletteCycle) Index(i, frame int) int {
+	if i < cycle.First || i > cycle.Last {
+		return i
+	}
+	return cycle.First + (i-cycle.First+frame)%cycle.NFrames()
+}
+
+// A Theme is a palette theme of a dungeon type.
+type Theme struct {
+	// Theme name appended to the base name of the tileset (e.g. "theme_1");
+	// empty for no suffix.
+	Name string
+	// Path to palette, relative to the root of the MPQ archive (e.g.
+	// "levels/l1data/l1_1.pal").
+	Palette string
+}
+
+// PalName returns the file name of the palette of the theme (e.g. "l1_1.pal").
+func (theme Theme) PalName() string {
+	return path.Base(theme.Palette)
+}
+
+// DataPath returns the path to the given level data file of the dungeon type,
+// relative to the root of the MPQ archive. The extension is appended to the
+// base name of the level data files, e.g. ".sol" gives "levels/l1data/l1.sol"
+// and "s.cel" gives "levels/l1data/l1s.cel".
+func (dt *DungeonType) DataPath(ext string) string {
+	name := dt.DataName
+	if len(name) == 0 {
+		name = dt.Name
+	}
+	return path.Join(dt.DataDir, name+ext)
+}
+
+// SolPath returns the path to the SOL file of the dungeon type, relative to
+// the root of the MPQ archive.
+func (dt *DungeonType) SolPath() string {
+	return dt.DataPath(".sol")
+}
+
+// DefaultTheme returns the default palette theme of the dungeon type.
+func (dt *DungeonType) DefaultTheme() Theme {
+	if len(dt.Themes) == 0 {
+		return Theme{}
+	}
+	return dt.Themes[0]
+}
+
+// Theme returns the palette theme of the given name, or the default theme if
+// the name is empty.
+func (dt *DungeonType) Theme(name string) (Theme, error) {
+	if len(name) == 0 {
+		return dt.DefaultTheme(), nil
+	}
+	var names []string
+	for _, theme := range dt.Themes {
+		if theme.Name == name {
+			return theme, nil
+		}
+		names = append(names, theme.Name)
+	}
+	return Theme{}, errors.Errorf("unable to locate theme %q of dungeon type %q; valid themes: %s", name, dt.Name, strings.Join(names, ", "))
+}
+
+// LevelTheme returns the palette theme of the level with the given seed.
+//
+// Diablo 1 picks a random palette for each level (e.g. l1_1.pal through
+// l1_4.pal) when loading the level. The palette is drawn from the level
+// random number generator after the level has been generated; since the
+// dungeon pieces of the level are all that is known here, the palette is
+// instead drawn from a freshly seeded generator. Thus the same level always
+// gets the same theme, with the same distribution of themes as Diablo 1.
+//
+// ref: LoadRndLvlPal
+func (dt *DungeonType) LevelTheme(seed int32) (Theme, error) {
+	if len(dt.LevelThemes) == 0 {
+		return dt.DefaultTheme(), nil
+	}
+	r := newRand(seed)
+	name := dt.LevelThemes[r.intn(len(dt.LevelThemes))]
+	return dt.Theme(name)
+}
+
+// TilesetName returns the name of the tileset of the given palette theme (e.g.
+// "tileset_cathedral_theme_1").
+func (dt *DungeonType) TilesetName(theme Theme) string {
+	if len(theme.Name) == 0 {
+		return dt.Tileset
+	}
+	return fmt.Sprintf("%s_%s", dt.Tileset, theme.Name)
+}
+
+// ArchID returns the arch ID of the given dungeon piece, or ArchNone if the
+// dungeon piece has no arch.
+func (dt *DungeonType) ArchID(dpieceID int) int {
+	return dt.Arches[dpieceID]
+}
+
+// ArchDPieceIDs returns the IDs of the dungeon pieces with arches, in
+// increasing order.
+func (dt *DungeonType) ArchDPieceIDs() []int {
+	var dpieceIDs []int
+	for dpieceID := range dt.Arches {
+		dpieceIDs = append(dpieceIDs, dpieceID)
+	}
+	sort.Ints(dpieceIDs)
+	return dpieceIDs
+}
+
+// LightRadius returns the light radius of the given dungeon piece, or 0 if the
+// dungeon piece emits no light.
+func (dt *DungeonType) LightRadius(dpieceID int) int {
+	return dt.Lights[dpieceID]
+}
+
+// NArches returns the number of arches of the dungeon type, as given by the
+// highest arch ID in use.
+func (dt *DungeonType) NArches() int {
+	n := 0
+	for _, archID := range dt.Arches {
+		if archID > n {
+			n = archID
+		}
+	}
+	for _, arches := range dt.NeighbourArches {
+		for _, arch := range arches {
+			if arch.ArchID > n {
+				n = arch.ArchID
+			}
+		}
+	}
+	return n
+}
+
+// PlaceArches returns the arch IDs of the given map of dungeon pieces, indexed
+// by x and y; ArchNone for cels without arches. Arches placed on neighbouring
+// dungeon pieces take precedence over the arches of the dungeon pieces
+// themselves, and are left out if located outside of the map.
+//
+// ref: DRLG_InitL2Vals
+func (dt *DungeonType) PlaceArches(dpieces [][]int) [][]int {
+	arches := make([][]int, len(dpieces))
+	for x := range dpieces {
+		arches[x] = make([]int, len(dpieces[x]))
+		for y, dpieceID := range dpieces[x] {
+			arches[x][y] = dt.ArchID(dpieceID)
+		}
+	}
+	for x := range dpieces {
+		for y, dpieceID := range dpieces[x] {
+			for _, arch := range dt.NeighbourArches[dpieceID] {
+				pt := image.Pt(x, y).Add(arch.Offset)
+				if pt.X < 0 || pt.X >= len(arches) || pt.Y < 0 || pt.Y >= len(arches[pt.X]) {
+					continue
+				}
+				arches[pt.X][pt.Y] = arch.ArchID
+			}
+		}
+	}
+	return arches
+}
+
+// IsDoor reports whether the given dungeon piece is a door.
+func (dt *DungeonType) IsDoor(dpieceID int) bool {
+	for _, id := range dt.Doors {
+		if id == dpieceID {
+			return true
+		}
+	}
+	return false
+}
+
+// ArchNone specifies that a dungeon piece has no arch.
+const ArchNone = 0
+
+// Names of MPQ archives.
+const (
+	// Diablo 1 game assets.
+	ArchiveDiabdat = "diabdat"
+	// Hellfire expansion game assets.
+	ArchiveHellfire = "hellfire"
+	// Hellfire music.
+	ArchiveHFMusic = "hfmusic"
+	// Diablo 1 shareware game assets, standing in for diabdat.
+	ArchiveSpawn = "spawn"
+)
+
+// Registered dungeon types.
+var (
+	// types maps from dungeon type name to dungeon type.
+	types = make(map[string]*DungeonType)
+	// names specifies the dungeon type names in order of registration.
+	names []string
+)
+
+// Register registers the given dungeon type, replacing any previously
+// registered dungeon type of the same name. The collision override of the doors
+// of a replaced dungeon type is removed if the new dungeon type has no doors.
+func Register(dt *DungeonType) {
+	if _, ok := types[dt.Name]; !ok {
+		names = append(names, dt.Name)
+	}
+	types[dt.Name] = dt
+	if len(dt.Doors) > 0 {
+		collision.RegisterOverride(dt.Name, doorOverride(dt))
+	} else {
+		collision.UnregisterOverride(dt.Name)
+	}
+}
+
+// Get returns the dungeon type of the given name.
+func Get(name string) (*DungeonType, error) {
+	dt, ok := types[name]
+	if !ok {
+		return nil, errors.Errorf("support for dungeon type %q not yet implemented", name)
+	}
+	return dt, nil
+}
+
+// Names returns the names of the registered dungeon types, in order of
+// registration.
+func Names() []string {
+	return append([]string(nil), names...)
+}
+
+// doorOverride returns a collision override for the doors of the given dungeon
+// type.
+func doorOverride(dt *DungeonType) collision.Override {
+	return func(dpieceID int) (int, bool) {
+		if dt.IsDoor(dpieceID) {
+			// TODO: Handle doors by replacing their tiles with open doors, and
+			// adding interactable objects (with their own collision) to display
+			// the doors.
+
+			// Skip collision for now.
+			return collision.BLOCKS_NONE, true
+		}
+		return 0, false
+	}
+}
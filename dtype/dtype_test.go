@@ -0,0 +1,200 @@
+package dtype_test
+
+import (
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sanctuary/ember/dtype"
+)
+
+func TestArchID(t *testing.T) {
+	golden := []struct {
+		dtype    string
+		dpieceID int
+		want     int
+	}{
+		// Tristram; ref: CreateTown.
+		{dtype: "town", dpieceID: 360, want: 1},
+		{dtype: "town", dpieceID: 358, want: 2},
+		{dtype: "town", dpieceID: 129, want: 6},
+		{dtype: "town", dpieceID: 130, want: 7},
+		{dtype: "town", dpieceID: 128, want: 8},
+		{dtype: "town", dpieceID: 117, want: 9},
+		{dtype: "town", dpieceID: 157, want: 10},
+		{dtype: "town", dpieceID: 158, want: 11},
+		{dtype: "town", dpieceID: 156, want: 12},
+		{dtype: "town", dpieceID: 162, want: 13},
+		{dtype: "town", dpieceID: 160, want: 14},
+		{dtype: "town", dpieceID: 214, want: 15},
+		{dtype: "town", dpieceID: 212, want: 16},
+		{dtype: "town", dpieceID: 217, want: 17},
+		{dtype: "town", dpieceID: 216, want: 18},
+		{dtype: "town", dpieceID: 1, want: dtype.ArchNone},
+		// Cathedral; ref: DRLG_InitL1Vals.
+		{dtype: "l1", dpieceID: 12, want: 1},
+		{dtype: "l1", dpieceID: 11, want: 2},
+		{dtype: "l1", dpieceID: 71, want: 1},
+		{dtype: "l1", dpieceID: 253, want: 3},
+		{dtype: "l1", dpieceID: 267, want: 6},
+		{dtype: "l1", dpieceID: 259, want: 5},
+		{dtype: "l1", dpieceID: 249, want: 2},
+		{dtype: "l1", dpieceID: 325, want: 2},
+		{dtype: "l1", dpieceID: 321, want: 1},
+		{dtype: "l1", dpieceID: 255, want: 4},
+		{dtype: "l1", dpieceID: 211, want: 1},
+		{dtype: "l1", dpieceID: 344, want: 2},
+		{dtype: "l1", dpieceID: 341, want: 1},
+		{dtype: "l1", dpieceID: 331, want: 2},
+		{dtype: "l1", dpieceID: 418, want: 1},
+		{dtype: "l1", dpieceID: 421, want: 2},
+		{dtype: "l1", dpieceID: 13, want: dtype.ArchNone},
+		// Catacombs; ref: DRLG_InitL2Vals.
+		{dtype: "l2", dpieceID: 541, want: 5},
+		{dtype: "l2", dpieceID: 178, want: 5},
+		{dtype: "l2", dpieceID: 551, want: 5},
+		{dtype: "l2", dpieceID: 13, want: 5},
+		{dtype: "l2", dpieceID: 542, want: 6},
+		{dtype: "l2", dpieceID: 553, want: 6},
+		{dtype: "l2", dpieceID: 17, want: 6},
+		// Arches 1-4 are placed on neighbouring dungeon pieces.
+		{dtype: "l2", dpieceID: 132, want: dtype.ArchNone},
+		{dtype: "l2", dpieceID: 135, want: dtype.ArchNone},
+		// Caves and Hell have no arches.
+		{dtype: "l3", dpieceID: 12, want: dtype.ArchNone},
+		{dtype: "l4", dpieceID: 12, want: dtype.ArchNone},
+		// Crypt; ref: DRLG_InitL5Vals.
+		{dtype: "l5", dpieceID: 77, want: 1},
+		{dtype: "l5", dpieceID: 80, want: 2},
+		{dtype: "l5", dpieceID: 78, want: dtype.ArchNone},
+		// The Hive has no arches.
+		{dtype: "l6", dpieceID: 77, want: dtype.ArchNone},
+	}
+	for _, g := range golden {
+		dt, err := dtype.Get(g.dtype)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		got := dt.ArchID(g.dpieceID)
+		if got != g.want {
+			t.Errorf("dungeon piece %d of %q; expected arch ID %d, got %d", g.dpieceID, g.dtype, g.want, got)
+		}
+	}
+}
+
+func TestNArches(t *testing.T) {
+	golden := []struct {
+		dtype string
+		want  int
+	}{
+		{dtype: "town", want: 18},
+		{dtype: "l1", want: 6},
+		{dtype: "l2", want: 6},
+		{dtype: "l3", want: 0},
+		{dtype: "l4", want: 0},
+		{dtype: "l5", want: 2},
+		{dtype: "l6", want: 0},
+	}
+	for _, g := range golden {
+		dt, err := dtype.Get(g.dtype)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if got := dt.NArches(); got != g.want {
+			t.Errorf("%q: expected %d arches, got %d", g.dtype, g.want, got)
+		}
+	}
+}
+
+func TestPlaceArches(t *testing.T) {
+	dt, err := dtype.Get("l2")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// Map of 5x5 dungeon pieces, indexed by x and y.
+	dpieces := make([][]int, 5)
+	for x := range dpieces {
+		dpieces[x] = make([]int, 5)
+	}
+	// Arches 2 and 1 one and two steps south-west of dungeon piece 132.
+	dpieces[1][0] = 132
+	// Arches 3 and 4 one and two steps south-east of dungeon piece 135.
+	dpieces[0][3] = 135
+	// Arches placed outside of the map are left out.
+	dpieces[4][0] = 139
+	// Arch of the dungeon piece itself.
+	dpieces[4][4] = 541
+	// Arch of the dungeon piece itself, replaced by neighbouring arch 1.
+	dpieces[1][2] = 542
+	want := [][]int{
+		{0, 0, 0, 0, 0},
+		{0, 2, 1, 3, 0},
+		{0, 0, 0, 4, 0},
+		{0, 0, 0, 0, 0},
+		{0, 0, 0, 0, 5},
+	}
+	got := dt.PlaceArches(dpieces)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	const input = `
+# Test.
+[dtype]
+name=test
+arch=11,2
+neighbour_arch=132,0,1,7
+neighbour_arch=132,0,2,1
+doors=44,46
+light=3-5,7
+`
+	dts, err := dtype.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(dts) != 1 {
+		t.Fatalf("expected 1 dungeon type, got %d", len(dts))
+	}
+	dt := dts[0]
+	if dt.Archive != dtype.ArchiveDiabdat {
+		t.Errorf("expected default archive %q, got %q", dtype.ArchiveDiabdat, dt.Archive)
+	}
+	if got := dt.ArchID(11); got != 2 {
+		t.Errorf("expected arch ID 2, got %d", got)
+	}
+	wantNeighbours := []dtype.NeighbourArch{
+		{Offset: image.Pt(0, 1), ArchID: 7},
+		{Offset: image.Pt(0, 2), ArchID: 1},
+	}
+	if got := dt.NeighbourArches[132]; !reflect.DeepEqual(got, wantNeighbours) {
+		t.Errorf("expected neighbour arches %v, got %v", wantNeighbours, got)
+	}
+	if got := dt.NArches(); got != 7 {
+		t.Errorf("expected 7 arches, got %d", got)
+	}
+	if !dt.IsDoor(46) || dt.IsDoor(45) {
+		t.Errorf("door mismatch; expected doors 44 and 46, got %v", dt.Doors)
+	}
+	for dpieceID, want := range map[int]int{2: 0, 3: 7, 5: 7, 6: 0} {
+		if got := dt.LightRadius(dpieceID); got != want {
+			t.Errorf("dungeon piece %d; expected light radius %d, got %d", dpieceID, want, got)
+		}
+	}
+	// Invalid definitions.
+	for _, input := range []string{
+		"name=test\n",
+		"[dtype]\narch=1\n",
+		"[dtype]\nneighbour_arch=132,0,1\n",
+		"[dtype]\nunknown=1\n",
+		"[dtype]\nlight=5-3,7\n",
+		"[dtype]\ntitle=test\n",
+		"[dtype]\nname=test\ndark=true\n",
+	} {
+		if _, err := dtype.Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("%q: expected error, got nil", input)
+		}
+	}
+}
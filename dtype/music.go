@@ -0,0 +1,50 @@
+package dtype
+
+import "path"
+
+// A Track is a music track of the game.
+type Track struct {
+	// Base name of the Ogg file of the track (e.g. "cathedral").
+	Name string
+	// Name of the MPQ archive containing the track (e.g. "diabdat").
+	Archive string
+	// Path to the WAV file of the track, relative to the root of the MPQ archive
+	// (e.g. "music/dlvla.wav").
+	WavPath string
+	// Names of the dungeon types playing the track (e.g. "l1"); empty if the
+	// track is not played within levels (e.g. the intro of the main menu).
+	DTypes []string
+}
+
+// Path returns the path to the Ogg file of the track, relative to the mod
+// directory (e.g. "music/cathedral.ogg").
+func (t Track) Path() string {
+	return path.Join("music", t.Name+".ogg")
+}
+
+// Tracks specifies the music tracks of Diablo 1 and the Hellfire expansion,
+// and the dungeon types playing each track; the `music` map property of each
+// dungeon type is given by this table.
+var Tracks = []Track{
+	{Name: "intro", Archive: ArchiveDiabdat, WavPath: "music/dintro.wav"},
+	{Name: "tristram", Archive: ArchiveDiabdat, WavPath: "music/dtowne.wav", DTypes: []string{"town", "hftown"}},
+	{Name: "cathedral", Archive: ArchiveDiabdat, WavPath: "music/dlvla.wav", DTypes: []string{"l1"}},
+	{Name: "catacombs", Archive: ArchiveDiabdat, WavPath: "music/dlvlb.wav", DTypes: []string{"l2"}},
+	{Name: "caves", Archive: ArchiveDiabdat, WavPath: "music/dlvlc.wav", DTypes: []string{"l3"}},
+	{Name: "hell", Archive: ArchiveDiabdat, WavPath: "music/dlvld.wav", DTypes: []string{"l4"}},
+	// Hellfire music is contained within hfmusic.mpq.
+	{Name: "hive", Archive: ArchiveHFMusic, WavPath: "music/dlvle.wav", DTypes: []string{"l6"}},
+	{Name: "crypt", Archive: ArchiveHFMusic, WavPath: "music/dlvlf.wav", DTypes: []string{"l5"}},
+}
+
+// TrackOf returns the music track played by the given dungeon type.
+func TrackOf(dtypeName string) (Track, bool) {
+	for _, t := range Tracks {
+		for _, name := range t.DTypes {
+			if name == dtypeName {
+				return t, true
+			}
+		}
+	}
+	return Track{}, false
+}
@@ -0,0 +1,254 @@
+package dtype
+
+import (
+	"bufio"
+	"image"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Load parses the given dungeon type definition file and registers the dungeon
+// types contained within.
+func Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	dts, err := Parse(f)
+	if err != nil {
+		return errors.Wrapf(err, "unable to parse %q", path)
+	}
+	for _, dt := range dts {
+		Register(dt)
+	}
+	return nil
+}
+
+// Parse parses the dungeon type definitions read from r.
+//
+// Dungeon type definitions use the key-value format of FLARE; each definition
+// starts with a [dtype] section header, and lines starting with '#' are
+// comments.
+//
+//    [dtype]
+//    name=l1
+//    title=cathedral
+//    # archive=diabdat (default) or hellfire
+//    archive=diabdat
+//    data_dir=levels/l1data
+//    # data_name=NAME (base name of level data files; defaults to name)
+//    map_size=112,112
+//    tileset=tileset_cathedral
+//    tile_height=160
+//    tiles_per_row=32
+//    music=music/cathedral.ogg
+//    # theme=NAME,PALETTE (repeatable; the first theme is the default)
+//    theme=theme_1,levels/l1data/l1_1.pal
+//    # level_themes=NAME,... (themes randomly chosen between for each level)
+//    level_themes=theme_1,theme_2,theme_3,theme_4
+//    # doors=DPIECE_ID,...
+//    doors=44,46,51,56,214,393,395,408
+//    # arch=DPIECE_ID,ARCH_ID (repeatable)
+//    arch=11,2
+//    # neighbour_arch=DPIECE_ID,DX,DY,ARCH_ID (repeatable; arch placed on the
+//    # dungeon piece at offset DX,DY; x towards south-east, y towards south-west)
+//    neighbour_arch=132,0,1,2
+//    # palette_cycle=FIRST,LAST,FRAME_DURATION_MS
+//    palette_cycle=1,31,50
+//    # dark=true or false (default); dark dungeon types require light sources
+//    dark=true
+//    # light=DPIECE_ID[-LAST_DPIECE_ID],RADIUS (repeatable)
+//    light=56-147,7
+//    # spawn=true (contained within the shareware spawn.mpq) or false (default)
+//    spawn=true
+func Parse(r io.Reader) ([]*DungeonType, error) {
+	var (
+		dts []*DungeonType
+		dt  *DungeonType
+	)
+	s := bufio.NewScanner(r)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "[dtype]" {
+			dt = &DungeonType{Arches: make(map[int]int), NeighbourArches: make(map[int][]NeighbourArch), Lights: make(map[int]int)}
+			dts = append(dts, dt)
+			continue
+		}
+		if dt == nil {
+			return nil, errors.Errorf("line %d: key-value pair %q outside of [dtype] section", lineNum, line)
+		}
+		pos := strings.Index(line, "=")
+		if pos == -1 {
+			return nil, errors.Errorf("line %d: invalid key-value pair %q", lineNum, line)
+		}
+		key, val := line[:pos], line[pos+1:]
+		if err := parseKey(dt, key, val); err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineNum)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, dt := range dts {
+		if len(dt.Name) == 0 {
+			return nil, errors.New("missing name of dungeon type")
+		}
+		if len(dt.Archive) == 0 {
+			dt.Archive = ArchiveDiabdat
+		}
+		if dt.Dark && len(dt.Lights) == 0 {
+			return nil, errors.Errorf("dark dungeon type %q without light sources", dt.Name)
+		}
+	}
+	return dts, nil
+}
+
+// parseKey parses the value of the given key into the dungeon type.
+func parseKey(dt *DungeonType, key, val string) error {
+	switch key {
+	case "name":
+		dt.Name = val
+	case "title":
+		dt.Title = val
+	case "archive":
+		dt.Archive = val
+	case "data_dir":
+		dt.DataDir = val
+	case "data_name":
+		dt.DataName = val
+	case "map_size":
+		vs, err := parseInts(val, 2)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		dt.MapWidth, dt.MapHeight = vs[0], vs[1]
+	case "tileset":
+		dt.Tileset = val
+	case "tile_height":
+		vs, err := parseInts(val, 1)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		dt.TileHeight = vs[0]
+	case "tiles_per_row":
+		vs, err := parseInts(val, 1)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		dt.NTilesPerRow = vs[0]
+	case "music":
+		dt.Music = val
+	case "theme":
+		parts := strings.Split(val, ",")
+		if len(parts) != 2 {
+			return errors.Errorf("invalid theme %q; expected NAME,PALETTE", val)
+		}
+		theme := Theme{Name: parts[0], Palette: parts[1]}
+		dt.Themes = append(dt.Themes, theme)
+	case "level_themes":
+		dt.LevelThemes = append(dt.LevelThemes, strings.Split(val, ",")...)
+	case "doors":
+		vs, err := parseInts(val, -1)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		dt.Doors = append(dt.Doors, vs...)
+	case "arch":
+		vs, err := parseInts(val, 2)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		dt.Arches[vs[0]] = vs[1]
+	case "neighbour_arch":
+		vs, err := parseInts(val, 4)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		arch := NeighbourArch{Offset: image.Pt(vs[1], vs[2]), ArchID: vs[3]}
+		dt.NeighbourArches[vs[0]] = append(dt.NeighbourArches[vs[0]], arch)
+	case "palette_cycle":
+		vs, err := parseInts(val, 3)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if vs[0] > vs[1] {
+			return errors.Errorf("invalid palette cycle range %d-%d", vs[0], vs[1])
+		}
+		dt.PaletteCycle = &PaletteCycle{First: vs[0], Last: vs[1], FrameDuration: vs[2]}
+	case "dark":
+		dark, err := strconv.ParseBool(val)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		dt.Dark = dark
+	case "light":
+		parts := strings.Split(val, ",")
+		if len(parts) != 2 {
+			return errors.Errorf("invalid light %q; expected DPIECE_ID[-LAST_DPIECE_ID],RADIUS", val)
+		}
+		first, last, err := parseRange(parts[0])
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		radius, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for dpieceID := first; dpieceID <= last; dpieceID++ {
+			dt.Lights[dpieceID] = radius
+		}
+	case "spawn":
+		spawn, err := strconv.ParseBool(val)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		dt.Spawn = spawn
+	default:
+		return errors.Errorf("unknown key %q", key)
+	}
+	return nil
+}
+
+// parseRange parses the given integer or inclusive range of integers (e.g.
+// "56-147").
+func parseRange(val string) (first, last int, err error) {
+	parts := strings.Split(val, "-")
+	if len(parts) > 2 {
+		return 0, 0, errors.Errorf("invalid range %q; expected FIRST[-LAST]", val)
+	}
+	vs, err := parseInts(strings.Join(parts, ","), len(parts))
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+	first, last = vs[0], vs[len(vs)-1]
+	if first > last {
+		return 0, 0, errors.Errorf("invalid range %d-%d", first, last)
+	}
+	return first, last, nil
+}
+
+// parseInts parses the given comma-separated list of integers. The number of
+// integers must match n, unless n is -1.
+func parseInts(val string, n int) ([]int, error) {
+	parts := strings.Split(val, ",")
+	if n != -1 && len(parts) != n {
+		return nil, errors.Errorf("invalid number of integers in %q; expected %d, got %d", val, n, len(parts))
+	}
+	var vs []int
+	for _, part := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
@@ -0,0 +1,38 @@
+package dtype
+
+// rand is the random number generator of Diablo 1; a linear congruential
+// generator.
+//
+// ref: SetRndSeed, GetRndSeed and random_
+type rand struct {
+	// Current seed.
+	seed int32
+}
+
+// newRand returns a new random number generator with the given seed.
+func newRand(seed int32) *rand {
+	return &rand{seed: seed}
+}
+
+// next returns the next value of the random number generator.
+func (r *rand) next() int32 {
+	r.seed = 0x015A4E35*r.seed + 1
+	if r.seed < 0 {
+		// Note, the absolute value of math.MinInt32 is math.MinInt32, as in
+		// Diablo 1.
+		return -r.seed
+	}
+	return r.seed
+}
+
+// intn returns a random number in [0, n).
+func (r *rand) intn(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	v := r.next()
+	if n < 0xFFFF {
+		v >>= 16
+	}
+	return int(v) % n
+}
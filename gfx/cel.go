@@ -0,0 +1,173 @@
+package gfx
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// pixel is a decoded pixel of a run-length encoded frame.
+type pixel struct {
+	// Palette index of the pixel.
+	index uint8
+	// Specifies whether the pixel is opaque.
+	opaque bool
+}
+
+// DecodeCEL decodes the frames of the given CEL image (e.g. objcurs.cel). The
+// frame width is determined from the frame header if present, and given by
+// width otherwise.
+func DecodeCEL(buf []byte, width int) ([]*Image, error) {
+	frames, err := parseFrames(buf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var imgs []*Image
+	for i, frame := range frames {
+		img, err := decodeFrame(frame, width, decodeCELRLE)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to decode CEL frame %d", i)
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs, nil
+}
+
+// DecodeCELGroups decodes the frames of the given CEL image, organized into
+// groups (e.g. one group per direction of towners/animals/cow.cel). Images
+// without groups are decoded into a single group. The frame width is determined
+// from the frame header if present, and given by width otherwise.
+func DecodeCELGroups(buf []byte, width int) ([][]*Image, error) {
+	var groups [][]*Image
+	for i, group := range parseGroups(buf) {
+		imgs, err := DecodeCEL(group, width)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to decode CEL group %d", i)
+		}
+		groups = append(groups, imgs)
+	}
+	return groups, nil
+}
+
+// decodeCELRLE decodes the given run-length encoded frame data of a CEL image.
+//
+// Each run starts with a control byte b. For b >= 0x80, the run consists of
+// 0x100-b transparent pixels. Otherwise, the run consists of the b palette
+// indices following the control byte.
+func decodeCELRLE(data []byte) ([]pixel, error) {
+	var pixels []pixel
+	for len(data) > 0 {
+		b := data[0]
+		data = data[1:]
+		if b&0x80 != 0 {
+			n := 0x100 - int(b)
+			pixels = append(pixels, make([]pixel, n)...)
+			continue
+		}
+		n := int(b)
+		if n > len(data) {
+			return nil, errors.Errorf("invalid run length %d; exceeds %d bytes of remaining frame data", n, len(data))
+		}
+		for _, index := range data[:n] {
+			pixels = append(pixels, pixel{index: index, opaque: true})
+		}
+		data = data[n:]
+	}
+	return pixels, nil
+}
+
+// decodeFrame decodes the given run-length encoded frame, using the specified
+// run-length decoder. The frame width is determined from the frame header if
+// present, and given by width otherwise.
+func decodeFrame(frame []byte, width int, decodeRLE func(data []byte) ([]pixel, error)) (*Image, error) {
+	data := frame
+	if hdrWidth, ok := frameHeaderWidth(frame, decodeRLE); ok {
+		width = hdrWidth
+		data = frame[frameHeaderSize:]
+	} else if hasFrameHeader(frame) {
+		data = frame[frameHeaderSize:]
+	}
+	if width <= 0 {
+		return nil, errors.New("unable to determine frame width; frame header missing")
+	}
+	pixels, err := decodeRLE(data)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return toImage(pixels, width), nil
+}
+
+// Size in bytes of frame headers.
+const frameHeaderSize = 10
+
+// hasFrameHeader reports whether the given frame starts with a frame header.
+//
+// The frame header consists of five 16-bit values; the size of the header
+// (10), followed by the offsets to the frame data of row 32, 64, 96 and 128
+// respectively, relative to the start of the frame (or 0 if the frame has
+// fewer rows).
+func hasFrameHeader(frame []byte) bool {
+	return len(frame) >= frameHeaderSize && binary.LittleEndian.Uint16(frame) == frameHeaderSize
+}
+
+// frameHeaderWidth returns the width of the given frame, as determined by the
+// number of pixels of the first 32 rows of the frame.
+func frameHeaderWidth(frame []byte, decodeRLE func(data []byte) ([]pixel, error)) (int, bool) {
+	if !hasFrameHeader(frame) {
+		return 0, false
+	}
+	end := int(binary.LittleEndian.Uint16(frame[2:]))
+	if end <= frameHeaderSize || end > len(frame) {
+		return 0, false
+	}
+	pixels, err := decodeRLE(frame[frameHeaderSize:end])
+	if err != nil || len(pixels)%32 != 0 {
+		return 0, false
+	}
+	return len(pixels) / 32, true
+}
+
+// toImage returns an image of the given width, based on the specified pixels.
+// The pixels are stored from the bottom row and up.
+func toImage(pixels []pixel, width int) *Image {
+	height := (len(pixels) + width - 1) / width
+	img := NewImage(width, height)
+	for i, p := range pixels {
+		if !p.opaque {
+			continue
+		}
+		x := i % width
+		y := height - 1 - i/width
+		img.Set(x, y, p.index)
+	}
+	return img
+}
+
+// parseFrames returns the frames of the given CEL or CL2 image (without
+// groups).
+//
+// The image starts with the number of frames n, followed by n+1 offsets to the
+// start of each frame (and the end of the last frame), relative to the start of
+// the image.
+func parseFrames(buf []byte) ([][]byte, error) {
+	if len(buf) < 4 {
+		return nil, errors.Errorf("invalid image size %d; too small", len(buf))
+	}
+	nframes := int(binary.LittleEndian.Uint32(buf))
+	if nframes < 0 || 4*(nframes+2) > len(buf) {
+		return nil, errors.Errorf("invalid number of frames %d; image size %d", nframes, len(buf))
+	}
+	offsets := make([]int, nframes+1)
+	for i := range offsets {
+		offsets[i] = int(binary.LittleEndian.Uint32(buf[4*(i+1):]))
+	}
+	var frames [][]byte
+	for i := 0; i < nframes; i++ {
+		start, end := offsets[i], offsets[i+1]
+		if start > end || end > len(buf) {
+			return nil, errors.Errorf("invalid offset of frame %d; [%d:%d] outside of %d bytes", i, start, end, len(buf))
+		}
+		frames = append(frames, buf[start:end])
+	}
+	return frames, nil
+}
@@ -0,0 +1,102 @@
+package gfx
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// DecodeCL2 decodes the frames of the given CL2 image (e.g. monster graphics),
+// organized into groups (e.g. one group per direction). Images without groups
+// are decoded into a single group. The frame width is determined from the
+// frame header if present, and given by width otherwise.
+func DecodeCL2(buf []byte, width int) ([][]*Image, error) {
+	var groups [][]*Image
+	for i, group := range parseGroups(buf) {
+		frames, err := parseFrames(group)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse frames of CL2 group %d", i)
+		}
+		var imgs []*Image
+		for j, frame := range frames {
+			img, err := decodeFrame(frame, width, decodeCL2RLE)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to decode frame %d of CL2 group %d", j, i)
+			}
+			imgs = append(imgs, img)
+		}
+		groups = append(groups, imgs)
+	}
+	return groups, nil
+}
+
+// decodeCL2RLE decodes the given run-length encoded frame data of a CL2 image.
+//
+// Each run starts with a signed control byte b. For b >= 0, the run consists of
+// b transparent pixels. For -b > 65, the run consists of -b-65 pixels of the
+// palette index following the control byte. Otherwise, the run consists of
+// the -b palette indices following the control byte.
+func decodeCL2RLE(data []byte) ([]pixel, error) {
+	var pixels []pixel
+	for len(data) > 0 {
+		b := int8(data[0])
+		data = data[1:]
+		if b >= 0 {
+			pixels = append(pixels, make([]pixel, int(b))...)
+			continue
+		}
+		n := -int(b)
+		if n > 65 {
+			n -= 65
+			if len(data) < 1 {
+				return nil, errors.New("invalid fill run; missing palette index")
+			}
+			p := pixel{index: data[0], opaque: true}
+			data = data[1:]
+			for i := 0; i < n; i++ {
+				pixels = append(pixels, p)
+			}
+			continue
+		}
+		if n > len(data) {
+			return nil, errors.Errorf("invalid run length %d; exceeds %d bytes of remaining frame data", n, len(data))
+		}
+		for _, index := range data[:n] {
+			pixels = append(pixels, pixel{index: index, opaque: true})
+		}
+		data = data[n:]
+	}
+	return pixels, nil
+}
+
+// parseGroups returns the groups of the given CEL or CL2 image. Images with
+// groups start with the offsets of each group (8 groups, one per direction);
+// images without groups are returned as a single group.
+func parseGroups(buf []byte) [][]byte {
+	const ngroups = 8
+	if len(buf) < 4*ngroups || binary.LittleEndian.Uint32(buf) != 4*ngroups {
+		return [][]byte{buf}
+	}
+	offsets := make([]int, ngroups+1)
+	for i := 0; i < ngroups; i++ {
+		offsets[i] = int(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	offsets[ngroups] = len(buf)
+	var groups [][]byte
+	for i := 0; i < ngroups; i++ {
+		start, end := offsets[i], offsets[i+1]
+		if start > end || end > len(buf) || end-start < 4 {
+			// Not a group; frame offset table of image without groups.
+			return [][]byte{buf}
+		}
+		group := buf[start:end]
+		// Verify that the group starts with a valid frame offset table; the first
+		// frame is located directly after the table.
+		nframes := int(binary.LittleEndian.Uint32(group))
+		if 4*(nframes+2) > len(group) || int(binary.LittleEndian.Uint32(group[4:])) != 4*(nframes+2) {
+			return [][]byte{buf}
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
@@ -0,0 +1,454 @@
+package gfx_test
+
+import (
+	"encoding/binary"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sanctuary/ember/gfx"
+)
+
+func TestParsePalette(t *testing.T) {
+	buf := make([]byte, 3*256)
+	buf[3*255], buf[3*255+1], buf[3*255+2] = 0x10, 0x20, 0x30
+	pal, err := gfx.ParsePalette(buf)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	want := color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xFF}
+	if got := pal[255]; got != want {
+		t.Errorf("colour 255; expected %v, got %v", want, got)
+	}
+	if _, err := gfx.ParsePalette(buf[:767]); err == nil {
+		t.Errorf("expected error for invalid palette size, got nil")
+	}
+	// Colour translation swapping palette index 0 and 255.
+	trn := make([]byte, 256)
+	for i := range trn {
+		trn[i] = byte(i)
+	}
+	trn[0], trn[255] = 255, 0
+	dst, err := gfx.Translate(pal, trn)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dst[0] != pal[255] || dst[255] != pal[0] || dst[1] != pal[1] {
+		t.Errorf("colour translation mismatch")
+	}
+	if _, err := gfx.Translate(pal, trn[:255]); err == nil {
+		t.Errorf("expected error for invalid colour translation size, got nil")
+	}
+}
+
+func TestDecodeCEL(t *testing.T) {
+	// Frame of 3x2 pixels without frame header; rows stored from the bottom and
+	// up.
+	frame := []byte{
+		// Bottom row: 2 pixels, 1 transparent.
+		0x02, 5, 6, 0xFF,
+		// Top row: 1 transparent, 1 pixel, 1 transparent.
+		0xFF, 0x01, 7, 0xFF,
+	}
+	imgs, err := gfx.DecodeCEL(encodeFrames(frame), 3)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	checkImages(t, imgs, [][]string{{
+		".7.",
+		"56.",
+	}})
+	// Frame width is required without frame header.
+	if _, err := gfx.DecodeCEL(encodeFrames(frame), 0); err == nil {
+		t.Errorf("expected error for unknown frame width, got nil")
+	}
+	// Run exceeding frame data.
+	if _, err := gfx.DecodeCEL(encodeFrames([]byte{0x03, 1, 2}), 3); err == nil {
+		t.Errorf("expected error for invalid run length, got nil")
+	}
+}
+
+func TestDecodeCELHeader(t *testing.T) {
+	// Frame of 2x32 pixels; the width is determined from the frame header.
+	var data []byte
+	for y := 0; y < 32; y++ {
+		data = append(data, 0x01, 4, 0xFF)
+	}
+	imgs, err := gfx.DecodeCEL(encodeFrames(withHeader(data)), 0)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	checkImages(t, imgs, [][]string{repeat("4.", 32)})
+}
+
+func TestDecodeCELGroups(t *testing.T) {
+	// Eight groups (one per direction) of a single 1x1 frame each.
+	var groups [][]byte
+	for dir := 0; dir < 8; dir++ {
+		groups = append(groups, encodeFrames([]byte{0x01, byte(dir)}))
+	}
+	got, err := gfx.DecodeCELGroups(encodeGroups(groups), 1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(got) != 8 {
+		t.Fatalf("expected 8 groups, got %d", len(got))
+	}
+	for dir, imgs := range got {
+		checkImages(t, imgs, [][]string{{string("01234567"[dir])}})
+	}
+	// Images without groups are decoded into a single group.
+	got, err = gfx.DecodeCELGroups(encodeFrames([]byte{0x01, 3}, []byte{0xFF}), 1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected 1 group, got %d", len(got))
+	}
+	checkImages(t, got[0], [][]string{{"3"}, {"."}})
+}
+
+func TestDecodeCL2(t *testing.T) {
+	// Frame of 4x2 pixels without frame header; rows stored from the bottom and
+	// up.
+	frame := []byte{
+		// Bottom row: fill run of 3 pixels (-(65+3)), 1 transparent.
+		0xBC, 9, 0x01,
+		// Top row: 2 pixels (-2), 2 transparent.
+		0xFE, 1, 2, 0x02,
+	}
+	// Frame of 1x32 pixels; the width is determined from the frame header.
+	var data []byte
+	for y := 0; y < 32; y++ {
+		data = append(data, 0xFF, byte(y%10))
+	}
+	headerFrame := withHeader(data)
+	var want []string
+	for y := 31; y >= 0; y-- {
+		want = append(want, string("0123456789"[y%10]))
+	}
+	golden := []struct {
+		buf   []byte
+		width int
+		want  [][][]string
+	}{
+		{buf: encodeFrames(frame), width: 4, want: [][][]string{{{"12..", "999."}}}},
+		{buf: encodeFrames(headerFrame, headerFrame), want: [][][]string{{want, want}}},
+	}
+	// Eight groups (one per direction).
+	var groups [][]byte
+	var groupsWant [][][]string
+	for dir := 0; dir < 8; dir++ {
+		groups = append(groups, encodeFrames(frame))
+		groupsWant = append(groupsWant, [][]string{{"12..", "999."}})
+	}
+	golden = append(golden, struct {
+		buf   []byte
+		width int
+		want  [][][]string
+	}{buf: encodeGroups(groups), width: 4, want: groupsWant})
+	for i, g := range golden {
+		got, err := gfx.DecodeCL2(g.buf, g.width)
+		if err != nil {
+			t.Errorf("i=%d: %+v", i, err)
+			continue
+		}
+		if len(got) != len(g.want) {
+			t.Errorf("i=%d: expected %d groups, got %d", i, len(g.want), len(got))
+			continue
+		}
+		for j, imgs := range got {
+			checkImages(t, imgs, g.want[j])
+		}
+	}
+	// Fill run missing palette index.
+	if _, err := gfx.DecodeCL2(encodeFrames([]byte{0xBC}), 3); err == nil {
+		t.Errorf("expected error for fill run without palette index, got nil")
+	}
+}
+
+func TestParseMIN(t *testing.T) {
+	// Two dungeon pieces of two blocks each; frame number in the lower 12 bits,
+	// block type in the following 3 bits.
+	buf := []byte{
+		0x01, 0x20, 0x00, 0x00,
+		0xFF, 0x5F, 0x02, 0x80,
+	}
+	got, err := gfx.ParseMIN(buf, 2)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	want := []gfx.DPiece{
+		{Blocks: []gfx.Block{{Frame: 1, Type: gfx.BlockLeftTriangle}, {}}},
+		{Blocks: []gfx.Block{{Frame: 0xFFF, Type: gfx.BlockRightTrapezoid}, {Frame: 2, Type: gfx.BlockSquare}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if _, err := gfx.ParseMIN(buf[:6], 2); err == nil {
+		t.Errorf("expected error for invalid MIN size, got nil")
+	}
+}
+
+func TestParseTIL(t *testing.T) {
+	// Tile of dungeon pieces top, right, left and bottom; stored 0-based.
+	buf := []byte{0, 0, 1, 0, 2, 0, 0x2C, 0x01}
+	got, err := gfx.ParseTIL(buf)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	want := [][4]int{{1, 2, 3, 301}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if _, err := gfx.ParseTIL(buf[:7]); err == nil {
+		t.Errorf("expected error for invalid TIL size, got nil")
+	}
+}
+
+// padding is the palette index of padding bytes in encoded blocks, which must
+// never be decoded as pixels.
+const padding = 0xEE
+
+func TestDecodeBlock(t *testing.T) {
+	// opaque reports whether the pixel at (x, y) of each block type is opaque.
+	// Triangles span 31 rows, with the widest row at y=16.
+	abs := func(v int) int {
+		if v < 0 {
+			return -v
+		}
+		return v
+	}
+	golden := []struct {
+		typ    gfx.BlockType
+		opaque func(x, y int) bool
+	}{
+		{typ: gfx.BlockSquare, opaque: func(x, y int) bool { return true }},
+		{typ: gfx.BlockTransparent, opaque: func(x, y int) bool { return x >= 16 }},
+		{typ: gfx.BlockLeftTriangle, opaque: func(x, y int) bool { return x >= 2*abs(y-16) }},
+		{typ: gfx.BlockRightTriangle, opaque: func(x, y int) bool { return x < 32-2*abs(y-16) }},
+		{typ: gfx.BlockLeftTrapezoid, opaque: func(x, y int) bool { return y < 16 || x >= 2*(y-16) }},
+		{typ: gfx.BlockRightTrapezoid, opaque: func(x, y int) bool { return y < 16 || x < 32-2*(y-16) }},
+	}
+	var frames [][]byte
+	for _, g := range golden {
+		frames = append(frames, encodeBlock(g.typ))
+	}
+	cel, err := gfx.ParseLevelCEL(encodeFrames(frames...))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for i, g := range golden {
+		img, err := cel.DecodeBlock(gfx.Block{Frame: i + 1, Type: g.typ})
+		if err != nil {
+			t.Errorf("block type %d: %+v", g.typ, err)
+			continue
+		}
+		if img.Width != 32 || img.Height != 32 {
+			t.Errorf("block type %d: expected 32x32 pixels, got %dx%d", g.typ, img.Width, img.Height)
+			continue
+		}
+	loop:
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 32; x++ {
+				i := y*32 + x
+				if img.Opaque[i] != g.opaque(x, y) {
+					t.Errorf("block type %d: pixel (%d, %d); expected opaque %v, got %v", g.typ, x, y, g.opaque(x, y), img.Opaque[i])
+					break loop
+				}
+				// Each row is encoded with its own palette index.
+				if img.Opaque[i] && img.Pix[i] != byte(y) {
+					t.Errorf("block type %d: pixel (%d, %d); expected palette index %d, got %d", g.typ, x, y, y, img.Pix[i])
+					break loop
+				}
+			}
+		}
+	}
+	// Truncated block data.
+	for _, typ := range []gfx.BlockType{gfx.BlockSquare, gfx.BlockLeftTriangle, gfx.BlockRightTrapezoid} {
+		data := encodeBlock(typ)
+		cel, err := gfx.ParseLevelCEL(encodeFrames(data[:len(data)-1]))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if _, err := cel.DecodeBlock(gfx.Block{Frame: 1, Type: typ}); err == nil {
+			t.Errorf("block type %d: expected error for truncated block, got nil", typ)
+		}
+	}
+	// Invalid frame number.
+	if _, err := cel.DecodeBlock(gfx.Block{Frame: len(golden) + 1}); err == nil {
+		t.Errorf("expected error for invalid frame number, got nil")
+	}
+}
+
+func TestDecodeDPiece(t *testing.T) {
+	// Blocks of two solid squares.
+	square := func(index byte) []byte {
+		buf := make([]byte, 32*32)
+		for i := range buf {
+			buf[i] = index
+		}
+		return buf
+	}
+	cel, err := gfx.ParseLevelCEL(encodeFrames(square(1), square(2)))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// Dungeon piece of 2x2 blocks; top left and bottom right present.
+	dpiece := gfx.DPiece{Blocks: []gfx.Block{{Frame: 1}, {}, {}, {Frame: 2}}}
+	img, err := cel.DecodeDPiece(dpiece)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var want []string
+	for y := 0; y < 64; y++ {
+		if y < 32 {
+			want = append(want, strings.Repeat("1", 32)+strings.Repeat(".", 32))
+		} else {
+			want = append(want, strings.Repeat(".", 32)+strings.Repeat("2", 32))
+		}
+	}
+	checkImages(t, []*gfx.Image{img}, [][]string{want})
+	dpiece.Blocks[3].Frame = 3
+	if _, err := cel.DecodeDPiece(dpiece); err == nil {
+		t.Errorf("expected error for invalid frame number, got nil")
+	}
+}
+
+// encodeBlock returns the encoded 32x32 block of the given type. The rows are
+// encoded from the bottom and up, each row using its y coordinate as palette
+// index.
+func encodeBlock(typ gfx.BlockType) []byte {
+	var buf []byte
+	y := 31
+	row := func(width int) {
+		for i := 0; i < width; i++ {
+			buf = append(buf, byte(y))
+		}
+		y--
+	}
+	pad := func(n int) {
+		for i := 0; i < n; i++ {
+			buf = append(buf, padding)
+		}
+	}
+	switch typ {
+	case gfx.BlockSquare:
+		for y >= 0 {
+			row(32)
+		}
+	case gfx.BlockTransparent:
+		for y >= 0 {
+			// 16 transparent pixels, followed by 16 pixels.
+			buf = append(buf, 0xF0, 0x10)
+			row(16)
+		}
+	case gfx.BlockLeftTriangle, gfx.BlockLeftTrapezoid:
+		// Rows preceded by padding.
+		for i := 30; i >= 0; i -= 2 {
+			pad(i & 2)
+			row(32 - i)
+		}
+		if typ == gfx.BlockLeftTrapezoid {
+			for y >= 0 {
+				row(32)
+			}
+			break
+		}
+		for i := 2; i < 32; i += 2 {
+			pad(i & 2)
+			row(32 - i)
+		}
+	case gfx.BlockRightTriangle, gfx.BlockRightTrapezoid:
+		// Rows followed by padding.
+		for i := 30; i >= 0; i -= 2 {
+			row(32 - i)
+			pad(i & 2)
+		}
+		if typ == gfx.BlockRightTrapezoid {
+			for y >= 0 {
+				row(32)
+			}
+			break
+		}
+		for i := 2; i < 32; i += 2 {
+			row(32 - i)
+			pad(i & 2)
+		}
+	}
+	return buf
+}
+
+// encodeFrames returns a CEL or CL2 image (without groups) of the given frames.
+func encodeFrames(frames ...[]byte) []byte {
+	n := len(frames)
+	buf := make([]byte, 4*(n+2))
+	binary.LittleEndian.PutUint32(buf, uint32(n))
+	offset := len(buf)
+	for i, frame := range frames {
+		binary.LittleEndian.PutUint32(buf[4*(i+1):], uint32(offset))
+		offset += len(frame)
+	}
+	binary.LittleEndian.PutUint32(buf[4*(n+1):], uint32(offset))
+	for _, frame := range frames {
+		buf = append(buf, frame...)
+	}
+	return buf
+}
+
+// encodeGroups returns a CEL or CL2 image of the given 8 groups.
+func encodeGroups(groups [][]byte) []byte {
+	buf := make([]byte, 4*len(groups))
+	for i, group := range groups {
+		binary.LittleEndian.PutUint32(buf[4*i:], uint32(len(buf)))
+		buf = append(buf, group...)
+	}
+	return buf
+}
+
+// withHeader returns the given frame data of 32 rows, preceded by a frame
+// header.
+func withHeader(data []byte) []byte {
+	hdr := make([]byte, 10)
+	binary.LittleEndian.PutUint16(hdr, 10)
+	binary.LittleEndian.PutUint16(hdr[2:], uint16(len(hdr)+len(data)))
+	return append(hdr, data...)
+}
+
+// repeat returns n copies of the given row.
+func repeat(row string, n int) []string {
+	var rows []string
+	for i := 0; i < n; i++ {
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// checkImages checks the given images against the expected rows of pixels; one
+// hexadecimal digit per palette index, and "." for transparent pixels.
+func checkImages(t *testing.T, imgs []*gfx.Image, want [][]string) {
+	t.Helper()
+	if len(imgs) != len(want) {
+		t.Errorf("expected %d frames, got %d", len(want), len(imgs))
+		return
+	}
+	for i, img := range imgs {
+		var got []string
+		for y := 0; y < img.Height; y++ {
+			row := &strings.Builder{}
+			for x := 0; x < img.Width; x++ {
+				j := y*img.Width + x
+				if !img.Opaque[j] {
+					row.WriteByte('.')
+					continue
+				}
+				row.WriteByte("0123456789ABCDEF"[img.Pix[j]&0xF])
+			}
+			got = append(got, row.String())
+		}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("frame %d: expected\n%s\ngot\n%s", i, strings.Join(want[i], "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package gfx
+
+import (
+	"image"
+	"image/color"
+)
+
+// An Image is a paletted image with transparency.
+type Image struct {
+	// Palette index of each pixel, in row-major order.
+	Pix []uint8
+	// Opaque specifies whether each pixel is opaque, in row-major order.
+	Opaque []bool
+	// Image dimensions in pixels.
+	Width, Height int
+}
+
+// NewImage returns a new fully transparent image of the given dimensions.
+func NewImage(width, height int) *Image {
+	return &Image{
+		Pix:    make([]uint8, width*height),
+		Opaque: make([]bool, width*height),
+		Width:  width,
+		Height: height,
+	}
+}
+
+// Set sets the palette index of the pixel at (x, y), and marks it as opaque.
+// Pixels outside of the image are ignored.
+func (img *Image) Set(x, y int, index uint8) {
+	if x < 0 || x >= img.Width || y < 0 || y >= img.Height {
+		return
+	}
+	i := y*img.Width + x
+	img.Pix[i] = index
+	img.Opaque[i] = true
+}
+
+// Draw draws the opaque pixels of src onto img, with the top-left corner of src
+// at (x, y).
+func (img *Image) Draw(src *Image, x, y int) {
+	for sy := 0; sy < src.Height; sy++ {
+		for sx := 0; sx < src.Width; sx++ {
+			i := sy*src.Width + sx
+			if src.Opaque[i] {
+				img.Set(x+sx, y+sy, src.Pix[i])
+			}
+		}
+	}
+}
+
+// Render renders the image using the given palette.
+func (img *Image) Render(pal color.Palette) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, img.Width, img.Height))
+	for i, index := range img.Pix {
+		if !img.Opaque[i] {
+			continue
+		}
+		c := color.NRGBAModel.Convert(pal[index]).(color.NRGBA)
+		dst.SetNRGBA(i%img.Width, i/img.Width, c)
+	}
+	return dst
+}
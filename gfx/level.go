@@ -0,0 +1,234 @@
+package gfx
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// BlockType specifies the encoding of a 32x32 block of a dungeon piece.
+type BlockType uint8
+
+// Block types.
+const (
+	// Opaque square; 32x32 palette indices.
+	BlockSquare BlockType = 0
+	// Square with transparency; run-length encoded as regular CEL frames.
+	BlockTransparent BlockType = 1
+	// Left triangle; right half of an isometric tile, opaque to the right.
+	BlockLeftTriangle BlockType = 2
+	// Right triangle; left half of an isometric tile, opaque to the left.
+	BlockRightTriangle BlockType = 3
+	// Left trapezoid; left triangle with an opaque upper half.
+	BlockLeftTrapezoid BlockType = 4
+	// Right trapezoid; right triangle with an opaque upper half.
+	BlockRightTrapezoid BlockType = 5
+)
+
+// Block width and height in pixels.
+const blockSize = 32
+
+// A Block is a 32x32 block of a dungeon piece.
+type Block struct {
+	// Frame number of the block within the level CEL image (1-based); 0 if the
+	// block is empty.
+	Frame int
+	// Encoding of the block.
+	Type BlockType
+}
+
+// A DPiece is a dungeon piece (i.e. miniture tile), made up of two columns of
+// 32x32 blocks.
+type DPiece struct {
+	// Blocks of the dungeon piece, from top to bottom, left before right.
+	Blocks []Block
+}
+
+// ParseMIN parses the given MIN file, containing the dungeon pieces of a
+// dungeon type. Each dungeon piece consists of nblocks blocks (e.g. 10 blocks
+// for 64x160 dungeon pieces and 16 blocks for 64x256 dungeon pieces).
+//
+// Each block is stored as a 16-bit value; the frame number in the lower 12
+// bits, and the block type in the following 3 bits.
+func ParseMIN(buf []byte, nblocks int) ([]DPiece, error) {
+	size := 2 * nblocks
+	if nblocks <= 0 || len(buf)%size != 0 {
+		return nil, errors.Errorf("invalid MIN size %d; expected multiple of %d", len(buf), size)
+	}
+	var dpieces []DPiece
+	for ; len(buf) > 0; buf = buf[size:] {
+		var dpiece DPiece
+		for i := 0; i < nblocks; i++ {
+			v := binary.LittleEndian.Uint16(buf[2*i:])
+			block := Block{
+				Frame: int(v & 0x0FFF),
+				Type:  BlockType(v >> 12 & 0x7),
+			}
+			dpiece.Blocks = append(dpiece.Blocks, block)
+		}
+		dpieces = append(dpieces, dpiece)
+	}
+	return dpieces, nil
+}
+
+// ParseTIL parses the given TIL file, containing the tiles of a dungeon type.
+// Each tile consists of four dungeon pieces; top, right, left and bottom. The
+// dungeon piece IDs are 1-based.
+func ParseTIL(buf []byte) ([][4]int, error) {
+	const size = 4 * 2
+	if len(buf)%size != 0 {
+		return nil, errors.Errorf("invalid TIL size %d; expected multiple of %d", len(buf), size)
+	}
+	var tiles [][4]int
+	for ; len(buf) > 0; buf = buf[size:] {
+		var tile [4]int
+		for i := range tile {
+			tile[i] = int(binary.LittleEndian.Uint16(buf[2*i:])) + 1
+		}
+		tiles = append(tiles, tile)
+	}
+	return tiles, nil
+}
+
+// A LevelCEL is the CEL image of a dungeon type (e.g. l1.cel), containing the
+// blocks of the dungeon pieces.
+type LevelCEL struct {
+	// Frames of the CEL image.
+	frames [][]byte
+}
+
+// ParseLevelCEL parses the given CEL image of a dungeon type.
+func ParseLevelCEL(buf []byte) (*LevelCEL, error) {
+	frames, err := parseFrames(buf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &LevelCEL{frames: frames}, nil
+}
+
+// DecodeDPiece decodes the given dungeon piece. The dungeon piece image is 64
+// pixels wide, and 32 pixels tall per row of blocks.
+func (cel *LevelCEL) DecodeDPiece(dpiece DPiece) (*Image, error) {
+	nrows := (len(dpiece.Blocks) + 1) / 2
+	img := NewImage(2*blockSize, nrows*blockSize)
+	for i, block := range dpiece.Blocks {
+		if block.Frame == 0 {
+			continue
+		}
+		blockImg, err := cel.DecodeBlock(block)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		img.Draw(blockImg, (i%2)*blockSize, (i/2)*blockSize)
+	}
+	return img, nil
+}
+
+// DecodeBlock decodes the given 32x32 block.
+func (cel *LevelCEL) DecodeBlock(block Block) (*Image, error) {
+	if block.Frame < 1 || block.Frame > len(cel.frames) {
+		return nil, errors.Errorf("invalid block frame number %d; expected 1-%d", block.Frame, len(cel.frames))
+	}
+	data := cel.frames[block.Frame-1]
+	img := NewImage(blockSize, blockSize)
+	// The rows of blocks are stored from the bottom row and up.
+	r := &blockReader{img: img, data: data, y: blockSize - 1}
+	switch block.Type {
+	case BlockSquare:
+		for y := 0; y < blockSize; y++ {
+			r.row(0, blockSize, 0)
+		}
+	case BlockTransparent:
+		pixels, err := decodeCELRLE(data)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(pixels) != blockSize*blockSize {
+			return nil, errors.Errorf("invalid number of pixels in transparent block; expected %d, got %d", blockSize*blockSize, len(pixels))
+		}
+		return toImage(pixels, blockSize), nil
+	case BlockLeftTriangle, BlockLeftTrapezoid:
+		// Lower half; each row is preceded by 0 or 2 bytes of padding.
+		for i := blockSize - 2; i >= 0; i -= 2 {
+			r.skip(i & 2)
+			r.row(i, blockSize-i, 0)
+		}
+		if block.Type == BlockLeftTrapezoid {
+			r.upperHalf()
+			break
+		}
+		// Upper half.
+		for i := 2; i < blockSize; i += 2 {
+			r.skip(i & 2)
+			r.row(i, blockSize-i, 0)
+		}
+	case BlockRightTriangle, BlockRightTrapezoid:
+		// Lower half; each row is followed by 0 or 2 bytes of padding.
+		for i := blockSize - 2; i >= 0; i -= 2 {
+			r.row(0, blockSize-i, i&2)
+		}
+		if block.Type == BlockRightTrapezoid {
+			r.upperHalf()
+			break
+		}
+		// Upper half.
+		for i := 2; i < blockSize; i += 2 {
+			r.row(0, blockSize-i, i&2)
+		}
+	default:
+		return nil, errors.Errorf("support for block type %d not yet implemented", block.Type)
+	}
+	if r.err != nil {
+		return nil, errors.Wrapf(r.err, "unable to decode block of type %d", block.Type)
+	}
+	return img, nil
+}
+
+// blockReader decodes the rows of a block, from the bottom row and up.
+type blockReader struct {
+	// Block image.
+	img *Image
+	// Remaining block data.
+	data []byte
+	// Current row.
+	y int
+	// First error encountered.
+	err error
+}
+
+// row decodes width palette indices into the current row, starting at x, and
+// skips padding bytes following the row.
+func (r *blockReader) row(x, width, padding int) {
+	if r.err != nil {
+		return
+	}
+	if width > len(r.data) {
+		r.err = errors.Errorf("invalid block data; row %d requires %d bytes, got %d", r.y, width, len(r.data))
+		return
+	}
+	for i, index := range r.data[:width] {
+		r.img.Set(x+i, r.y, index)
+	}
+	r.data = r.data[width:]
+	r.y--
+	r.skip(padding)
+}
+
+// skip skips n bytes of padding.
+func (r *blockReader) skip(n int) {
+	if r.err != nil {
+		return
+	}
+	if n > len(r.data) {
+		r.err = errors.Errorf("invalid block data; padding of %d bytes, got %d", n, len(r.data))
+		return
+	}
+	r.data = r.data[n:]
+}
+
+// upperHalf decodes the opaque upper half of trapezoid blocks.
+func (r *blockReader) upperHalf() {
+	for i := 0; i < blockSize/2; i++ {
+		r.row(0, blockSize, 0)
+	}
+}
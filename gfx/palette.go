@@ -0,0 +1,40 @@
+// Package gfx decodes the graphics formats of Diablo 1; palettes (PAL),
+// colour translations (TRN), sprites (CEL and CL2) and the dungeon pieces and
+// tiles of levels (MIN and TIL).
+//
+// The graphics are decoded in memory to paletted images with transparency,
+// which may be rendered using any palette (e.g. the palette of each theme of a
+// dungeon type, or a palette with colour translations applied).
+package gfx
+
+import (
+	"image/color"
+
+	"github.com/pkg/errors"
+)
+
+// ParsePalette parses the given palette of 256 RGB colours (e.g. town.pal).
+func ParsePalette(buf []byte) (color.Palette, error) {
+	const ncolors = 256
+	if len(buf) != 3*ncolors {
+		return nil, errors.Errorf("invalid palette size; expected %d, got %d", 3*ncolors, len(buf))
+	}
+	pal := make(color.Palette, ncolors)
+	for i := range pal {
+		pal[i] = color.NRGBA{R: buf[3*i], G: buf[3*i+1], B: buf[3*i+2], A: 0xFF}
+	}
+	return pal, nil
+}
+
+// Translate returns the given palette with colours translated by the specified
+// colour translation of 256 palette indices (e.g. the TRN of a unique monster).
+func Translate(pal color.Palette, trn []byte) (color.Palette, error) {
+	if len(trn) != len(pal) {
+		return nil, errors.Errorf("invalid colour translation size; expected %d, got %d", len(pal), len(trn))
+	}
+	dst := make(color.Palette, len(pal))
+	for i, j := range trn {
+		dst[i] = pal[j]
+	}
+	return dst, nil
+}
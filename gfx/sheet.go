@@ -0,0 +1,38 @@
+package gfx
+
+// SpriteSheet arranges the given frames into a sprite sheet, with one row of
+// cells per slice of frames. Each frame is placed at the bottom center of its
+// cell, which is cellWidth x cellHeight pixels; the width and height of the
+// largest frame are used if cellWidth or cellHeight is 0 respectively.
+func SpriteSheet(rows [][]*Image, cellWidth, cellHeight int) *Image {
+	ncols := 0
+	maxWidth, maxHeight := 0, 0
+	for _, row := range rows {
+		if len(row) > ncols {
+			ncols = len(row)
+		}
+		for _, frame := range row {
+			if frame.Width > maxWidth {
+				maxWidth = frame.Width
+			}
+			if frame.Height > maxHeight {
+				maxHeight = frame.Height
+			}
+		}
+	}
+	if cellWidth == 0 {
+		cellWidth = maxWidth
+	}
+	if cellHeight == 0 {
+		cellHeight = maxHeight
+	}
+	sheet := NewImage(ncols*cellWidth, len(rows)*cellHeight)
+	for y, row := range rows {
+		for x, frame := range row {
+			dx := x*cellWidth + (cellWidth-frame.Width)/2
+			dy := y*cellHeight + cellHeight - frame.Height
+			sheet.Draw(frame, dx, dy)
+		}
+	}
+	return sheet
+}
@@ -0,0 +1,89 @@
+// Package light computes the darkness of Diablo 1 levels, as lit by static
+// light sources (e.g. lava).
+//
+// Darkness is measured in light levels, from 0 (fully lit) to MaxLevel (fully
+// dark). The light of each light source fades with the distance to the light
+// source, and reaches darkness at the light radius.
+//
+// FLARE has no dynamic lighting, and no engine setting for the light radius of
+// the player. Dark levels are instead darkened by the darkness layer of maps
+// (gentmx -darkness), as lit by the static light sources of the level. The
+// light of the player is accounted for by capping the darkness at the light
+// level of the player's light at the edge of the view (see PlayerLevel).
+package light
+
+import (
+	"image"
+	"math"
+)
+
+// MaxLevel specifies the darkest light level.
+const MaxLevel = 15
+
+// PlayerRadius specifies the light radius of the player.
+//
+// ref: CreatePlayer
+const PlayerRadius = 10
+
+// viewRadius specifies the approximate distance in number of cels from the
+// player to the edge of the 640x352 view.
+const viewRadius = PlayerRadius / 2
+
+// PlayerLevel returns the light level of the player's light at the edge of the
+// view; the darkest light level seen near the player.
+func PlayerLevel() int {
+	return Level(viewRadius, PlayerRadius)
+}
+
+// A Source is a static light source.
+type Source struct {
+	// Location of the light source in the map.
+	Pos image.Point
+	// Light radius in number of cels.
+	Radius int
+}
+
+// Level returns the light level at the given distance in number of cels from a
+// light source of the specified light radius.
+//
+// ref: MakeLightTable
+func Level(dist, radius int) int {
+	level := (dist*MaxLevel + (radius+1)/2) / (radius + 1)
+	if level > MaxLevel {
+		return MaxLevel
+	}
+	return level
+}
+
+// Darkness returns the light level of each cell of a map with the given
+// dimensions, indexed by x and y coordinate, as lit by the specified light
+// sources. As the darkness is static, the light level is capped at PlayerLevel
+// to keep cells visible when approached by the player.
+func Darkness(width, height int, sources []Source) [][]int {
+	playerLevel := PlayerLevel()
+	levels := make([][]int, width)
+	for x := range levels {
+		levels[x] = make([]int, height)
+		for y := range levels[x] {
+			levels[x][y] = playerLevel
+		}
+	}
+	for _, src := range sources {
+		for x := src.Pos.X - src.Radius; x <= src.Pos.X+src.Radius; x++ {
+			if x < 0 || x >= width {
+				continue
+			}
+			for y := src.Pos.Y - src.Radius; y <= src.Pos.Y+src.Radius; y++ {
+				if y < 0 || y >= height {
+					continue
+				}
+				dx, dy := float64(x-src.Pos.X), float64(y-src.Pos.Y)
+				dist := int(math.Sqrt(dx*dx + dy*dy))
+				if level := Level(dist, src.Radius); level < levels[x][y] {
+					levels[x][y] = level
+				}
+			}
+		}
+	}
+	return levels
+}
@@ -0,0 +1,120 @@
+package light_test
+
+import (
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/sanctuary/ember/light"
+)
+
+func TestLevel(t *testing.T) {
+	golden := []struct {
+		dist   int
+		radius int
+		want   int
+	}{
+		// Lava of the Caves.
+		{dist: 0, radius: 7, want: 0},
+		{dist: 1, radius: 7, want: 2},
+		{dist: 4, radius: 7, want: 8},
+		{dist: 7, radius: 7, want: 13},
+		{dist: 8, radius: 7, want: 15},
+		// Clamped to the darkest light level.
+		{dist: 9, radius: 7, want: light.MaxLevel},
+		{dist: 100, radius: 7, want: light.MaxLevel},
+		// Player.
+		{dist: 5, radius: light.PlayerRadius, want: 7},
+		{dist: 10, radius: light.PlayerRadius, want: 14},
+	}
+	for _, g := range golden {
+		got := light.Level(g.dist, g.radius)
+		if got != g.want {
+			t.Errorf("distance %d of light radius %d; expected %d, got %d", g.dist, g.radius, g.want, got)
+		}
+	}
+	if got, want := light.PlayerLevel(), 7; got != want {
+		t.Errorf("player light level mismatch; expected %d, got %d", want, got)
+	}
+}
+
+func TestDarkness(t *testing.T) {
+	// Darkest light level, as capped by the light of the player.
+	d := light.PlayerLevel()
+	golden := []struct {
+		name          string
+		width, height int
+		sources       []light.Source
+		// Expected light levels, one row per y coordinate.
+		want [][]int
+	}{
+		{
+			name:  "no light sources",
+			width: 3, height: 2,
+			want: [][]int{
+				{d, d, d},
+				{d, d, d},
+			},
+		},
+		{
+			name:  "single light source",
+			width: 3, height: 3,
+			sources: []light.Source{
+				{Pos: image.Pt(1, 1), Radius: 7},
+			},
+			want: [][]int{
+				{2, 2, 2},
+				{2, 0, 2},
+				{2, 2, 2},
+			},
+		},
+		{
+			// Light fades with the distance to the light source, and cells
+			// outside of the light radius stay dark.
+			name:  "light radius",
+			width: 5, height: 1,
+			sources: []light.Source{
+				{Pos: image.Pt(0, 0), Radius: 3},
+			},
+			want: [][]int{
+				{0, 4, d, d, d},
+			},
+		},
+		{
+			// Overlapping light sources take the lightest light level, and
+			// light sources at the edge of the map are clipped.
+			name:  "overlapping light sources",
+			width: 6, height: 1,
+			sources: []light.Source{
+				{Pos: image.Pt(0, 0), Radius: 3},
+				{Pos: image.Pt(2, 0), Radius: 7},
+				{Pos: image.Pt(5, 0), Radius: 1},
+			},
+			want: [][]int{
+				{0, 2, 0, 2, 4, 0},
+			},
+		},
+	}
+	for _, g := range golden {
+		got := light.Darkness(g.width, g.height, g.sources)
+		if want := transpose(g.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: light levels mismatch; expected %v, got %v", g.name, g.want, transpose(got))
+		}
+	}
+}
+
+// transpose returns the given cells indexed by y and x coordinate, as indexed by
+// x and y coordinate, and vice versa.
+func transpose(cells [][]int) [][]int {
+	if len(cells) == 0 {
+		return nil
+	}
+	dst := make([][]int, len(cells[0]))
+	for i := range dst {
+		dst[i] = make([]int, len(cells))
+		for j := range cells {
+			dst[i][j] = cells[j][i]
+		}
+	}
+	return dst
+}
@@ -0,0 +1,77 @@
+package mpq
+
+import "strings"
+
+// Hash types used by hashString.
+const (
+	// Hash table offset.
+	hashTableOffset = 0
+	// Name hash A.
+	hashNameA = 1
+	// Name hash B.
+	hashNameB = 2
+	// Encryption key.
+	hashFileKey = 3
+)
+
+// cryptTable is the table of encryption constants used for hashing and
+// decryption.
+var cryptTable [0x500]uint32
+
+func init() {
+	seed := uint32(0x00100001)
+	for index1 := 0; index1 < 0x100; index1++ {
+		index2 := index1
+		for i := 0; i < 5; i++ {
+			seed = (seed*125 + 3) % 0x2AAAAB
+			temp1 := (seed & 0xFFFF) << 0x10
+			seed = (seed*125 + 3) % 0x2AAAAB
+			temp2 := seed & 0xFFFF
+			cryptTable[index2] = temp1 | temp2
+			index2 += 0x100
+		}
+	}
+}
+
+// hashString returns the hash of the given file name, using the specified hash
+// type. File names are hashed case-insensitively, with backslash as path
+// separator.
+func hashString(name string, hashType uint32) uint32 {
+	name = strings.ToUpper(strings.Replace(name, "/", `\`, -1))
+	seed1 := uint32(0x7FED7FED)
+	seed2 := uint32(0xEEEEEEEE)
+	for i := 0; i < len(name); i++ {
+		ch := uint32(name[i])
+		seed1 = cryptTable[hashType*0x100+ch] ^ (seed1 + seed2)
+		seed2 = ch + seed1 + seed2 + (seed2 << 5) + 3
+	}
+	return seed1
+}
+
+// decrypt decrypts the given data in place, using the specified key.
+func decrypt(data []uint32, key uint32) {
+	seed := uint32(0xEEEEEEEE)
+	for i := range data {
+		seed += cryptTable[0x400+(key&0xFF)]
+		ch := data[i] ^ (key + seed)
+		key = ((^key << 0x15) + 0x11111111) | (key >> 0x0B)
+		seed = ch + seed + (seed << 5) + 3
+		data[i] = ch
+	}
+}
+
+// decryptBytes decrypts the given data in place, using the specified key. Any
+// trailing bytes not making up a full 32-bit word are left unencrypted.
+func decryptBytes(buf []byte, key uint32) {
+	data := make([]uint32, len(buf)/4)
+	for i := range data {
+		data[i] = uint32(buf[4*i]) | uint32(buf[4*i+1])<<8 | uint32(buf[4*i+2])<<16 | uint32(buf[4*i+3])<<24
+	}
+	decrypt(data, key)
+	for i, v := range data {
+		buf[4*i] = byte(v)
+		buf[4*i+1] = byte(v >> 8)
+		buf[4*i+2] = byte(v >> 16)
+		buf[4*i+3] = byte(v >> 24)
+	}
+}
@@ -0,0 +1,197 @@
+package mpq
+
+import (
+	"github.com/pkg/errors"
+)
+
+// The explode decompressor of the PKWARE Data Compression Library, as used by
+// the MPQ archives of Diablo 1.
+//
+// Based on blast.c by Mark Adler.
+//
+// ref: https://github.com/madler/zlib/blob/master/contrib/blast/blast.c
+
+// Maximum number of bits in a Huffman code.
+const maxBits = 13
+
+// huffman is a canonical Huffman code.
+type huffman struct {
+	// Number of symbols of each code length.
+	count [maxBits + 1]int
+	// Symbols ordered by code length and value.
+	symbol []int
+}
+
+// Huffman codes of the explode decompressor.
+var (
+	// Literal code.
+	litCode = newHuffman([]byte{
+		11, 124, 8, 7, 28, 7, 188, 13, 76, 4, 10, 8, 12, 10, 12, 10, 8, 23, 8,
+		9, 7, 6, 7, 8, 7, 6, 55, 8, 23, 24, 12, 11, 7, 9, 11, 12, 6, 7, 22, 5,
+		7, 24, 6, 11, 9, 6, 7, 22, 7, 11, 38, 7, 9, 8, 25, 11, 8, 11, 9, 12,
+		8, 12, 5, 38, 5, 38, 5, 11, 7, 5, 6, 21, 6, 10, 53, 8, 7, 24, 10, 27,
+		44, 253, 253, 253, 252, 252, 252, 13, 12, 45, 12, 45, 12, 61, 12, 45,
+		44, 173,
+	})
+	// Length code.
+	lenCode = newHuffman([]byte{2, 35, 36, 53, 38, 23})
+	// Distance code.
+	distCode = newHuffman([]byte{2, 20, 53, 230, 247, 151, 248})
+)
+
+// Base and number of extra bits of lengths, indexed by length symbol.
+var (
+	lenBase  = [16]int{3, 2, 4, 5, 6, 7, 8, 9, 10, 12, 16, 24, 40, 72, 136, 264}
+	lenExtra = [16]uint{0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8}
+)
+
+// newHuffman returns the canonical Huffman code of the given compact code
+// length representation. Each byte encodes a code length in the low four bits
+// and a repeat count minus one in the high four bits.
+func newHuffman(rep []byte) *huffman {
+	var lengths []int
+	for _, b := range rep {
+		n := int(b>>4) + 1
+		length := int(b & 0x0F)
+		for i := 0; i < n; i++ {
+			lengths = append(lengths, length)
+		}
+	}
+	h := &huffman{symbol: make([]int, len(lengths))}
+	for _, length := range lengths {
+		h.count[length]++
+	}
+	var offs [maxBits + 1]int
+	for length := 1; length < maxBits; length++ {
+		offs[length+1] = offs[length] + h.count[length]
+	}
+	for symbol, length := range lengths {
+		if length != 0 {
+			h.symbol[offs[length]] = symbol
+			offs[length]++
+		}
+	}
+	return h
+}
+
+// bitReader reads bits from a byte slice, least significant bit first.
+type bitReader struct {
+	// Input data.
+	buf []byte
+	// Bit buffer.
+	bitBuf uint
+	// Number of bits in bit buffer.
+	bitCnt uint
+}
+
+// bits reads n bits from the input.
+func (br *bitReader) bits(n uint) (int, error) {
+	val := br.bitBuf
+	for br.bitCnt < n {
+		if len(br.buf) == 0 {
+			return 0, errors.New("unexpected end of compressed data")
+		}
+		val |= uint(br.buf[0]) << br.bitCnt
+		br.buf = br.buf[1:]
+		br.bitCnt += 8
+	}
+	br.bitBuf = val >> n
+	br.bitCnt -= n
+	return int(val & (1<<n - 1)), nil
+}
+
+// decode decodes a symbol from the input, using the given Huffman code. The
+// codes are stored inverted in the input.
+func (br *bitReader) decode(h *huffman) (int, error) {
+	code, first, index := 0, 0, 0
+	for length := 1; length <= maxBits; length++ {
+		bit, err := br.bits(1)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		code |= bit ^ 1
+		count := h.count[length]
+		if code < first+count {
+			return h.symbol[index+(code-first)], nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		code <<= 1
+	}
+	return 0, errors.New("invalid Huffman code")
+}
+
+// explode decompresses the given PKWARE DCL imploded data.
+func explode(buf []byte) ([]byte, error) {
+	br := &bitReader{buf: buf}
+	lit, err := br.bits(8)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if lit > 1 {
+		return nil, errors.Errorf("invalid literal flag %d; expected 0 or 1", lit)
+	}
+	dict, err := br.bits(8)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if dict < 4 || dict > 6 {
+		return nil, errors.Errorf("invalid dictionary size %d; expected 4, 5 or 6", dict)
+	}
+	var out []byte
+	for {
+		isPair, err := br.bits(1)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if isPair == 0 {
+			// Literal.
+			var symbol int
+			if lit == 1 {
+				symbol, err = br.decode(litCode)
+			} else {
+				symbol, err = br.bits(8)
+			}
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			out = append(out, byte(symbol))
+			continue
+		}
+		// Length-distance pair.
+		symbol, err := br.decode(lenCode)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		extra, err := br.bits(lenExtra[symbol])
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		length := lenBase[symbol] + extra
+		if length == 519 {
+			// End of stream.
+			break
+		}
+		distBits := uint(dict)
+		if length == 2 {
+			distBits = 2
+		}
+		symbol, err = br.decode(distCode)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		low, err := br.bits(distBits)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		dist := symbol<<distBits + low + 1
+		if dist > len(out) {
+			return nil, errors.Errorf("invalid distance %d; exceeds output length %d", dist, len(out))
+		}
+		for i := 0; i < length; i++ {
+			out = append(out, out[len(out)-dist])
+		}
+	}
+	return out, nil
+}
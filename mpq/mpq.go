@@ -0,0 +1,392 @@
+// Package mpq implements read access to MPQ archives, as used by Diablo 1 (e.g.
+// diabdat.mpq).
+//
+// MPQ archives of Diablo 1 contain no list of file names; files are located by
+// the hash of their name, and may thus only be opened by name.
+package mpq
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Archive is an MPQ archive.
+type Archive struct {
+	// Underlying reader of the archive.
+	r io.ReaderAt
+	// Underlying file of the archive; nil if not opened from file.
+	f *os.File
+	// Offset of the MPQ header within the underlying reader.
+	offset int64
+	// Sector size in bytes.
+	sectorSize int
+	// Hash table of the archive.
+	hashTable []hashEntry
+	// Block table of the archive.
+	blockTable []blockEntry
+}
+
+// header is the MPQ archive header.
+type header struct {
+	// MPQ signature ("MPQ\x1A").
+	Magic [4]byte
+	// Size of the header in bytes.
+	HeaderSize uint32
+	// Size of the archive in bytes.
+	ArchiveSize uint32
+	// Format version.
+	FormatVersion uint16
+	// Sector size, as a power of two shifted left of 512.
+	SectorSizeShift uint16
+	// Offset of the hash table, relative to the MPQ header.
+	HashTableOffset uint32
+	// Offset of the block table, relative to the MPQ header.
+	BlockTableOffset uint32
+	// Number of hash table entries.
+	NHashEntries uint32
+	// Number of block table entries.
+	NBlockEntries uint32
+}
+
+// hashEntry is an entry of the hash table.
+type hashEntry struct {
+	// Name hash A of the file.
+	NameA uint32
+	// Name hash B of the file.
+	NameB uint32
+	// Locale of the file.
+	Locale uint16
+	// Platform of the file.
+	Platform uint16
+	// Index into the block table of the file.
+	BlockIndex uint32
+}
+
+// Special block indices of hash table entries.
+const (
+	// Hash table entry is empty, and has always been empty; terminates search.
+	blockIndexEmpty = 0xFFFFFFFF
+	// Hash table entry is empty, but was previously valid.
+	blockIndexDeleted = 0xFFFFFFFE
+)
+
+// blockEntry is an entry of the block table.
+type blockEntry struct {
+	// Offset of the file data, relative to the MPQ header.
+	FilePos uint32
+	// Compressed size of the file in bytes.
+	CompressedSize uint32
+	// Uncompressed size of the file in bytes.
+	FileSize uint32
+	// File flags.
+	Flags uint32
+}
+
+// File flags.
+const (
+	// File is compressed using PKWARE DCL implode.
+	flagImplode = 0x00000100
+	// File is compressed using one or more compression methods.
+	flagCompress = 0x00000200
+	// File is encrypted.
+	flagEncrypted = 0x00010000
+	// Encryption key of the file is adjusted by the file position and size.
+	flagFixKey = 0x00020000
+	// File is stored as a single unit, rather than split into sectors.
+	flagSingleUnit = 0x01000000
+	// File exists.
+	flagExists = 0x80000000
+)
+
+// Compression methods of files with flagCompress set.
+const (
+	// zlib compression.
+	compressZlib = 0x02
+	// PKWARE DCL implode compression.
+	compressImplode = 0x08
+)
+
+// Open opens the given MPQ archive.
+func Open(mpqPath string) (*Archive, error) {
+	f, err := os.Open(mpqPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	a, err := NewArchive(f)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "unable to parse MPQ archive %q", mpqPath)
+	}
+	a.f = f
+	return a, nil
+}
+
+// NewArchive returns a new MPQ archive reading from r.
+func NewArchive(r io.ReaderAt) (*Archive, error) {
+	a := &Archive{r: r}
+	// Locate MPQ header; stored at a 512 byte boundary.
+	var hdr header
+	for offset := int64(0); ; offset += 512 {
+		sr := io.NewSectionReader(r, offset, 32)
+		if err := binary.Read(sr, binary.LittleEndian, &hdr); err != nil {
+			return nil, errors.Errorf("unable to locate MPQ header; %v", err)
+		}
+		if string(hdr.Magic[:]) == "MPQ\x1A" {
+			a.offset = offset
+			break
+		}
+	}
+	a.sectorSize = 512 << hdr.SectorSizeShift
+	// Parse hash table.
+	hashData, err := a.readTable(int64(hdr.HashTableOffset), hdr.NHashEntries, "(hash table)")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	a.hashTable = make([]hashEntry, hdr.NHashEntries)
+	if err := binary.Read(bytes.NewReader(hashData), binary.LittleEndian, a.hashTable); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// Parse block table.
+	blockData, err := a.readTable(int64(hdr.BlockTableOffset), hdr.NBlockEntries, "(block table)")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	a.blockTable = make([]blockEntry, hdr.NBlockEntries)
+	if err := binary.Read(bytes.NewReader(blockData), binary.LittleEndian, a.blockTable); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return a, nil
+}
+
+// readTable reads and decrypts the given encrypted table of 16 byte entries.
+func (a *Archive) readTable(offset int64, nentries uint32, keyName string) ([]byte, error) {
+	buf := make([]byte, 16*int(nentries))
+	if _, err := a.r.ReadAt(buf, a.offset+offset); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	decryptBytes(buf, hashString(keyName, hashFileKey))
+	return buf, nil
+}
+
+// Close closes the underlying file of the MPQ archive.
+func (a *Archive) Close() error {
+	if a.f == nil {
+		return nil
+	}
+	return a.f.Close()
+}
+
+// Exists reports whether the given file exists within the MPQ archive.
+func (a *Archive) Exists(name string) bool {
+	_, ok := a.lookup(name)
+	return ok
+}
+
+// lookup returns the block table entry of the given file.
+func (a *Archive) lookup(name string) (blockEntry, bool) {
+	n := uint32(len(a.hashTable))
+	if n == 0 {
+		return blockEntry{}, false
+	}
+	name = strings.Replace(name, "/", `\`, -1)
+	nameA := hashString(name, hashNameA)
+	nameB := hashString(name, hashNameB)
+	start := hashString(name, hashTableOffset) % n
+	for i := uint32(0); i < n; i++ {
+		entry := a.hashTable[(start+i)%n]
+		if entry.BlockIndex == blockIndexEmpty {
+			break
+		}
+		if entry.BlockIndex == blockIndexDeleted {
+			continue
+		}
+		if entry.NameA == nameA && entry.NameB == nameB && int(entry.BlockIndex) < len(a.blockTable) {
+			block := a.blockTable[entry.BlockIndex]
+			if block.Flags&flagExists == 0 {
+				return blockEntry{}, false
+			}
+			return block, true
+		}
+	}
+	return blockEntry{}, false
+}
+
+// ReadFile reads the contents of the given file of the MPQ archive. ReadFile
+// implements fs.ReadFileFS.
+func (a *Archive) ReadFile(name string) ([]byte, error) {
+	block, ok := a.lookup(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	buf, err := a.readBlock(name, block)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read %q", name)
+	}
+	return buf, nil
+}
+
+// readBlock reads the contents of the given file.
+func (a *Archive) readBlock(name string, block blockEntry) ([]byte, error) {
+	var key uint32
+	if block.Flags&flagEncrypted != 0 {
+		base := name
+		if pos := strings.LastIndexAny(name, `\/`); pos != -1 {
+			base = name[pos+1:]
+		}
+		key = hashString(base, hashFileKey)
+		if block.Flags&flagFixKey != 0 {
+			key = (key + block.FilePos) ^ block.FileSize
+		}
+	}
+	raw := make([]byte, block.CompressedSize)
+	if _, err := a.r.ReadAt(raw, a.offset+int64(block.FilePos)); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	compressed := block.Flags&(flagImplode|flagCompress) != 0
+	if block.Flags&flagSingleUnit != 0 {
+		if block.Flags&flagEncrypted != 0 {
+			decryptBytes(raw, key)
+		}
+		if compressed && block.CompressedSize < block.FileSize {
+			return decompress(raw, block.Flags)
+		}
+		return raw, nil
+	}
+	// Locate sectors of the file.
+	nsectors := (int(block.FileSize) + a.sectorSize - 1) / a.sectorSize
+	offsets := make([]uint32, nsectors+1)
+	if compressed {
+		if len(raw) < 4*len(offsets) {
+			return nil, errors.Errorf("invalid sector offset table size; expected >= %d, got %d", 4*len(offsets), len(raw))
+		}
+		table := make([]byte, 4*len(offsets))
+		copy(table, raw)
+		if block.Flags&flagEncrypted != 0 {
+			decryptBytes(table, key-1)
+		}
+		for i := range offsets {
+			offsets[i] = binary.LittleEndian.Uint32(table[4*i:])
+		}
+	} else {
+		for i := range offsets {
+			offsets[i] = uint32(i * a.sectorSize)
+		}
+		offsets[nsectors] = block.FileSize
+	}
+	out := make([]byte, 0, block.FileSize)
+	for i := 0; i < nsectors; i++ {
+		start, end := offsets[i], offsets[i+1]
+		if start > end || int(end) > len(raw) {
+			return nil, errors.Errorf("invalid offset of sector %d; [%d:%d] outside of %d bytes", i, start, end, len(raw))
+		}
+		sector := raw[start:end]
+		if block.Flags&flagEncrypted != 0 {
+			decryptBytes(sector, key+uint32(i))
+		}
+		// Uncompressed size of sector.
+		size := a.sectorSize
+		if i == nsectors-1 {
+			size = int(block.FileSize) - i*a.sectorSize
+		}
+		if compressed && len(sector) < size {
+			data, err := decompress(sector, block.Flags)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to decompress sector %d", i)
+			}
+			sector = data
+		}
+		out = append(out, sector...)
+	}
+	return out, nil
+}
+
+// decompress decompresses the given data, based on the file flags.
+func decompress(buf []byte, flags uint32) ([]byte, error) {
+	if flags&flagImplode != 0 {
+		return explode(buf)
+	}
+	if len(buf) == 0 {
+		return nil, errors.New("missing compression method")
+	}
+	method, data := buf[0], buf[1:]
+	switch method {
+	case compressImplode:
+		return explode(data)
+	case compressZlib:
+		return inflate(data)
+	default:
+		return nil, errors.Errorf("support for compression method 0x%02X not yet implemented", method)
+	}
+}
+
+// inflate decompresses the given zlib compressed data.
+func inflate(buf []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(buf))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer zr.Close()
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return data, nil
+}
+
+// Open opens the given file of the MPQ archive. Open implements fs.FS.
+func (a *Archive) Open(name string) (fs.File, error) {
+	buf, err := a.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	f := &file{
+		Reader: bytes.NewReader(buf),
+		info: fileInfo{
+			name: path.Base(strings.Replace(name, `\`, "/", -1)),
+			size: int64(len(buf)),
+		},
+	}
+	return f, nil
+}
+
+// file is a file of an MPQ archive.
+type file struct {
+	*bytes.Reader
+	// File information.
+	info fileInfo
+}
+
+// Stat returns the file information of the file.
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Close closes the file.
+func (f *file) Close() error {
+	return nil
+}
+
+// fileInfo is the file information of a file of an MPQ archive.
+type fileInfo struct {
+	// Base name of the file.
+	name string
+	// Uncompressed size of the file.
+	size int64
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() fs.FileMode  { return 0444 }
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() interface{}   { return nil }
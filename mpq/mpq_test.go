@@ -0,0 +1,256 @@
+package mpq
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"io/fs"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// update specifies whether to update the synthetic MPQ archive of testdata.
+var update = flag.Bool("update", false, "update testdata/diabdat.mpq")
+
+// testFile is a file of a synthetic MPQ archive.
+type testFile struct {
+	// File name.
+	name string
+	// Uncompressed file contents.
+	data []byte
+	// File flags; flagImplode and flagCompress specify the compression method
+	// of each sector.
+	flags uint32
+}
+
+// imploded is the PKWARE DCL implode compressed version of "AIAIAIAIAIAIA";
+// from the example of blast.c by Mark Adler.
+var imploded = []byte{0x00, 0x04, 0x82, 0x24, 0x25, 0x8F, 0x80, 0x7F}
+
+// testFiles returns the files of the synthetic MPQ archive.
+func testFiles() []testFile {
+	plain := make([]byte, 1000)
+	for i := range plain {
+		plain[i] = byte(i * 7)
+	}
+	text := bytes.Repeat([]byte("The Butcher. "), 60)
+	return []testFile{
+		// Uncompressed and encrypted; two sectors.
+		{name: `levels\l1data\l1.sol`, data: plain, flags: flagExists | flagEncrypted},
+		// Imploded; one sector.
+		{name: `data\implode.txt`, data: []byte("AIAIAIAIAIAIA"), flags: flagExists | flagImplode},
+		// zlib compressed and encrypted with a key adjusted by file position and
+		// size; two sectors.
+		{name: `data\zlib.txt`, data: text, flags: flagExists | flagCompress | flagEncrypted | flagFixKey},
+	}
+}
+
+// encryptBytes encrypts the given data in place, using the specified key; the
+// inverse of decryptBytes.
+func encryptBytes(buf []byte, key uint32) {
+	seed := uint32(0xEEEEEEEE)
+	for i := 0; i+4 <= len(buf); i += 4 {
+		seed += cryptTable[0x400+(key&0xFF)]
+		ch := binary.LittleEndian.Uint32(buf[i:])
+		binary.LittleEndian.PutUint32(buf[i:], ch^(key+seed))
+		key = ((^key << 0x15) + 0x11111111) | (key >> 0x0B)
+		seed = ch + seed + (seed << 5) + 3
+	}
+}
+
+// writeArchive returns a synthetic MPQ archive containing the given files, with
+// a sector size of 512 bytes.
+func writeArchive(t *testing.T, files []testFile) []byte {
+	const sectorSize = 512
+	buf := &bytes.Buffer{}
+	// Placeholder for header.
+	buf.Write(make([]byte, 32))
+	var blocks []blockEntry
+	for _, f := range files {
+		pos := uint32(buf.Len())
+		base := f.name[strings.LastIndex(f.name, `\`)+1:]
+		key := hashString(base, hashFileKey)
+		if f.flags&flagFixKey != 0 {
+			key = (key + pos) ^ uint32(len(f.data))
+		}
+		// Split file into sectors.
+		var sectors [][]byte
+		for i := 0; i < len(f.data); i += sectorSize {
+			end := i + sectorSize
+			if end > len(f.data) {
+				end = len(f.data)
+			}
+			sector := append([]byte(nil), f.data[i:end]...)
+			switch {
+			case f.flags&flagImplode != 0:
+				if string(sector) != "AIAIAIAIAIAIA" {
+					t.Fatalf("support for imploding %q not implemented", sector)
+				}
+				sector = append([]byte(nil), imploded...)
+			case f.flags&flagCompress != 0:
+				zbuf := &bytes.Buffer{}
+				zbuf.WriteByte(compressZlib)
+				zw := zlib.NewWriter(zbuf)
+				if _, err := zw.Write(sector); err != nil {
+					t.Fatal(err)
+				}
+				if err := zw.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if zbuf.Len() >= len(sector) {
+					t.Fatalf("zlib compressed sector of %q not smaller than uncompressed", f.name)
+				}
+				sector = zbuf.Bytes()
+			}
+			if f.flags&flagEncrypted != 0 {
+				encryptBytes(sector, key+uint32(len(sectors)))
+			}
+			sectors = append(sectors, sector)
+		}
+		// Sector offset table of compressed files.
+		if f.flags&(flagImplode|flagCompress) != 0 {
+			table := make([]byte, 4*(len(sectors)+1))
+			offset := len(table)
+			for i, sector := range sectors {
+				binary.LittleEndian.PutUint32(table[4*i:], uint32(offset))
+				offset += len(sector)
+			}
+			binary.LittleEndian.PutUint32(table[4*len(sectors):], uint32(offset))
+			if f.flags&flagEncrypted != 0 {
+				encryptBytes(table, key-1)
+			}
+			buf.Write(table)
+		}
+		for _, sector := range sectors {
+			buf.Write(sector)
+		}
+		block := blockEntry{
+			FilePos:        pos,
+			CompressedSize: uint32(buf.Len()) - pos,
+			FileSize:       uint32(len(f.data)),
+			Flags:          f.flags,
+		}
+		blocks = append(blocks, block)
+	}
+	// Hash table.
+	const nhashes = 8
+	hashes := make([]hashEntry, nhashes)
+	for i := range hashes {
+		hashes[i].BlockIndex = blockIndexEmpty
+	}
+	for blockIndex, f := range files {
+		i := hashString(f.name, hashTableOffset) % nhashes
+		for hashes[i].BlockIndex != blockIndexEmpty {
+			i = (i + 1) % nhashes
+		}
+		hashes[i] = hashEntry{
+			NameA:      hashString(f.name, hashNameA),
+			NameB:      hashString(f.name, hashNameB),
+			BlockIndex: uint32(blockIndex),
+		}
+	}
+	writeTable := func(table interface{}, keyName string) uint32 {
+		offset := uint32(buf.Len())
+		tbuf := &bytes.Buffer{}
+		if err := binary.Write(tbuf, binary.LittleEndian, table); err != nil {
+			t.Fatal(err)
+		}
+		data := tbuf.Bytes()
+		encryptBytes(data, hashString(keyName, hashFileKey))
+		buf.Write(data)
+		return offset
+	}
+	hdr := header{
+		Magic:            [4]byte{'M', 'P', 'Q', 0x1A},
+		HeaderSize:       32,
+		HashTableOffset:  writeTable(hashes, "(hash table)"),
+		BlockTableOffset: writeTable(blocks, "(block table)"),
+		NHashEntries:     nhashes,
+		NBlockEntries:    uint32(len(blocks)),
+	}
+	hdr.ArchiveSize = uint32(buf.Len())
+	data := buf.Bytes()
+	hbuf := &bytes.Buffer{}
+	if err := binary.Write(hbuf, binary.LittleEndian, hdr); err != nil {
+		t.Fatal(err)
+	}
+	copy(data, hbuf.Bytes())
+	return data
+}
+
+func TestArchive(t *testing.T) {
+	data := writeArchive(t, testFiles())
+	if *update {
+		if err := ioutil.WriteFile("testdata/diabdat.mpq", data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Archive located at a 512 byte boundary, preceded by other data.
+	prefixed := append(make([]byte, 1024), data...)
+	for _, buf := range [][]byte{data, prefixed} {
+		a, err := NewArchive(bytes.NewReader(buf))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		checkArchive(t, a)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	a, err := Open("testdata/diabdat.mpq")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer a.Close()
+	checkArchive(t, a)
+}
+
+// checkArchive checks the contents of the given synthetic MPQ archive.
+func checkArchive(t *testing.T, a *Archive) {
+	for _, f := range testFiles() {
+		got, err := a.ReadFile(f.name)
+		if err != nil {
+			t.Errorf("%q: %+v", f.name, err)
+			continue
+		}
+		if !bytes.Equal(got, f.data) {
+			t.Errorf("%q: contents mismatch; expected %d bytes, got %d bytes", f.name, len(f.data), len(got))
+		}
+	}
+	// File names are case-insensitive, with both forward slashes and
+	// backslashes as path separators.
+	if !a.Exists("Levels/L1Data/L1.SOL") {
+		t.Errorf("%q: expected to exist", "Levels/L1Data/L1.SOL")
+	}
+	if a.Exists(`levels\l1data\l1.min`) {
+		t.Errorf("%q: expected to not exist", `levels\l1data\l1.min`)
+	}
+	if _, err := a.ReadFile(`levels\l1data\l1.min`); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("%q: expected fs.ErrNotExist, got %v", `levels\l1data\l1.min`, err)
+	}
+	// fs.FS interface.
+	got, err := fs.ReadFile(a, "data/implode.txt")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(got) != "AIAIAIAIAIAIA" {
+		t.Errorf("%q: expected %q, got %q", "data/implode.txt", "AIAIAIAIAIAIA", got)
+	}
+}
+
+func TestExplode(t *testing.T) {
+	got, err := explode(imploded)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(got) != "AIAIAIAIAIAIA" {
+		t.Errorf("expected %q, got %q", "AIAIAIAIAIAIA", got)
+	}
+	// Truncated input.
+	if _, err := explode(imploded[:4]); err == nil {
+		t.Errorf("expected error for truncated input, got nil")
+	}
+}
@@ -0,0 +1,113 @@
+// Package trans locates the transparent walls of Diablo 1 dungeon pieces (i.e.
+// miniture tiles), and the transparency regions of maps.
+//
+// Diablo 1 renders walls in front of the player as see-through when the player
+// is located within the transparency region of the walls. The transparency
+// regions are determined by flood filling the floor of the map, delimited by
+// walls and doors; each transparent wall belongs to the region of the floor
+// behind it.
+//
+// ref: DRLG_FloodTVal
+package trans
+
+import (
+	"image"
+
+	"github.com/sanctuary/ember/collision"
+)
+
+// IsTransparent reports whether the given dungeon piece is a transparent wall,
+// based on the contents of <dtype>.SOL.
+func IsTransparent(sol []byte, dpieceID int) bool {
+	if dpieceID < 1 || dpieceID > len(sol) {
+		return false
+	}
+	return sol[dpieceID-1]&collision.Sol08 != 0
+}
+
+// DPieceIDs returns the IDs of the transparent wall dungeon pieces, in
+// increasing order.
+func DPieceIDs(sol []byte) []int {
+	var dpieceIDs []int
+	for dpieceID := 1; dpieceID <= len(sol); dpieceID++ {
+		if IsTransparent(sol, dpieceID) {
+			dpieceIDs = append(dpieceIDs, dpieceID)
+		}
+	}
+	return dpieceIDs
+}
+
+// Regions returns the transparency region of each cell of the given map of
+// dungeon pieces, indexed by x and y coordinate. Regions are numbered from 1 in
+// the order they are located, and cells outside of any region are 0.
+//
+// Note, the regions are an approximation of the transparency regions of Diablo
+// 1, which are assigned while generating the dungeon layout. Open areas
+// connected without doors form a single region.
+func Regions(dpieces [][]int, sol []byte, isDoor func(dpieceID int) bool) [][]int {
+	width := len(dpieces)
+	height := 0
+	if width > 0 {
+		height = len(dpieces[0])
+	}
+	regions := make([][]int, width)
+	for x := range regions {
+		regions[x] = make([]int, height)
+	}
+	inside := func(pt image.Point) bool {
+		return pt.X >= 0 && pt.X < width && pt.Y >= 0 && pt.Y < height
+	}
+	isFloor := func(pt image.Point) bool {
+		dpieceID := dpieces[pt.X][pt.Y]
+		if dpieceID < 1 || dpieceID > len(sol) || isDoor(dpieceID) {
+			return false
+		}
+		return sol[dpieceID-1]&collision.SolBlockWalk == 0
+	}
+	// Flood fill floor.
+	dirs := []image.Point{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+	region := 0
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			start := image.Pt(x, y)
+			if regions[x][y] != 0 || !isFloor(start) {
+				continue
+			}
+			region++
+			regions[x][y] = region
+			queue := []image.Point{start}
+			for len(queue) > 0 {
+				pt := queue[0]
+				queue = queue[1:]
+				for _, dir := range dirs {
+					next := pt.Add(dir)
+					if !inside(next) || regions[next.X][next.Y] != 0 || !isFloor(next) {
+						continue
+					}
+					regions[next.X][next.Y] = region
+					queue = append(queue, next)
+				}
+			}
+		}
+	}
+	// Assign transparent walls to the region of the floor behind them (i.e.
+	// towards the top of the screen).
+	behind := []image.Point{{-1, 0}, {0, -1}, {-1, -1}}
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			pt := image.Pt(x, y)
+			if !IsTransparent(sol, dpieces[x][y]) || isFloor(pt) {
+				continue
+			}
+			for _, dir := range behind {
+				next := pt.Add(dir)
+				if !inside(next) || !isFloor(next) {
+					continue
+				}
+				regions[x][y] = regions[next.X][next.Y]
+				break
+			}
+		}
+	}
+	return regions
+}
@@ -0,0 +1,203 @@
+package trans_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sanctuary/ember/collision"
+	"github.com/sanctuary/ember/trans"
+)
+
+// Dungeon pieces of a synthetic dungeon type.
+const (
+	// Floor.
+	floor = 1
+	// Wall.
+	wall = 2
+	// Transparent wall.
+	transWall = 3
+	// Door.
+	door = 4
+	// Walkable dungeon piece marked as transparent.
+	transFloor = 5
+)
+
+// sol holds the solid properties of the dungeon pieces of the synthetic dungeon
+// type.
+var sol = []byte{
+	floor - 1:      0x00,
+	wall - 1:       collision.SolBlockWalk | collision.SolBlockMissile,
+	transWall - 1:  collision.SolBlockWalk | collision.SolBlockMissile | collision.Sol08,
+	door - 1:       0x00,
+	transFloor - 1: collision.Sol08,
+}
+
+// isDoor reports whether the given dungeon piece of the synthetic dungeon type
+// is a door.
+func isDoor(dpieceID int) bool {
+	return dpieceID == door
+}
+
+func TestIsTransparent(t *testing.T) {
+	golden := []struct {
+		dpieceID int
+		want     bool
+	}{
+		{dpieceID: floor, want: false},
+		{dpieceID: wall, want: false},
+		{dpieceID: transWall, want: true},
+		{dpieceID: door, want: false},
+		{dpieceID: transFloor, want: true},
+		// Dungeon piece IDs out of range.
+		{dpieceID: 0, want: false},
+		{dpieceID: len(sol) + 1, want: false},
+	}
+	for _, g := range golden {
+		got := trans.IsTransparent(sol, g.dpieceID)
+		if got != g.want {
+			t.Errorf("dungeon piece %d; expected %v, got %v", g.dpieceID, g.want, got)
+		}
+	}
+	want := []int{transWall, transFloor}
+	if got := trans.DPieceIDs(sol); !reflect.DeepEqual(got, want) {
+		t.Errorf("transparent dungeon pieces mismatch; expected %v, got %v", want, got)
+	}
+}
+
+func TestRegions(t *testing.T) {
+	golden := []struct {
+		name string
+		// Map of dungeon pieces, one row per y coordinate; '.' is floor, '#'
+		// wall, 'T' transparent wall, 'D' door, 't' walkable transparent
+		// dungeon piece, ' ' no dungeon piece, and '?' an invalid dungeon
+		// piece.
+		dpieces []string
+		// Expected transparency regions, one row per y coordinate.
+		want [][]int
+	}{
+		{
+			name: "flood fill",
+			dpieces: []string{
+				"..#..",
+				"..#..",
+				"#####",
+				".....",
+			},
+			want: [][]int{
+				{1, 1, 0, 3, 3},
+				{1, 1, 0, 3, 3},
+				{0, 0, 0, 0, 0},
+				{2, 2, 2, 2, 2},
+			},
+		},
+		{
+			// Doors delimit regions, and belong to no region.
+			name: "doors",
+			dpieces: []string{
+				"#####",
+				"#.D.#",
+				"##.##",
+				"##D##",
+				"##.##",
+			},
+			want: [][]int{
+				{0, 0, 0, 0, 0},
+				{0, 1, 0, 4, 0},
+				{0, 0, 2, 0, 0},
+				{0, 0, 0, 0, 0},
+				{0, 0, 3, 0, 0},
+			},
+		},
+		{
+			// Transparent walls belong to the region behind them (i.e. towards
+			// the top of the screen); to the west, north or north-west in map
+			// coordinates, in that order of precedence.
+			name: "walls behind",
+			dpieces: []string{
+				"..#..",
+				"..T..",
+				"###T#",
+				".#...",
+				"T#T#T",
+			},
+			want: [][]int{
+				{1, 1, 0, 4, 4},
+				{1, 1, 1, 4, 4},
+				{0, 0, 0, 4, 0},
+				{2, 0, 3, 3, 3},
+				{2, 0, 3, 0, 3},
+			},
+		},
+		{
+			// Transparent walls without floor behind them belong to no region.
+			name: "walls without floor behind",
+			dpieces: []string{
+				"T#",
+				"#.",
+			},
+			want: [][]int{
+				{0, 0},
+				{0, 1},
+			},
+		},
+		{
+			// Walkable dungeon pieces marked as transparent are floor, and
+			// missing or invalid dungeon pieces delimit regions.
+			name: "special dungeon pieces",
+			dpieces: []string{
+				".t ..",
+				"..?..",
+			},
+			want: [][]int{
+				{1, 1, 0, 2, 2},
+				{1, 1, 0, 2, 2},
+			},
+		},
+	}
+	for _, g := range golden {
+		got := trans.Regions(parseMap(g.dpieces), sol, isDoor)
+		if want := transpose(g.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: regions mismatch; expected %v, got %v", g.name, g.want, transpose(got))
+		}
+	}
+	if got := trans.Regions(nil, sol, isDoor); len(got) != 0 {
+		t.Errorf("empty map: expected no regions, got %v", got)
+	}
+}
+
+// parseMap returns the map of dungeon pieces of the given rows, indexed by x
+// and y coordinate.
+func parseMap(rows []string) [][]int {
+	ids := map[byte]int{
+		'.': floor,
+		'#': wall,
+		'T': transWall,
+		'D': door,
+		't': transFloor,
+		' ': 0,
+		'?': len(sol) + 1,
+	}
+	cells := make([][]int, len(rows))
+	for y, row := range rows {
+		for x := 0; x < len(row); x++ {
+			cells[y] = append(cells[y], ids[row[x]])
+		}
+	}
+	return transpose(cells)
+}
+
+// transpose returns the given cells indexed by y and x coordinate, as indexed by
+// x and y coordinate, and vice versa.
+func transpose(cells [][]int) [][]int {
+	if len(cells) == 0 {
+		return nil
+	}
+	dst := make([][]int, len(cells[0]))
+	for i := range dst {
+		dst[i] = make([]int, len(cells))
+		for j := range cells {
+			dst[i][j] = cells[j][i]
+		}
+	}
+	return dst
+}
@@ -0,0 +1,74 @@
+package vorbis
+
+import (
+	"math"
+)
+
+// A bitWriter packs bits into bytes, least significant bit first, as specified
+// by the Vorbis bitpacking convention.
+type bitWriter struct {
+	// Packed bytes.
+	buf []byte
+	// Number of bits written.
+	n uint
+}
+
+// writeBits writes the n least significant bits of v, least significant bit
+// first.
+func (bw *bitWriter) writeBits(v uint32, n int) {
+	for i := 0; i < n; i++ {
+		if bw.n%8 == 0 {
+			bw.buf = append(bw.buf, 0)
+		}
+		bw.buf[len(bw.buf)-1] |= byte((v>>uint(i))&1) << (bw.n % 8)
+		bw.n++
+	}
+}
+
+// writeBool writes the given flag as one bit.
+func (bw *bitWriter) writeBool(v bool) {
+	if v {
+		bw.writeBits(1, 1)
+	} else {
+		bw.writeBits(0, 1)
+	}
+}
+
+// writeString writes the bytes of the given string.
+func (bw *bitWriter) writeString(s string) {
+	for i := 0; i < len(s); i++ {
+		bw.writeBits(uint32(s[i]), 8)
+	}
+}
+
+// writeFloat writes the given value in the 32-bit float format of Vorbis; a
+// 21-bit mantissa, 10-bit exponent (biased by 788) and sign bit.
+func (bw *bitWriter) writeFloat(f float64) {
+	var sign uint32
+	if f < 0 {
+		sign = 1
+		f = -f
+	}
+	mant, exp := math.Frexp(f)
+	mantissa := uint32(mant * (1 << 21))
+	exponent := uint32(exp - 21 + 788)
+	if mantissa == 0 {
+		exponent = 0
+	}
+	bw.writeBits(sign<<31|exponent<<21|mantissa, 32)
+}
+
+// bytes returns the packed bytes.
+func (bw *bitWriter) bytes() []byte {
+	return bw.buf
+}
+
+// ilog returns the number of bits required to represent v; i.e. the position of
+// the highest set bit.
+func ilog(v int) int {
+	n := 0
+	for ; v > 0; v >>= 1 {
+		n++
+	}
+	return n
+}
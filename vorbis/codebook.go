@@ -0,0 +1,254 @@
+package vorbis
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// A codebook is a Huffman codebook of a Vorbis stream, mapping entries to
+// codewords. The codeword lengths are computed from the number of occurrences
+// of each entry in the stream.
+type codebook struct {
+	// Number of scalars of each entry.
+	dims int
+	// Number of entries.
+	nentries int
+	// Specifies whether the entries map to a lattice of vector values (lookup
+	// type 1); otherwise, the codebook is only used to decode scalar entry
+	// numbers.
+	lattice bool
+	// Value of the first lattice point of each dimension.
+	min float64
+	// Distance between lattice points of each dimension.
+	delta float64
+	// Number of occurrences of each entry.
+	counts []int
+	// Codeword length in bits of each entry; 0 if unused.
+	lengths []int
+	// Codeword of each entry, most significant bit first.
+	codewords []uint32
+}
+
+// newCodebook returns a new codebook of the given number of entries and
+// dimensions.
+func newCodebook(nentries, dims int) *codebook {
+	return &codebook{
+		dims:     dims,
+		nentries: nentries,
+		counts:   make([]int, nentries),
+	}
+}
+
+// newLatticeCodebook returns a new lattice codebook of the given dimensions,
+// with nvalues lattice points of each dimension, starting at min and separated
+// by delta.
+func newLatticeCodebook(nvalues, dims int, min, delta float64) *codebook {
+	nentries := 1
+	for i := 0; i < dims; i++ {
+		nentries *= nvalues
+	}
+	book := newCodebook(nentries, dims)
+	book.lattice = true
+	book.min = min
+	book.delta = delta
+	return book
+}
+
+// nvalues returns the number of lattice points of each dimension.
+func (book *codebook) nvalues() int {
+	n := 1
+	for {
+		m := 1
+		for i := 0; i < book.dims; i++ {
+			m *= n + 1
+		}
+		if m > book.nentries {
+			return n
+		}
+		n++
+	}
+}
+
+// build computes the codeword lengths and codewords of the codebook from the
+// number of occurrences of each entry. Unused entries are given no codeword.
+func (book *codebook) build() error {
+	counts := make([]int, book.nentries)
+	copy(counts, book.counts)
+	// Decoders require at least two used entries; fill up with pseudo
+	// occurrences of unused entries.
+	nused := 0
+	for _, count := range counts {
+		if count > 0 {
+			nused++
+		}
+	}
+	for i := 0; i < len(counts) && nused < 2; i++ {
+		if counts[i] == 0 {
+			counts[i] = 1
+			nused++
+		}
+	}
+	// Limit codeword lengths to 32 bits by flattening the distribution.
+	for {
+		book.lengths = huffmanLengths(counts)
+		max := 0
+		for _, length := range book.lengths {
+			if length > max {
+				max = length
+			}
+		}
+		if max <= 32 {
+			break
+		}
+		for i, count := range counts {
+			if count > 0 {
+				counts[i] = (count + 1) / 2
+			}
+		}
+	}
+	codewords, err := makeCodewords(book.lengths)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	book.codewords = codewords
+	return nil
+}
+
+// huffmanLengths returns the Huffman codeword lengths of the given number of
+// occurrences of each entry; 0 for entries without occurrences.
+func huffmanLengths(counts []int) []int {
+	// A node is a node of the Huffman tree.
+	type node struct {
+		// Accumulated number of occurrences.
+		count int
+		// Parent node index; -1 for the root.
+		parent int
+	}
+	var nodes []node
+	var live []int
+	leaves := make([]int, len(counts))
+	for i, count := range counts {
+		leaves[i] = -1
+		if count > 0 {
+			leaves[i] = len(nodes)
+			live = append(live, len(nodes))
+			nodes = append(nodes, node{count: count, parent: -1})
+		}
+	}
+	for len(live) > 1 {
+		// Merge the two least frequent nodes.
+		sort.SliceStable(live, func(i, j int) bool {
+			return nodes[live[i]].count < nodes[live[j]].count
+		})
+		a, b := live[0], live[1]
+		parent := len(nodes)
+		nodes = append(nodes, node{count: nodes[a].count + nodes[b].count, parent: -1})
+		nodes[a].parent = parent
+		nodes[b].parent = parent
+		live = append(live[2:], parent)
+	}
+	lengths := make([]int, len(counts))
+	for i, leaf := range leaves {
+		if leaf == -1 {
+			continue
+		}
+		for n := leaf; nodes[n].parent != -1; n = nodes[n].parent {
+			lengths[i]++
+		}
+	}
+	return lengths
+}
+
+// makeCodewords returns the codewords of the given codeword lengths, as
+// assigned by Vorbis decoders; each entry, in order, is given the lowest
+// valued available codeword of its length.
+func makeCodewords(lengths []int) ([]uint32, error) {
+	codewords := make([]uint32, len(lengths))
+	// marker[i] is the next available codeword of length i.
+	var marker [33]uint32
+	for i, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		entry := marker[length]
+		if length < 32 && entry>>uint(length) != 0 {
+			return nil, errors.Errorf("overpopulated Huffman tree at entry %d", i)
+		}
+		codewords[i] = entry
+		// Update the available codewords of shorter lengths.
+		for j := length; j > 0; j-- {
+			if marker[j]&1 != 0 {
+				if j == 1 {
+					marker[1]++
+				} else {
+					marker[j] = marker[j-1] << 1
+				}
+				break
+			}
+			marker[j]++
+		}
+		// Prune the available codewords of longer lengths.
+		for j := length + 1; j < 33; j++ {
+			if marker[j]>>1 != entry {
+				break
+			}
+			entry = marker[j]
+			marker[j] = marker[j-1] << 1
+		}
+	}
+	return codewords, nil
+}
+
+// writeHeader writes the codebook configuration of the setup header.
+func (book *codebook) writeHeader(bw *bitWriter) {
+	bw.writeBits(0x564342, 24) // sync pattern "BCV"
+	bw.writeBits(uint32(book.dims), 16)
+	bw.writeBits(uint32(book.nentries), 24)
+	bw.writeBool(false) // ordered
+	sparse := false
+	for _, length := range book.lengths {
+		if length == 0 {
+			sparse = true
+		}
+	}
+	bw.writeBool(sparse)
+	for _, length := range book.lengths {
+		if sparse {
+			bw.writeBool(length > 0)
+			if length == 0 {
+				continue
+			}
+		}
+		bw.writeBits(uint32(length-1), 5)
+	}
+	if !book.lattice {
+		bw.writeBits(0, 4) // lookup type
+		return
+	}
+	nvalues := book.nvalues()
+	bw.writeBits(1, 4) // lookup type
+	bw.writeFloat(book.min)
+	bw.writeFloat(book.delta)
+	valueBits := ilog(nvalues - 1)
+	bw.writeBits(uint32(valueBits-1), 4)
+	bw.writeBool(false) // sequence
+	for i := 0; i < nvalues; i++ {
+		bw.writeBits(uint32(i), valueBits)
+	}
+}
+
+// write writes the codeword of the given entry. Before the codebook is built,
+// the occurrence of the entry is counted instead.
+func (book *codebook) write(bw *bitWriter, entry int) {
+	if book.codewords == nil {
+		book.counts[entry]++
+		return
+	}
+	length := book.lengths[entry]
+	codeword := book.codewords[entry]
+	// Codewords are read one bit at the time, most significant bit first.
+	for i := length - 1; i >= 0; i-- {
+		bw.writeBits(codeword>>uint(i), 1)
+	}
+}
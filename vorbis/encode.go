@@ -0,0 +1,289 @@
+// Package vorbis implements an Ogg Vorbis encoder.
+//
+// The encoder is deliberately simple; it uses long blocks only, without a
+// psychoacoustic model. The spectral envelope (floor) of each block follows
+// the energy of the spectrum, and the residue is quantized in proportion to
+// the floor, with coarser quantization of higher frequencies and of spectral
+// coefficients far below the peak amplitude of the block. The Huffman
+// codebooks are computed for each stream from the quantized values, thus the
+// stream is encoded in two passes.
+//
+// Specification:
+//
+//    https://xiph.org/vorbis/doc/Vorbis_I_spec.html
+package vorbis
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Block sizes in samples; only long blocks are used, as no transients are
+// detected.
+const (
+	blockSize0 = 256
+	blockSize1 = 2048
+)
+
+// Encoder settings.
+const (
+	// Quantization step of residues, relative to the RMS amplitude of the
+	// spectrum around each floor point; lower values give higher quality and
+	// larger files.
+	noise = 0.2
+	// Lowest floor amplitude relative to the peak amplitude of the block;
+	// spectral coefficients 60 dB below the peak are considered inaudible.
+	dynamicRange = 1e-3
+	// Lowest floor amplitude, in the order of the quantization noise of 16-bit
+	// samples.
+	minFloor = 1e-6
+	// Channels of blocks with all spectral coefficients below this amplitude
+	// are encoded as silent.
+	silence = 1e-5
+)
+
+// vendor is the vendor string of the comment header.
+const vendor = "github.com/sanctuary/ember/vorbis"
+
+// serial is the serial number of the logical bitstream.
+const serial = 0x454D4252 // "EMBR"
+
+// Encode encodes the given signed 16-bit PCM samples, interleaved by channel,
+// as an Ogg Vorbis stream written to w. The comments (e.g. "TITLE=Tristram")
+// are stored in the comment header. An empty sound is encoded as a single
+// silent sample frame.
+func Encode(w io.Writer, samples []int16, nchannels, sampleRate int, comments []string) error {
+	if nchannels < 1 || nchannels > 255 {
+		return errors.Errorf("invalid number of channels; expected 1-255, got %d", nchannels)
+	}
+	if sampleRate < 1 {
+		return errors.Errorf("invalid sample rate; expected > 0, got %d", sampleRate)
+	}
+	if len(samples) < nchannels {
+		// Decoders reject streams without samples, as the first audio packet of
+		// a stream returns no samples and the last is cut short by the final
+		// granule position. An empty sound is thus encoded as a single silent
+		// sample frame, spanning two blocks.
+		samples = make([]int16, nchannels)
+	}
+	enc := newEncoder(nchannels)
+	blocks := enc.analyze(samples)
+	// Count codebook entries of the audio packets, to compute codewords.
+	for _, blk := range blocks {
+		enc.writeAudio(&bitWriter{}, blk)
+	}
+	for _, book := range enc.books() {
+		if err := book.build(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	// Write headers.
+	ow := newOggWriter(w, serial)
+	if err := ow.writePacket(identHeader(nchannels, sampleRate), 0); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ow.flush(false); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ow.writePacket(commentHeader(comments), 0); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ow.writePacket(enc.setupHeader(), 0); err != nil {
+		return errors.WithStack(err)
+	}
+	// Audio packets start on a new page.
+	if err := ow.flush(false); err != nil {
+		return errors.WithStack(err)
+	}
+	// Write audio packets. Each packet completes the samples of the first half
+	// of its block.
+	nframes := int64(len(samples) / nchannels)
+	for i, blk := range blocks {
+		bw := &bitWriter{}
+		enc.writeAudio(bw, blk)
+		granule := int64(i) * blockSize1 / 2
+		if granule > nframes {
+			granule = nframes
+		}
+		if err := ow.writePacket(bw.bytes(), granule); err != nil {
+			return errors.WithStack(err)
+		}
+		last := i == len(blocks)-1
+		if last || len(ow.data) >= 4096 {
+			if err := ow.flush(last); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+	return nil
+}
+
+// An encoder is a Vorbis encoder.
+type encoder struct {
+	// Number of channels.
+	nchannels int
+	// Forward MDCT of long blocks.
+	mdct *mdct
+	// Codebook of floor amplitudes.
+	floorBook *codebook
+	// Codebooks of residues.
+	residueBooks *residueBooks
+}
+
+// newEncoder returns a new Vorbis encoder of the given number of channels.
+func newEncoder(nchannels int) *encoder {
+	return &encoder{
+		nchannels:    nchannels,
+		mdct:         newMDCT(blockSize1),
+		floorBook:    newCodebook(floorRange, 1),
+		residueBooks: newResidueBooks(),
+	}
+}
+
+// books returns the codebooks of the encoder, in codebook number order.
+func (enc *encoder) books() []*codebook {
+	return []*codebook{enc.floorBook, enc.residueBooks.class, enc.residueBooks.fine, enc.residueBooks.coarse}
+}
+
+// A block is an analyzed block of audio samples.
+type block struct {
+	// Floor of each channel.
+	floors []*floor
+	// Residue of each channel.
+	residues []*residue
+}
+
+// analyze analyzes the blocks of the given samples. Blocks overlap by half; the
+// first block starts half a block before the first sample, and the last block
+// ends after the last sample.
+func (enc *encoder) analyze(samples []int16) []*block {
+	const n = blockSize1
+	nframes := len(samples) / enc.nchannels
+	nblocks := (nframes+n/2-1)/(n/2) + 1
+	blocks := make([]*block, nblocks)
+	x := make([]float64, n)
+	coeffs := make([]float64, n/2)
+	for i := range blocks {
+		blk := &block{
+			floors:   make([]*floor, enc.nchannels),
+			residues: make([]*residue, enc.nchannels),
+		}
+		start := (i - 1) * n / 2
+		for ch := 0; ch < enc.nchannels; ch++ {
+			for j := range x {
+				x[j] = 0
+				if pos := start + j; pos >= 0 && pos < nframes {
+					x[j] = float64(samples[pos*enc.nchannels+ch]) / 32768
+				}
+			}
+			enc.mdct.transform(coeffs, x)
+			f := analyzeFloor(coeffs)
+			blk.floors[ch] = f
+			if !f.unused {
+				blk.residues[ch] = quantizeResidue(coeffs, f.curve)
+			}
+		}
+		blocks[i] = blk
+	}
+	return blocks
+}
+
+// writeAudio writes the audio packet of the given block. Before the codebooks
+// are built, codebook entries are counted instead of written.
+func (enc *encoder) writeAudio(bw *bitWriter, blk *block) {
+	bw.writeBits(0, 1) // packet type
+	// Mode number; zero bits, as there is only one mode.
+	bw.writeBool(true) // previous window flag
+	bw.writeBool(true) // next window flag
+	for _, f := range blk.floors {
+		f.write(bw, enc.floorBook)
+	}
+	writeResidues(bw, blk.floors, blk.residues, enc.residueBooks)
+}
+
+// identHeader returns the identification header of the given number of
+// channels and sample rate.
+func identHeader(nchannels, sampleRate int) []byte {
+	bw := &bitWriter{}
+	bw.writeBits(1, 8) // packet type
+	bw.writeString("vorbis")
+	bw.writeBits(0, 32) // version
+	bw.writeBits(uint32(nchannels), 8)
+	bw.writeBits(uint32(sampleRate), 32)
+	bw.writeBits(0, 32) // maximum bitrate
+	bw.writeBits(0, 32) // nominal bitrate
+	bw.writeBits(0, 32) // minimum bitrate
+	bw.writeBits(uint32(ilog(blockSize0-1)), 4)
+	bw.writeBits(uint32(ilog(blockSize1-1)), 4)
+	bw.writeBool(true) // framing
+	return bw.bytes()
+}
+
+// commentHeader returns the comment header of the given comments.
+func commentHeader(comments []string) []byte {
+	bw := &bitWriter{}
+	bw.writeBits(3, 8) // packet type
+	bw.writeString("vorbis")
+	bw.writeBits(uint32(len(vendor)), 32)
+	bw.writeString(vendor)
+	bw.writeBits(uint32(len(comments)), 32)
+	for _, comment := range comments {
+		bw.writeBits(uint32(len(comment)), 32)
+		bw.writeString(comment)
+	}
+	bw.writeBool(true) // framing
+	return bw.bytes()
+}
+
+// setupHeader returns the setup header of the encoder; its codebooks must be
+// built.
+func (enc *encoder) setupHeader() []byte {
+	bw := &bitWriter{}
+	bw.writeBits(5, 8) // packet type
+	bw.writeString("vorbis")
+	// Codebooks; floor amplitudes (0), residue classes (1), fine residues (2)
+	// and coarse residues (3).
+	books := enc.books()
+	bw.writeBits(uint32(len(books)-1), 8)
+	for _, book := range books {
+		book.writeHeader(bw)
+	}
+	// Time domain transforms; placeholder.
+	bw.writeBits(0, 6)
+	bw.writeBits(0, 16)
+	// Floors.
+	bw.writeBits(0, 6)
+	writeFloorHeader(bw, 0)
+	// Residues.
+	bw.writeBits(0, 6)
+	writeResidueHeader(bw, blockSize1/2, 1, 2, 3)
+	// Mappings; one submap of all channels, without channel coupling.
+	bw.writeBits(0, 6)
+	bw.writeBits(0, 16) // mapping type
+	bw.writeBool(false) // submaps
+	bw.writeBool(false) // coupling
+	bw.writeBits(0, 2)  // reserved
+	bw.writeBits(0, 8)  // time configuration; unused
+	bw.writeBits(0, 8)  // floor number
+	bw.writeBits(0, 8)  // residue number
+	// Modes; one mode of long blocks.
+	bw.writeBits(0, 6)
+	bw.writeBool(true)  // block flag
+	bw.writeBits(0, 16) // window type
+	bw.writeBits(0, 16) // transform type
+	bw.writeBits(0, 8)  // mapping number
+	bw.writeBool(true)  // framing
+	return bw.bytes()
+}
+
+// clamp returns x clamped to the range [min, max].
+func clamp(x, min, max int) int {
+	switch {
+	case x < min:
+		return min
+	case x > max:
+		return max
+	}
+	return x
+}
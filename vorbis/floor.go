@@ -0,0 +1,275 @@
+package vorbis
+
+import (
+	"math"
+	"sort"
+)
+
+// Floor configuration (floor type 1). The spectral envelope of each channel is
+// a piecewise linear curve in the dB domain, through the amplitudes of the
+// points of floorX.
+const (
+	// Amplitude multiplier of floor points.
+	floorMultiplier = 2
+	// Range of floor point amplitudes, as given by the multiplier.
+	floorRange = 128
+	// Number of bits of floor point positions.
+	floorRangeBits = 10
+	// Number of floor partitions.
+	floorPartitions = 8
+	// Number of floor points of each partition.
+	floorPartitionDims = 4
+)
+
+// floorX specifies the positions of the floor points, in spectral coefficients
+// (the first two points span the spectrum of long blocks). Points are densest at
+// low frequencies.
+var floorX = []int{
+	0, 1024,
+	4, 8, 12, 16, 20, 24, 30, 36,
+	42, 50, 58, 68, 80, 92, 106, 122,
+	140, 160, 182, 206, 234, 264, 298, 336,
+	378, 424, 476, 534, 600, 674, 760, 860,
+}
+
+// floorOrder specifies the indices of floorX in order of position.
+var floorOrder []int
+
+// floorLow and floorHigh specify the neighbouring points, in list order, used
+// to predict the amplitude of each floor point.
+var floorLow, floorHigh []int
+
+// inverseDB maps floor amplitudes to linear amplitudes, ranging from 140 dB
+// below full scale to 1.0.
+var inverseDB [256]float64
+
+func init() {
+	floorOrder = make([]int, len(floorX))
+	for i := range floorOrder {
+		floorOrder[i] = i
+	}
+	sort.Slice(floorOrder, func(i, j int) bool {
+		return floorX[floorOrder[i]] < floorX[floorOrder[j]]
+	})
+	floorLow = make([]int, len(floorX))
+	floorHigh = make([]int, len(floorX))
+	for i := 2; i < len(floorX); i++ {
+		lowX, highX := -1, 1<<floorRangeBits+1
+		for j := 0; j < i; j++ {
+			if x := floorX[j]; x < floorX[i] && x > lowX {
+				lowX = x
+				floorLow[i] = j
+			}
+			if x := floorX[j]; x > floorX[i] && x < highX {
+				highX = x
+				floorHigh[i] = j
+			}
+		}
+	}
+	for i := range inverseDB {
+		inverseDB[i] = inverseDBBase * math.Exp(float64(i)*inverseDBStep)
+	}
+}
+
+// Linear amplitude of floor amplitude 0.
+const inverseDBBase = 1.0649863e-07
+
+// inverseDBStep is the natural logarithm of the ratio between the linear
+// amplitudes of consecutive floor amplitudes.
+var inverseDBStep = -math.Log(inverseDBBase) / 255
+
+// A floor is the floor of a channel of a block.
+type floor struct {
+	// Specifies whether the channel is unused (silent).
+	unused bool
+	// Encoded amplitude values of each floor point, in list order; the first two
+	// values are amplitudes and the remaining values are differences from the
+	// predicted amplitudes.
+	vals []int
+	// Linear amplitude of the floor curve at each spectral coefficient.
+	curve []float64
+}
+
+// analyzeFloor computes the floor of the given spectral coefficients. The floor
+// amplitude at each point is based on the energy and peak amplitude of the
+// coefficients between the neighbouring points, such that the quantized
+// residue stays within the range of the residue codebooks.
+func analyzeFloor(coeffs []float64) *floor {
+	peak := 0.0
+	for _, c := range coeffs {
+		peak = math.Max(peak, math.Abs(c))
+	}
+	if peak < silence {
+		return &floor{unused: true}
+	}
+	// Amplitude of each floor point.
+	ys := make([]int, len(floorX))
+	for pos, i := range floorOrder {
+		start, end := 0, len(coeffs)
+		if pos > 0 {
+			start = floorX[floorOrder[pos-1]]
+		}
+		if pos+1 < len(floorOrder) {
+			end = floorX[floorOrder[pos+1]]
+		}
+		var sum, max float64
+		for _, c := range coeffs[start:end] {
+			sum += c * c
+			max = math.Max(max, math.Abs(c))
+		}
+		rms := math.Sqrt(sum / float64(end-start))
+		// Coarser quantization of higher frequencies.
+		tilt := 1 + float64(floorX[i])/float64(len(coeffs))
+		target := math.Max(rms*noise*tilt, max/peakResidue)
+		target = math.Max(target, math.Max(peak*dynamicRange, minFloor))
+		ys[i] = int(math.Ceil(math.Log(target/inverseDBBase) / inverseDBStep / floorMultiplier))
+		ys[i] = clamp(ys[i], 0, floorRange-1)
+	}
+	// Encode amplitudes as differences from the predicted amplitudes.
+	f := &floor{vals: make([]int, len(floorX))}
+	f.vals[0], f.vals[1] = ys[0], ys[1]
+	for i := 2; i < len(floorX); i++ {
+		low, high := floorLow[i], floorHigh[i]
+		pred := renderPoint(floorX[low], ys[low], floorX[high], ys[high], floorX[i])
+		if ys[i] == pred {
+			// The predicted amplitude is encoded as an unused point; raise the
+			// amplitude by one to keep the point.
+			if ys[i]+1 < floorRange {
+				ys[i]++
+			} else {
+				ys[i]--
+			}
+		}
+		for val := 1; val < floorRange; val++ {
+			if unpredict(val, pred) == ys[i] {
+				f.vals[i] = val
+				break
+			}
+		}
+	}
+	f.curve = renderFloor(ys, len(coeffs))
+	return f
+}
+
+// unpredict returns the floor amplitude of the given encoded value, based on the
+// predicted amplitude.
+func unpredict(val, pred int) int {
+	highroom := floorRange - pred
+	lowroom := pred
+	room := 2 * lowroom
+	if highroom < lowroom {
+		room = 2 * highroom
+	}
+	switch {
+	case val >= room:
+		if highroom > lowroom {
+			return val - lowroom + pred
+		}
+		return pred - val + highroom - 1
+	case val%2 == 1:
+		return pred - (val+1)/2
+	default:
+		return pred + val/2
+	}
+}
+
+// renderFloor returns the linear amplitudes of the floor curve through the given
+// floor point amplitudes, at each of the n spectral coefficients.
+func renderFloor(ys []int, n int) []float64 {
+	ints := make([]int, n)
+	lx, ly := 0, ys[floorOrder[0]]*floorMultiplier
+	for _, i := range floorOrder[1:] {
+		hx, hy := floorX[i], ys[i]*floorMultiplier
+		renderLine(lx, ly, hx, hy, ints)
+		lx, ly = hx, hy
+	}
+	curve := make([]float64, n)
+	for i, v := range ints {
+		curve[i] = inverseDB[v]
+	}
+	return curve
+}
+
+// renderPoint returns the amplitude at x of the line between (x0, y0) and (x1,
+// y1), as computed by Vorbis decoders.
+func renderPoint(x0, y0, x1, y1, x int) int {
+	dy := y1 - y0
+	adx := x1 - x0
+	ady := dy
+	if ady < 0 {
+		ady = -ady
+	}
+	off := ady * (x - x0) / adx
+	if dy < 0 {
+		return y0 - off
+	}
+	return y0 + off
+}
+
+// renderLine renders the line between (x0, y0) and (x1, y1) to v, excluding x1,
+// as computed by Vorbis decoders.
+func renderLine(x0, y0, x1, y1 int, v []int) {
+	dy := y1 - y0
+	adx := x1 - x0
+	ady := dy
+	if ady < 0 {
+		ady = -ady
+	}
+	base := dy / adx
+	sy := base + 1
+	if dy < 0 {
+		sy = base - 1
+	}
+	absBase := base
+	if absBase < 0 {
+		absBase = -absBase
+	}
+	ady -= absBase * adx
+	y, err := y0, 0
+	if x0 < len(v) {
+		v[x0] = y
+	}
+	for x := x0 + 1; x < x1 && x < len(v); x++ {
+		err += ady
+		if err >= adx {
+			err -= adx
+			y += sy
+		} else {
+			y += base
+		}
+		v[x] = y
+	}
+}
+
+// writeFloorHeader writes the floor configuration of the setup header, using
+// the given codebook for floor point amplitudes.
+func writeFloorHeader(bw *bitWriter, book int) {
+	bw.writeBits(1, 16) // floor type
+	bw.writeBits(floorPartitions, 5)
+	for i := 0; i < floorPartitions; i++ {
+		bw.writeBits(0, 4) // partition class
+	}
+	// Partition class 0.
+	bw.writeBits(floorPartitionDims-1, 3)
+	bw.writeBits(0, 2) // subclasses
+	bw.writeBits(uint32(book+1), 8)
+	bw.writeBits(floorMultiplier-1, 2)
+	bw.writeBits(floorRangeBits, 4)
+	for _, x := range floorX[2:] {
+		bw.writeBits(uint32(x), floorRangeBits)
+	}
+}
+
+// write writes the floor of a channel of an audio packet.
+func (f *floor) write(bw *bitWriter, book *codebook) {
+	bw.writeBool(!f.unused)
+	if f.unused {
+		return
+	}
+	bits := ilog(floorRange - 1)
+	bw.writeBits(uint32(f.vals[0]), bits)
+	bw.writeBits(uint32(f.vals[1]), bits)
+	for _, val := range f.vals[2:] {
+		book.write(bw, val)
+	}
+}
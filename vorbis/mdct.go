@@ -0,0 +1,98 @@
+package vorbis
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// An mdct computes the windowed forward MDCT of blocks of n samples, producing
+// n/2 spectral coefficients, as inverted by the IMDCT of Vorbis decoders.
+type mdct struct {
+	// Block size in samples.
+	n int
+	// Vorbis window of the block size.
+	window []float64
+	// Pre-twiddle factors; exp(-i*pi*k/n).
+	pre []complex128
+	// Post-twiddle factors; exp(-i*2*pi*(1/2+n/4)*(k+1/2)/n).
+	post []complex128
+	// Roots of unity of the FFT; exp(-i*2*pi*k/n).
+	roots []complex128
+	// Scratch buffer of the FFT.
+	buf []complex128
+}
+
+// newMDCT returns a new MDCT of the given block size, which must be a power of
+// two.
+func newMDCT(n int) *mdct {
+	t := &mdct{
+		n:      n,
+		window: make([]float64, n),
+		pre:    make([]complex128, n),
+		post:   make([]complex128, n/2),
+		roots:  make([]complex128, n/2),
+		buf:    make([]complex128, n),
+	}
+	for i := range t.window {
+		// Vorbis window of overlapping long blocks.
+		s := math.Sin((float64(i) + 0.5) / float64(n) * math.Pi)
+		t.window[i] = math.Sin(math.Pi / 2 * s * s)
+	}
+	for i := range t.pre {
+		t.pre[i] = cmplx.Exp(complex(0, -math.Pi*float64(i)/float64(n)))
+	}
+	n0 := 0.5 + float64(n)/4
+	for k := range t.post {
+		t.post[k] = cmplx.Exp(complex(0, -2*math.Pi*n0*(float64(k)+0.5)/float64(n)))
+	}
+	for i := range t.roots {
+		t.roots[i] = cmplx.Exp(complex(0, -2*math.Pi*float64(i)/float64(n)))
+	}
+	return t
+}
+
+// transform computes the spectral coefficients of the given block of n
+// samples, storing them in the first n/2 elements of out. The samples are
+// windowed before the transform.
+//
+//    X[k] = 4/n * sum(w[i]*x[i] * cos(2*pi/n * (i + 1/2 + n/4) * (k + 1/2)))
+func (t *mdct) transform(out, x []float64) {
+	for i, v := range x {
+		t.buf[i] = complex(t.window[i]*v, 0) * t.pre[i]
+	}
+	t.fft(t.buf)
+	scale := 4 / float64(t.n)
+	for k := 0; k < t.n/2; k++ {
+		out[k] = scale * real(t.buf[k]*t.post[k])
+	}
+}
+
+// fft computes the in-place discrete Fourier transform of x, of length n,
+// using the iterative radix-2 Cooley-Tukey algorithm.
+func (t *mdct) fft(x []complex128) {
+	n := len(x)
+	// Bit-reversal permutation.
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		half := size / 2
+		step := n / size
+		for start := 0; start < n; start += size {
+			for k := 0; k < half; k++ {
+				w := t.roots[k*step]
+				a := x[start+k]
+				b := x[start+k+half] * w
+				x[start+k] = a + b
+				x[start+k+half] = a - b
+			}
+		}
+	}
+}
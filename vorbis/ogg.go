@@ -0,0 +1,132 @@
+package vorbis
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Ogg page header flags.
+const (
+	// The first packet of the page continues a packet of the previous page.
+	pageContinued = 0x01
+	// First page of the logical bitstream.
+	pageBOS = 0x02
+	// Last page of the logical bitstream.
+	pageEOS = 0x04
+)
+
+// Maximum number of lacing values of an Ogg page.
+const maxSegments = 255
+
+// An oggWriter writes packets of a logical bitstream to Ogg pages.
+type oggWriter struct {
+	// Underlying writer.
+	w io.Writer
+	// Serial number of the logical bitstream.
+	serial uint32
+	// Sequence number of the next page.
+	seq uint32
+	// Lacing values of the pending page.
+	segments []byte
+	// Packet data of the pending page.
+	data []byte
+	// Granule position of the last packet completed on the pending page; -1 if
+	// none.
+	granule int64
+	// Specifies whether the pending page starts with a continued packet.
+	continued bool
+}
+
+// newOggWriter returns a new Ogg page writer of a logical bitstream with the
+// given serial number.
+func newOggWriter(w io.Writer, serial uint32) *oggWriter {
+	return &oggWriter{w: w, serial: serial, granule: -1}
+}
+
+// writePacket adds the given packet, ending at the given granule position, to
+// the pending page. Full pages are written as the packet is added.
+func (ow *oggWriter) writePacket(packet []byte, granule int64) error {
+	for {
+		if len(ow.segments) == maxSegments {
+			if err := ow.flush(false); err != nil {
+				return errors.WithStack(err)
+			}
+			ow.continued = true
+		}
+		if len(packet) >= 255 {
+			ow.segments = append(ow.segments, 255)
+			ow.data = append(ow.data, packet[:255]...)
+			packet = packet[255:]
+			continue
+		}
+		// A lacing value below 255 ends the packet.
+		ow.segments = append(ow.segments, byte(len(packet)))
+		ow.data = append(ow.data, packet...)
+		ow.granule = granule
+		return nil
+	}
+}
+
+// flush writes the pending page, marking the end of the logical bitstream if
+// eos is set.
+func (ow *oggWriter) flush(eos bool) error {
+	var flags byte
+	if ow.continued {
+		flags |= pageContinued
+	}
+	if ow.seq == 0 {
+		flags |= pageBOS
+	}
+	if eos {
+		flags |= pageEOS
+	}
+	page := make([]byte, 27, 27+len(ow.segments)+len(ow.data))
+	copy(page, "OggS")
+	page[4] = 0 // version
+	page[5] = flags
+	binary.LittleEndian.PutUint64(page[6:], uint64(ow.granule))
+	binary.LittleEndian.PutUint32(page[14:], ow.serial)
+	binary.LittleEndian.PutUint32(page[18:], ow.seq)
+	page[26] = byte(len(ow.segments))
+	page = append(page, ow.segments...)
+	page = append(page, ow.data...)
+	binary.LittleEndian.PutUint32(page[22:], crc(page))
+	if _, err := ow.w.Write(page); err != nil {
+		return errors.WithStack(err)
+	}
+	ow.seq++
+	ow.segments = ow.segments[:0]
+	ow.data = ow.data[:0]
+	ow.granule = -1
+	ow.continued = false
+	return nil
+}
+
+// crcTable is the lookup table of the Ogg page checksum.
+var crcTable [256]uint32
+
+func init() {
+	// CRC-32 of polynomial 0x04C11DB7, most significant bit first.
+	for i := range crcTable {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04C11DB7
+			} else {
+				r <<= 1
+			}
+		}
+		crcTable[i] = r
+	}
+}
+
+// crc returns the checksum of the given Ogg page, with a zero checksum field.
+func crc(page []byte) uint32 {
+	var c uint32
+	for _, b := range page {
+		c = c<<8 ^ crcTable[byte(c>>24)^b]
+	}
+	return c
+}
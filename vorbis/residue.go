@@ -0,0 +1,177 @@
+package vorbis
+
+import (
+	"math"
+)
+
+// Residue configuration (residue type 1). The residue of each channel is the
+// spectrum divided by the floor curve, quantized to integers and coded in
+// partitions of 16 coefficients. Each partition is classified by its largest
+// quantized value:
+//
+//    class 0: all values are zero; no codewords.
+//    class 1: values within [-4, 4]; one pass of the fine codebook.
+//    class 2: values within [-40, 40]; one pass of the coarse codebook (steps of
+//             9) and one pass of the fine codebook.
+const (
+	// Number of spectral coefficients of each residue partition.
+	residuePartitionSize = 16
+	// Number of residue partition classes.
+	residueClasses = 3
+	// Largest quantized residue value of the fine codebook.
+	maxFine = 4
+	// Largest quantized residue value.
+	maxResidue = 40
+	// Largest quantized residue value targeted by the floor, leaving room for
+	// the floor amplitude steps between floor points.
+	peakResidue = 36
+)
+
+// A residue is the quantized residue of a channel of a block.
+type residue struct {
+	// Quantized residue values of each spectral coefficient.
+	vals []int
+	// Class of each residue partition.
+	classes []int
+}
+
+// quantizeResidue returns the quantized residue of the given spectral
+// coefficients, divided by the floor curve.
+func quantizeResidue(coeffs, curve []float64) *residue {
+	r := &residue{
+		vals:    make([]int, len(coeffs)),
+		classes: make([]int, len(coeffs)/residuePartitionSize),
+	}
+	for i, c := range coeffs {
+		r.vals[i] = clamp(int(math.Floor(c/curve[i]+0.5)), -maxResidue, maxResidue)
+	}
+	for p := range r.classes {
+		max := 0
+		for _, v := range r.vals[p*residuePartitionSize : (p+1)*residuePartitionSize] {
+			if v < 0 {
+				v = -v
+			}
+			if v > max {
+				max = v
+			}
+		}
+		switch {
+		case max == 0:
+			r.classes[p] = 0
+		case max <= maxFine:
+			r.classes[p] = 1
+		default:
+			r.classes[p] = 2
+		}
+	}
+	return r
+}
+
+// residueBooks specifies the codebooks of the residue configuration.
+type residueBooks struct {
+	// Codebook of partition classes, coding two classes per codeword.
+	class *codebook
+	// Codebook of pairs of values within [-4, 4].
+	fine *codebook
+	// Codebook of pairs of values within [-36, 36] in steps of 9.
+	coarse *codebook
+}
+
+// newResidueBooks returns new codebooks of the residue configuration.
+func newResidueBooks() *residueBooks {
+	return &residueBooks{
+		class:  newCodebook(residueClasses*residueClasses, 2),
+		fine:   newLatticeCodebook(2*maxFine+1, 2, -maxFine, 1),
+		coarse: newLatticeCodebook(2*maxFine+1, 2, -maxFine*(2*maxFine+1), 2*maxFine+1),
+	}
+}
+
+// writeResidueHeader writes the residue configuration of the setup header,
+// using the given codebook numbers of the class, fine and coarse codebooks.
+func writeResidueHeader(bw *bitWriter, n, class, fine, coarse int) {
+	bw.writeBits(1, 16) // residue type
+	bw.writeBits(0, 24) // begin
+	bw.writeBits(uint32(n), 24)
+	bw.writeBits(residuePartitionSize-1, 24)
+	bw.writeBits(residueClasses-1, 6)
+	bw.writeBits(uint32(class), 8)
+	// Cascade of codebook passes of each class.
+	cascades := []uint32{0x0, 0x1, 0x3}
+	for _, cascade := range cascades {
+		bw.writeBits(cascade, 3)
+		bw.writeBool(false)
+	}
+	// Class 1: fine.
+	bw.writeBits(uint32(fine), 8)
+	// Class 2: coarse, fine.
+	bw.writeBits(uint32(coarse), 8)
+	bw.writeBits(uint32(fine), 8)
+}
+
+// writeResidues writes the residues of the used channels of an audio packet.
+// Codewords are written in the order read by decoders; pass by pass, partition
+// by partition, interleaving channels.
+func writeResidues(bw *bitWriter, floors []*floor, residues []*residue, books *residueBooks) {
+	nparts := 0
+	for ch, f := range floors {
+		if !f.unused {
+			nparts = len(residues[ch].classes)
+		}
+	}
+	if nparts == 0 {
+		// All channels unused.
+		return
+	}
+	// Codebooks of each pass of each class.
+	passes := [residueClasses][]*codebook{
+		nil,
+		{books.fine},
+		{books.coarse, books.fine},
+	}
+	for pass := 0; pass < 2; pass++ {
+		for p := 0; p < nparts; p += 2 {
+			if pass == 0 {
+				for ch, f := range floors {
+					if f.unused {
+						continue
+					}
+					classes := residues[ch].classes
+					books.class.write(bw, classes[p]*residueClasses+classes[p+1])
+				}
+			}
+			for i := p; i < p+2; i++ {
+				for ch, f := range floors {
+					if f.unused {
+						continue
+					}
+					r := residues[ch]
+					class := r.classes[i]
+					if pass >= len(passes[class]) {
+						continue
+					}
+					book := passes[class][pass]
+					vals := r.vals[i*residuePartitionSize : (i+1)*residuePartitionSize]
+					for j := 0; j < len(vals); j += 2 {
+						a, b := vals[j], vals[j+1]
+						if book == books.coarse {
+							a, b = coarse(a), coarse(b)
+						} else if class == 2 {
+							a, b = a-(2*maxFine+1)*coarse(a), b-(2*maxFine+1)*coarse(b)
+						}
+						book.write(bw, (a+maxFine)+(2*maxFine+1)*(b+maxFine))
+					}
+				}
+			}
+		}
+	}
+}
+
+// coarse returns the coarse part of the given quantized residue value, in steps
+// of 9.
+func coarse(v int) int {
+	const step = 2*maxFine + 1
+	if v < 0 {
+		return -((-v + maxFine) / step)
+	}
+	return (v + maxFine) / step
+}
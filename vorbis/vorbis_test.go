@@ -0,0 +1,105 @@
+package vorbis_test
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/jfreymuth/oggvorbis"
+	"github.com/sanctuary/ember/vorbis"
+)
+
+func TestEncode(t *testing.T) {
+	golden := []struct {
+		// Number of channels.
+		nchannels int
+		// Sample rate in Hz.
+		sampleRate int
+		// Number of sample frames.
+		nframes int
+		// Lowest signal-to-noise ratio of the decoded samples, in dB.
+		minSNR float64
+	}{
+		{nchannels: 1, sampleRate: 22050, nframes: 22050, minSNR: 20},
+		{nchannels: 2, sampleRate: 22050, nframes: 10000, minSNR: 20},
+		{nchannels: 1, sampleRate: 11025, nframes: 1000, minSNR: 20},
+		// Shorter than a block.
+		{nchannels: 2, sampleRate: 22050, nframes: 100, minSNR: 20},
+		// Empty sound.
+		{nchannels: 1, sampleRate: 22050, nframes: 0},
+		{nchannels: 2, sampleRate: 22050, nframes: 0},
+	}
+	for _, g := range golden {
+		samples := tone(g.nchannels, g.sampleRate, g.nframes)
+		buf := &bytes.Buffer{}
+		comments := []string{"TITLE=Tristram"}
+		if err := vorbis.Encode(buf, samples, g.nchannels, g.sampleRate, comments); err != nil {
+			t.Errorf("%d channels, %d frames: %+v", g.nchannels, g.nframes, err)
+			continue
+		}
+		got, format, err := oggvorbis.ReadAll(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Errorf("%d channels, %d frames: unable to decode Ogg Vorbis stream; %+v", g.nchannels, g.nframes, err)
+			continue
+		}
+		if format.Channels != g.nchannels {
+			t.Errorf("%d channels, %d frames: expected %d channels, got %d", g.nchannels, g.nframes, g.nchannels, format.Channels)
+		}
+		if format.SampleRate != g.sampleRate {
+			t.Errorf("%d channels, %d frames: expected sample rate %d, got %d", g.nchannels, g.nframes, g.sampleRate, format.SampleRate)
+		}
+		if len(samples) == 0 {
+			// An empty sound is encoded as a single silent sample frame.
+			if want := make([]float32, g.nchannels); !reflect.DeepEqual(got, want) {
+				t.Errorf("%d channels, %d frames: expected samples %v, got %v", g.nchannels, g.nframes, want, got)
+			}
+			continue
+		}
+		if len(got) != len(samples) {
+			t.Errorf("%d channels, %d frames: expected %d samples, got %d", g.nchannels, g.nframes, len(samples), len(got))
+			continue
+		}
+		snr := signalToNoise(samples, got)
+		if snr < g.minSNR {
+			t.Errorf("%d channels, %d frames: expected signal-to-noise ratio >= %.1f dB, got %.1f dB", g.nchannels, g.nframes, g.minSNR, snr)
+		}
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	if err := vorbis.Encode(&bytes.Buffer{}, nil, 0, 22050, nil); err == nil {
+		t.Errorf("0 channels: expected error, got nil")
+	}
+	if err := vorbis.Encode(&bytes.Buffer{}, nil, 1, 0, nil); err == nil {
+		t.Errorf("sample rate 0: expected error, got nil")
+	}
+}
+
+// tone returns a sound of the given number of sample frames, holding a chord of
+// sine waves with a different pitch in each channel.
+func tone(nchannels, sampleRate, nframes int) []int16 {
+	samples := make([]int16, nchannels*nframes)
+	for i := 0; i < nframes; i++ {
+		t := float64(i) / float64(sampleRate)
+		for ch := 0; ch < nchannels; ch++ {
+			freq := 220 * float64(ch+1)
+			v := 0.4*math.Sin(2*math.Pi*freq*t) + 0.2*math.Sin(2*math.Pi*1.5*freq*t) + 0.1*math.Sin(2*math.Pi*4*freq*t)
+			samples[i*nchannels+ch] = int16(v * 32767)
+		}
+	}
+	return samples
+}
+
+// signalToNoise returns the signal-to-noise ratio in dB of the decoded samples,
+// compared to the original signed 16-bit PCM samples.
+func signalToNoise(want []int16, got []float32) float64 {
+	var signal, noise float64
+	for i, v := range want {
+		x := float64(v) / 32768
+		d := float64(got[i]) - x
+		signal += x * x
+		noise += d * d
+	}
+	return 10 * math.Log10(signal/noise)
+}
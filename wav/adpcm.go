@@ -0,0 +1,108 @@
+package wav
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// imaIndexTable specifies the step index adjustment of each 4-bit IMA ADPCM
+// code, ignoring the sign bit.
+var imaIndexTable = [8]int{-1, -1, -1, -1, 2, 4, 6, 8}
+
+// imaStepTable specifies the quantizer step size of each IMA ADPCM step index.
+var imaStepTable = [89]int{
+	7, 8, 9, 10, 11, 12, 13, 14, 16, 17,
+	19, 21, 23, 25, 28, 31, 34, 37, 41, 45,
+	50, 55, 60, 66, 73, 80, 88, 97, 107, 118,
+	130, 143, 157, 173, 190, 209, 230, 253, 279, 307,
+	337, 371, 408, 449, 494, 544, 598, 658, 724, 796,
+	876, 963, 1060, 1166, 1282, 1411, 1552, 1707, 1878, 2066,
+	2272, 2499, 2749, 3024, 3327, 3660, 4026, 4428, 4871, 5358,
+	5894, 6484, 7132, 7845, 8630, 9493, 10442, 11487, 12635, 13899,
+	15289, 16818, 18500, 20350, 22385, 24623, 27086, 29794, 32767,
+}
+
+// imaDecoder is the decoder state of an IMA ADPCM channel.
+type imaDecoder struct {
+	// Predicted sample.
+	pred int
+	// Step index.
+	index int
+}
+
+// decode decodes the given 4-bit IMA ADPCM code.
+func (d *imaDecoder) decode(code byte) int16 {
+	step := imaStepTable[d.index]
+	diff := step >> 3
+	if code&1 != 0 {
+		diff += step >> 2
+	}
+	if code&2 != 0 {
+		diff += step >> 1
+	}
+	if code&4 != 0 {
+		diff += step
+	}
+	if code&8 != 0 {
+		diff = -diff
+	}
+	d.pred = clamp(d.pred+diff, -32768, 32767)
+	d.index = clamp(d.index+imaIndexTable[code&7], 0, len(imaStepTable)-1)
+	return int16(d.pred)
+}
+
+// decodeIMAADPCM decodes the given IMA ADPCM samples, stored in blocks of
+// blockAlign bytes.
+//
+// Each block starts with a 4-byte header for each channel, holding the first
+// sample and step index of the channel. The header is followed by 4-bit codes,
+// interleaved by channel in groups of 4 bytes (8 samples); the low nibble of
+// each byte holds the first sample.
+func decodeIMAADPCM(data []byte, nchannels, blockAlign int) ([]int16, error) {
+	headerSize := 4 * nchannels
+	if blockAlign <= headerSize || (blockAlign-headerSize)%headerSize != 0 {
+		return nil, errors.Errorf("invalid IMA ADPCM block size %d of %d channels", blockAlign, nchannels)
+	}
+	var samples []int16
+	decoders := make([]imaDecoder, nchannels)
+	for len(data) > headerSize {
+		block := data
+		if len(block) > blockAlign {
+			block = block[:blockAlign]
+		}
+		data = data[len(block):]
+		// Parse block header.
+		for ch := range decoders {
+			header := block[4*ch:]
+			decoders[ch].pred = int(int16(binary.LittleEndian.Uint16(header)))
+			decoders[ch].index = clamp(int(header[2]), 0, len(imaStepTable)-1)
+			samples = append(samples, int16(decoders[ch].pred))
+		}
+		// Decode block samples, 8 samples of each channel at the time.
+		body := block[headerSize:]
+		for len(body) >= headerSize {
+			frames := make([]int16, 8*nchannels)
+			for ch := range decoders {
+				for i, b := range body[4*ch : 4*ch+4] {
+					frames[(2*i)*nchannels+ch] = decoders[ch].decode(b & 0x0F)
+					frames[(2*i+1)*nchannels+ch] = decoders[ch].decode(b >> 4)
+				}
+			}
+			samples = append(samples, frames...)
+			body = body[headerSize:]
+		}
+	}
+	return samples, nil
+}
+
+// clamp returns x clamped to the range [min, max].
+func clamp(x, min, max int) int {
+	switch {
+	case x < min:
+		return min
+	case x > max:
+		return max
+	}
+	return x
+}
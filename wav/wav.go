@@ -0,0 +1,181 @@
+// Package wav decodes the WAV sound files of Diablo 1 (e.g. music and sound
+// effects), stored either as uncompressed PCM or as IMA ADPCM.
+//
+// The sounds are decoded in memory to 16-bit PCM samples, which may be encoded
+// to other formats (e.g. Ogg Vorbis).
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// Audio formats of WAV files.
+const (
+	// Uncompressed PCM.
+	formatPCM = 0x0001
+	// IMA ADPCM.
+	formatIMAADPCM = 0x0011
+)
+
+// A Sound is a decoded WAV sound.
+type Sound struct {
+	// Sample rate in Hz (e.g. 22050).
+	SampleRate int
+	// Number of channels (e.g. 1 for mono and 2 for stereo).
+	NChannels int
+	// Samples as signed 16-bit PCM, interleaved by channel.
+	Samples []int16
+}
+
+// NFrames returns the number of sample frames of the sound; i.e. the number of
+// samples of each channel.
+func (snd *Sound) NFrames() int {
+	return len(snd.Samples) / snd.NChannels
+}
+
+// format is the contents of the "fmt " chunk of a WAV file.
+type format struct {
+	// Audio format (e.g. formatPCM).
+	AudioFormat uint16
+	// Number of channels.
+	NChannels uint16
+	// Sample rate in Hz.
+	SampleRate uint32
+	// Average number of bytes per second.
+	ByteRate uint32
+	// Size in bytes of each block of samples.
+	BlockAlign uint16
+	// Number of bits per sample.
+	BitsPerSample uint16
+}
+
+// Decode decodes the WAV sound read from r.
+func Decode(r io.Reader) (*Sound, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return DecodeBytes(buf)
+}
+
+// DecodeBytes decodes the given WAV sound.
+func DecodeBytes(buf []byte) (*Sound, error) {
+	if len(buf) < 12 || string(buf[0:4]) != "RIFF" || string(buf[8:12]) != "WAVE" {
+		return nil, errors.New("invalid WAV header; expected RIFF WAVE")
+	}
+	var (
+		fmtChunk []byte
+		data     []byte
+		// Number of sample frames given by the "fact" chunk; -1 if not present.
+		nframes = -1
+	)
+	for _, c := range chunks(buf[12:]) {
+		switch c.id {
+		case "fmt ":
+			fmtChunk = c.data
+		case "data":
+			data = c.data
+		case "fact":
+			if len(c.data) >= 4 {
+				nframes = int(binary.LittleEndian.Uint32(c.data))
+			}
+		}
+	}
+	if fmtChunk == nil {
+		return nil, errors.New(`unable to locate "fmt " chunk`)
+	}
+	if data == nil {
+		return nil, errors.New(`unable to locate "data" chunk`)
+	}
+	var f format
+	if err := binary.Read(bytes.NewReader(fmtChunk), binary.LittleEndian, &f); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if f.NChannels == 0 {
+		return nil, errors.New("invalid number of channels; expected > 0, got 0")
+	}
+	snd := &Sound{
+		SampleRate: int(f.SampleRate),
+		NChannels:  int(f.NChannels),
+	}
+	switch f.AudioFormat {
+	case formatPCM:
+		samples, err := decodePCM(data, int(f.BitsPerSample))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		snd.Samples = samples
+	case formatIMAADPCM:
+		samples, err := decodeIMAADPCM(data, int(f.NChannels), int(f.BlockAlign))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		snd.Samples = samples
+	default:
+		return nil, errors.Errorf("support for audio format 0x%04X not yet implemented", f.AudioFormat)
+	}
+	// Drop incomplete sample frame, and the padding of the final block of
+	// compressed sounds, as given by the "fact" chunk.
+	n := snd.NFrames()
+	if f.AudioFormat != formatPCM && nframes >= 0 && nframes < n {
+		n = nframes
+	}
+	snd.Samples = snd.Samples[:n*snd.NChannels]
+	return snd, nil
+}
+
+// A chunk is a chunk of a RIFF file.
+type chunk struct {
+	// Chunk ID (e.g. "fmt ").
+	id string
+	// Chunk contents.
+	data []byte
+}
+
+// chunks returns the chunks of the given RIFF contents. A truncated final chunk
+// is cut short.
+func chunks(buf []byte) []chunk {
+	var cs []chunk
+	for len(buf) >= 8 {
+		id := string(buf[0:4])
+		size := int(binary.LittleEndian.Uint32(buf[4:8]))
+		buf = buf[8:]
+		if size > len(buf) {
+			size = len(buf)
+		}
+		cs = append(cs, chunk{id: id, data: buf[:size]})
+		// Chunks are padded to even size.
+		if size%2 == 1 && size < len(buf) {
+			size++
+		}
+		buf = buf[size:]
+	}
+	return cs
+}
+
+// decodePCM decodes the given uncompressed PCM samples of the specified number
+// of bits per sample.
+func decodePCM(data []byte, bitsPerSample int) ([]int16, error) {
+	switch bitsPerSample {
+	case 8:
+		// 8-bit samples are unsigned.
+		samples := make([]int16, len(data))
+		for i, b := range data {
+			samples[i] = int16(int(b)-0x80) << 8
+		}
+		return samples, nil
+	case 16:
+		samples := make([]int16, len(data)/2)
+		for i := range samples {
+			samples[i] = int16(binary.LittleEndian.Uint16(data[2*i:]))
+		}
+		return samples, nil
+	default:
+		return nil, errors.Errorf("support for %d-bit PCM samples not yet implemented", bitsPerSample)
+	}
+}
@@ -0,0 +1,217 @@
+package wav_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/sanctuary/ember/wav"
+)
+
+func TestDecode(t *testing.T) {
+	// IMA ADPCM block of a mono sound, holding the first sample 0 with step
+	// index 0, followed by 8 samples.
+	monoBlock := []byte{
+		0x00, 0x00, 0x00, 0x00, // header
+		0x07, 0x8F, 0x42, 0x00, // codes
+	}
+	monoSamples := []int16{0, 11, 13, -12, -15, 2, 30, 33, 36}
+	// IMA ADPCM block of a mono sound, holding the first sample 32760 with
+	// step index 88; the decoded samples are clamped to the range of 16-bit
+	// samples.
+	clampBlock := []byte{
+		0xF8, 0x7F, 0x58, 0x00, // header
+		0xF7, 0x00, 0x00, 0x00, // codes
+	}
+	clampSamples := []int16{32760, 32767, -28669, -24574, -20850, -17465, -14388, -11590, -9047}
+	// IMA ADPCM block of a stereo sound, holding the first samples 100 and
+	// -100 of the left and right channel, followed by 8 samples of each
+	// channel.
+	stereoBlock := []byte{
+		0x64, 0x00, 0x00, 0x00, // header of left channel
+		0x9C, 0xFF, 0x00, 0x00, // header of right channel
+		0x00, 0x00, 0x00, 0x00, // codes of left channel
+		0x11, 0x11, 0x11, 0x11, // codes of right channel
+	}
+	stereoSamples := []int16{
+		100, -100,
+		100, -99, 100, -98, 100, -97, 100, -96,
+		100, -95, 100, -94, 100, -93, 100, -92,
+	}
+	golden := []struct {
+		name string
+		buf  []byte
+		want *wav.Sound
+	}{
+		// Uncompressed PCM.
+		{
+			name: "pcm_8bit",
+			buf: riff(
+				fmtChunk(0x0001, 1, 11025, 1, 8),
+				riffChunk("data", []byte{0x80, 0xFF, 0x00, 0x81}),
+			),
+			want: &wav.Sound{SampleRate: 11025, NChannels: 1, Samples: []int16{0, 32512, -32768, 256}},
+		},
+		{
+			name: "pcm_16bit",
+			buf: riff(
+				fmtChunk(0x0001, 2, 22050, 4, 16),
+				// Chunks are padded to even size.
+				riffChunk("LIST", []byte{1, 2, 3}),
+				riffChunk("data", le16(0, 1, -1, 32767, -32768, 1000)),
+			),
+			want: &wav.Sound{SampleRate: 22050, NChannels: 2, Samples: []int16{0, 1, -1, 32767, -32768, 1000}},
+		},
+		{
+			name: "pcm_16bit_incomplete_frame",
+			buf: riff(
+				fmtChunk(0x0001, 2, 22050, 4, 16),
+				riffChunk("data", append(le16(1, 2, 3, 4, 5), 0x06)),
+			),
+			want: &wav.Sound{SampleRate: 22050, NChannels: 2, Samples: []int16{1, 2, 3, 4}},
+		},
+		{
+			// The "fact" chunk of uncompressed sounds is ignored.
+			name: "pcm_16bit_fact",
+			buf: riff(
+				fmtChunk(0x0001, 1, 22050, 2, 16),
+				riffChunk("fact", le32(1)),
+				riffChunk("data", le16(1, 2, 3)),
+			),
+			want: &wav.Sound{SampleRate: 22050, NChannels: 1, Samples: []int16{1, 2, 3}},
+		},
+		// IMA ADPCM.
+		{
+			name: "ima_adpcm_mono",
+			buf: riff(
+				fmtChunk(0x0011, 1, 22050, 8, 4),
+				riffChunk("data", cat(monoBlock, clampBlock)),
+			),
+			want: &wav.Sound{SampleRate: 22050, NChannels: 1, Samples: append(append([]int16{}, monoSamples...), clampSamples...)},
+		},
+		{
+			name: "ima_adpcm_stereo",
+			buf: riff(
+				fmtChunk(0x0011, 2, 44100, 16, 4),
+				riffChunk("data", stereoBlock),
+			),
+			want: &wav.Sound{SampleRate: 44100, NChannels: 2, Samples: stereoSamples},
+		},
+		{
+			// The padding of the final block is trimmed, as given by the
+			// "fact" chunk.
+			name: "ima_adpcm_fact",
+			buf: riff(
+				fmtChunk(0x0011, 1, 22050, 8, 4),
+				riffChunk("fact", le32(12)),
+				riffChunk("data", cat(monoBlock, clampBlock)),
+			),
+			want: &wav.Sound{SampleRate: 22050, NChannels: 1, Samples: append(append([]int16{}, monoSamples...), clampSamples[:3]...)},
+		},
+		{
+			// A "fact" chunk beyond the decoded samples is ignored.
+			name: "ima_adpcm_fact_too_long",
+			buf: riff(
+				fmtChunk(0x0011, 2, 44100, 16, 4),
+				riffChunk("fact", le32(100)),
+				riffChunk("data", stereoBlock),
+			),
+			want: &wav.Sound{SampleRate: 44100, NChannels: 2, Samples: stereoSamples},
+		},
+		{
+			// Truncated final block.
+			name: "ima_adpcm_truncated",
+			buf: riff(
+				fmtChunk(0x0011, 1, 22050, 8, 4),
+				riffChunk("data", cat(monoBlock, clampBlock[:4])),
+			),
+			want: &wav.Sound{SampleRate: 22050, NChannels: 1, Samples: monoSamples},
+		},
+	}
+	for _, g := range golden {
+		got, err := wav.Decode(bytes.NewReader(g.buf))
+		if err != nil {
+			t.Errorf("%q: %+v", g.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, g.want) {
+			t.Errorf("%q: sound mismatch; expected %+v, got %+v", g.name, g.want, got)
+		}
+		if want := len(g.want.Samples) / g.want.NChannels; got.NFrames() != want {
+			t.Errorf("%q: expected %d sample frames, got %d", g.name, want, got.NFrames())
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	golden := []struct {
+		name string
+		buf  []byte
+	}{
+		{name: "empty", buf: nil},
+		{name: "not_wave", buf: []byte("RIFF\x04\x00\x00\x00AVI ")},
+		{name: "missing_fmt", buf: riff(riffChunk("data", le16(1)))},
+		{name: "missing_data", buf: riff(fmtChunk(0x0001, 1, 22050, 2, 16))},
+		{name: "no_channels", buf: riff(fmtChunk(0x0001, 0, 22050, 2, 16), riffChunk("data", le16(1)))},
+		{name: "24bit_pcm", buf: riff(fmtChunk(0x0001, 1, 22050, 3, 24), riffChunk("data", []byte{1, 2, 3}))},
+		{name: "ms_adpcm", buf: riff(fmtChunk(0x0002, 1, 22050, 256, 4), riffChunk("data", make([]byte, 256)))},
+		{name: "ima_adpcm_block_size", buf: riff(fmtChunk(0x0011, 1, 22050, 6, 4), riffChunk("data", make([]byte, 6)))},
+	}
+	for _, g := range golden {
+		if _, err := wav.DecodeBytes(g.buf); err == nil {
+			t.Errorf("%q: expected error, got nil", g.name)
+		}
+	}
+}
+
+// riff returns a RIFF WAVE file holding the given chunks.
+func riff(chunks ...[]byte) []byte {
+	body := cat(append([][]byte{[]byte("WAVE")}, chunks...)...)
+	return riffChunk("RIFF", body)
+}
+
+// riffChunk returns a RIFF chunk of the given chunk ID and contents, padded to
+// even size.
+func riffChunk(id string, data []byte) []byte {
+	buf := cat([]byte(id), le32(uint32(len(data))), data)
+	if len(data)%2 == 1 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// fmtChunk returns a "fmt " chunk of the given audio format.
+func fmtChunk(audioFormat, nchannels uint16, sampleRate uint32, blockAlign, bitsPerSample uint16) []byte {
+	buf := &bytes.Buffer{}
+	byteRate := sampleRate * uint32(blockAlign)
+	for _, v := range []interface{}{audioFormat, nchannels, sampleRate, byteRate, blockAlign, bitsPerSample} {
+		binary.Write(buf, binary.LittleEndian, v)
+	}
+	return riffChunk("fmt ", buf.Bytes())
+}
+
+// le16 returns the given 16-bit samples in little-endian byte order.
+func le16(samples ...int16) []byte {
+	buf := make([]byte, 2*len(samples))
+	for i, v := range samples {
+		binary.LittleEndian.PutUint16(buf[2*i:], uint16(v))
+	}
+	return buf
+}
+
+// le32 returns the given 32-bit value in little-endian byte order.
+func le32(v uint32) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, v)
+	return buf
+}
+
+// cat returns the concatenation of the given byte slices.
+func cat(bufs ...[]byte) []byte {
+	var buf []byte
+	for _, b := range bufs {
+		buf = append(buf, b...)
+	}
+	return buf
+}